@@ -0,0 +1,109 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"maunium.net/go/mautrix/crypto/attachment"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// GetMessage fetches a single event and decrypts it if necessary.
+func (bot *Bot) GetMessage(ctx context.Context, roomID id.RoomID, eventID id.EventID) (*event.Event, error) {
+	evt, err := bot.Client.GetEvent(ctx, roomID, eventID)
+	if err != nil {
+		return nil, err
+	}
+	err = evt.Content.ParseRaw(evt.Type)
+	if err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+		return nil, fmt.Errorf("failed to parse event content: %w", err)
+	}
+	if evt.Type == event.EventEncrypted {
+		if bot.CryptoHelper == nil {
+			return nil, fmt.Errorf("event is encrypted, but encryption is not enabled")
+		}
+		evt, err = bot.CryptoHelper.Decrypt(ctx, evt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt event: %w", err)
+		}
+		err = evt.Content.ParseRaw(evt.Type)
+		if err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+			return nil, fmt.Errorf("failed to parse decrypted event content: %w", err)
+		}
+	}
+	return evt, nil
+}
+
+// DownloadMedia downloads the file attached to the given message, decrypting it if necessary.
+func (bot *Bot) DownloadMedia(ctx context.Context, content *event.MessageEventContent) ([]byte, error) {
+	if content.File != nil {
+		mxc, err := content.File.URL.Parse()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse file URL: %w", err)
+		}
+		err = content.File.PrepareForDecryption()
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare file for decryption: %w", err)
+		}
+		data, err := bot.Client.DownloadBytes(ctx, mxc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download file: %w", err)
+		}
+		err = content.File.DecryptInPlace(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt file: %w", err)
+		}
+		return data, nil
+	} else if content.URL != "" {
+		mxc, err := content.URL.Parse()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse file URL: %w", err)
+		}
+		data, err := bot.Client.DownloadBytes(ctx, mxc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download file: %w", err)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("message doesn't have a file")
+}
+
+// SendFile uploads the given data and sends it to the given room as a file message.
+// The file is encrypted if the room is encrypted.
+func (bot *Bot) SendFile(ctx context.Context, roomID id.RoomID, data []byte, fileName, mimeType string) error {
+	content := &event.MessageEventContent{
+		MsgType:  event.MsgFile,
+		Body:     fileName,
+		FileName: fileName,
+		Info: &event.FileInfo{
+			MimeType: mimeType,
+			Size:     len(data),
+		},
+	}
+	uploadMimeType := mimeType
+	encrypted, err := bot.StateStore.IsEncrypted(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("failed to check if room is encrypted: %w", err)
+	} else if encrypted {
+		file := attachment.NewEncryptedFile()
+		data = file.Encrypt(data)
+		uploadMimeType = "application/octet-stream"
+		content.File = &event.EncryptedFileInfo{EncryptedFile: *file}
+	}
+	resp, err := bot.Client.UploadBytes(ctx, data, uploadMimeType)
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	if content.File != nil {
+		content.File.URL = resp.ContentURI.CUString()
+	} else {
+		content.URL = resp.ContentURI.CUString()
+	}
+	_, err = bot.Client.SendMessageEvent(ctx, roomID, event.EventMessage, content)
+	if err != nil {
+		return fmt.Errorf("failed to send file message: %w", err)
+	}
+	return nil
+}
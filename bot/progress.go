@@ -0,0 +1,114 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const DefaultProgressInterval = 5 * time.Second
+
+// Progress reports the progress of a long-running operation using a single message,
+// which is edited as the operation progresses. Details about individual items are sent to a thread under the message.
+//
+// All methods are safe to call on a nil Progress, which makes it easy to only report progress for large operations.
+type Progress struct {
+	bot     *Bot
+	roomID  id.RoomID
+	eventID id.EventID
+	title   string
+	total   int
+	start   time.Time
+
+	done   atomic.Int64
+	failed atomic.Int64
+
+	editLock sync.Mutex
+	lastEdit time.Time
+	finished bool
+
+	// Interval is the minimum time between progress edits.
+	Interval time.Duration
+}
+
+// StartProgress sends a progress message to the given room and returns a Progress that can be used to update it.
+func (bot *Bot) StartProgress(ctx context.Context, roomID id.RoomID, title string, total int) *Progress {
+	p := &Progress{
+		bot:      bot,
+		roomID:   roomID,
+		title:    title,
+		total:    total,
+		start:    time.Now(),
+		Interval: DefaultProgressInterval,
+	}
+	p.lastEdit = p.start
+	p.eventID = bot.SendNoticeOpts(ctx, roomID, p.status(), nil)
+	return p
+}
+
+func (p *Progress) status() string {
+	msg := fmt.Sprintf("⏳ %s... %d/%d done", p.title, p.done.Load()+p.failed.Load(), p.total)
+	if failed := p.failed.Load(); failed > 0 {
+		msg += fmt.Sprintf(" (%d failed)", failed)
+	}
+	return msg
+}
+
+// Add marks items as done or failed and edits the progress message if enough time has passed since the last edit.
+func (p *Progress) Add(ctx context.Context, done, failed int) {
+	if p == nil {
+		return
+	}
+	p.done.Add(int64(done))
+	p.failed.Add(int64(failed))
+	// Don't block concurrent workers while another one is editing the message
+	if !p.editLock.TryLock() {
+		return
+	}
+	defer p.editLock.Unlock()
+	if p.finished || time.Since(p.lastEdit) < p.Interval {
+		return
+	}
+	p.lastEdit = time.Now()
+	p.bot.SendNoticeOpts(ctx, p.roomID, p.status(), &SendNoticeOpts{Edit: p.eventID})
+}
+
+// Detail sends a message about an individual item to the thread of the progress message.
+func (p *Progress) Detail(ctx context.Context, message string, args ...any) {
+	if p == nil {
+		return
+	}
+	if len(args) > 0 {
+		message = fmt.Sprintf(message, args...)
+	}
+	p.bot.SendNoticeOpts(ctx, p.roomID, message, &SendNoticeOpts{ThreadRoot: p.eventID})
+}
+
+// Finish edits the progress message to mark the operation as completed with the given summary.
+func (p *Progress) Finish(ctx context.Context, summary string, args ...any) {
+	if p == nil {
+		return
+	}
+	if len(args) > 0 {
+		summary = fmt.Sprintf(summary, args...)
+	}
+	p.editLock.Lock()
+	defer p.editLock.Unlock()
+	if p.finished {
+		return
+	}
+	p.finished = true
+	icon := "✅"
+	if p.failed.Load() > 0 {
+		icon = "⚠️"
+	}
+	p.bot.SendNoticeOpts(
+		ctx, p.roomID,
+		fmt.Sprintf("%s %s: %s (took %s)", icon, p.title, summary, time.Since(p.start).Truncate(time.Millisecond)),
+		&SendNoticeOpts{Edit: p.eventID},
+	)
+}
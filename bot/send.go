@@ -22,9 +22,13 @@ type SendNoticeOpts struct {
 	AllowHTML        bool
 	Mentions         *event.Mentions
 	SendAsText       bool
+	// Edit is the ID of a previously sent message which this message should replace.
+	Edit id.EventID
+	// ThreadRoot is the ID of the message whose thread this message should be sent to.
+	ThreadRoot id.EventID
 }
 
-func (bot *Bot) SendNoticeOpts(ctx context.Context, roomID id.RoomID, message string, opts *SendNoticeOpts) {
+func (bot *Bot) SendNoticeOpts(ctx context.Context, roomID id.RoomID, message string, opts *SendNoticeOpts) id.EventID {
 	if opts == nil {
 		opts = &SendNoticeOpts{}
 	}
@@ -35,9 +39,16 @@ func (bot *Bot) SendNoticeOpts(ctx context.Context, roomID id.RoomID, message st
 	if opts.Mentions != nil {
 		content.Mentions = opts.Mentions
 	}
-	_, err := bot.Client.SendMessageEvent(ctx, roomID, event.EventMessage, &content)
+	if opts.Edit != "" {
+		content.SetEdit(opts.Edit)
+	} else if opts.ThreadRoot != "" {
+		content.RelatesTo = (&event.RelatesTo{}).SetThread(opts.ThreadRoot, opts.ThreadRoot)
+	}
+	resp, err := bot.Client.SendMessageEvent(ctx, roomID, event.EventMessage, &content)
 	if err != nil {
 		zerolog.Ctx(ctx).Err(err).
 			Msg("Failed to send management room message")
+		return ""
 	}
+	return resp.EventID
 }
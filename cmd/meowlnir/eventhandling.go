@@ -9,6 +9,7 @@ import (
 	"maunium.net/go/mautrix/id"
 
 	"go.mau.fi/meowlnir/config"
+	"go.mau.fi/meowlnir/policyeval"
 )
 
 func (m *Meowlnir) AddEventHandlers() {
@@ -39,9 +40,14 @@ func (m *Meowlnir) AddEventHandlers() {
 	m.EventProcessor.On(event.StateUnstablePolicyRoom, m.UpdatePolicyList)
 	m.EventProcessor.On(event.StateUnstablePolicyServer, m.UpdatePolicyList)
 	m.EventProcessor.On(event.EventRedaction, m.UpdatePolicyList)
+	m.EventProcessor.On(event.StatePowerLevels, m.UpdatePolicyListPowerLevels)
+	m.EventProcessor.On(event.StateTombstone, m.HandleTombstone)
+	m.EventProcessor.On(event.StateSpaceChild, m.HandleSpaceChild)
 	// Management room config
 	m.EventProcessor.On(config.StateWatchedLists, m.HandleConfigChange)
 	m.EventProcessor.On(config.StateProtectedRooms, m.HandleConfigChange)
+	m.EventProcessor.On(config.StateNoticeRouting, m.HandleConfigChange)
+	m.EventProcessor.On(config.StateRoles, m.HandleConfigChange)
 	m.EventProcessor.On(event.StatePowerLevels, m.HandleConfigChange)
 	m.EventProcessor.On(event.StateRoomName, m.HandleConfigChange)
 	m.EventProcessor.On(event.StateServerACL, m.HandleConfigChange)
@@ -49,6 +55,7 @@ func (m *Meowlnir) AddEventHandlers() {
 	m.EventProcessor.On(event.StateMember, m.HandleMember)
 	m.EventProcessor.On(event.EventMessage, m.HandleMessage)
 	m.EventProcessor.On(event.EventSticker, m.HandleMessage)
+	m.EventProcessor.On(event.EventReaction, m.HandleMessage)
 	m.EventProcessor.On(event.EventEncrypted, m.HandleEncrypted)
 }
 
@@ -87,6 +94,51 @@ func (m *Meowlnir) UpdatePolicyList(ctx context.Context, evt *event.Event) {
 	}
 }
 
+func (m *Meowlnir) UpdatePolicyListPowerLevels(ctx context.Context, evt *event.Event) {
+	room, prevPL := m.PolicyStore.UpdatePowerLevels(evt)
+	if room == nil {
+		return
+	}
+	for _, eval := range m.EvaluatorByManagementRoom {
+		eval.HandlePolicyListPowerLevels(ctx, room, prevPL)
+	}
+}
+
+func (m *Meowlnir) HandleTombstone(ctx context.Context, evt *event.Event) {
+	content, ok := evt.Content.Parsed.(*event.TombstoneEventContent)
+	if evt.StateKey == nil || *evt.StateKey != "" || !ok || content.ReplacementRoom == "" {
+		return
+	}
+	m.MapLock.RLock()
+	roomProtector, isProtected := m.EvaluatorByProtectedRoom[evt.RoomID]
+	m.MapLock.RUnlock()
+	if isProtected {
+		roomProtector.HandleProtectedRoomTombstone(ctx, evt, content)
+	}
+	for _, eval := range m.EvaluatorByManagementRoom {
+		if eval.IsWatchingList(evt.RoomID) {
+			eval.HandlePolicyListTombstone(ctx, evt, content)
+		}
+	}
+}
+
+func (m *Meowlnir) HandleSpaceChild(ctx context.Context, evt *event.Event) {
+	if evt.StateKey == nil || *evt.StateKey == "" {
+		return
+	}
+	m.MapLock.RLock()
+	var evaluators []*policyeval.PolicyEvaluator
+	for _, eval := range m.EvaluatorByManagementRoom {
+		if eval.IsProtectedSpace(evt.RoomID) {
+			evaluators = append(evaluators, eval)
+		}
+	}
+	m.MapLock.RUnlock()
+	for _, eval := range evaluators {
+		eval.HandleSpaceChild(ctx, evt)
+	}
+}
+
 func (m *Meowlnir) HandleConfigChange(ctx context.Context, evt *event.Event) {
 	// All room config events should have an empty state key
 	if evt.StateKey == nil || *evt.StateKey != "" {
@@ -150,10 +202,6 @@ func (m *Meowlnir) HandleEncrypted(ctx context.Context, evt *event.Event) {
 }
 
 func (m *Meowlnir) HandleMessage(ctx context.Context, evt *event.Event) {
-	content, ok := evt.Content.Parsed.(*event.MessageEventContent)
-	if !ok {
-		return
-	}
 	m.MapLock.RLock()
 	_, isBot := m.Bots[evt.Sender]
 	managementRoom, isManagement := m.EvaluatorByManagementRoom[evt.RoomID]
@@ -162,11 +210,18 @@ func (m *Meowlnir) HandleMessage(ctx context.Context, evt *event.Event) {
 	if isBot {
 		return
 	}
-	if isManagement {
-		if content.MsgType == event.MsgText && managementRoom.Admins.Has(evt.Sender) {
-			managementRoom.HandleCommand(ctx, evt)
+	switch content := evt.Content.Parsed.(type) {
+	case *event.MessageEventContent:
+		if isManagement {
+			if content.MsgType == event.MsgText && managementRoom.CanUseCommands(evt.Sender) {
+				managementRoom.HandleCommand(ctx, evt)
+			}
+		} else if isProtected {
+			roomProtector.HandleMessage(ctx, evt)
+		}
+	case *event.ReactionEventContent:
+		if isManagement && managementRoom.CanUseCommands(evt.Sender) {
+			managementRoom.HandleReaction(ctx, evt)
 		}
-	} else if isProtected {
-		roomProtector.HandleMessage(ctx, evt)
 	}
 }
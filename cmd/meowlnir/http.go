@@ -38,6 +38,10 @@ func (m *Meowlnir) AddHTTPEndpoints() {
 	managementRouter.HandleFunc("PUT /v1/bot/{username}", m.PutBot)
 	managementRouter.HandleFunc("POST /v1/bot/{username}/verify", m.PostVerifyBot)
 	managementRouter.HandleFunc("PUT /v1/management_room/{roomID}", m.PutManagementRoom)
+	managementRouter.HandleFunc("GET /v1/management_room/{roomID}/lint", m.GetLint)
+	managementRouter.HandleFunc("GET /v1/management_room/{roomID}/notes", m.GetNotes)
+	managementRouter.HandleFunc("GET /v1/management_room/{roomID}/list/{shortcode}/export", m.GetExportPolicies)
+	managementRouter.HandleFunc("POST /v1/management_room/{roomID}/list/{shortcode}/import", m.PostImportPolicies)
 	m.AS.Router.PathPrefix("/_meowlnir").Handler(applyMiddleware(
 		http.StripPrefix("/_meowlnir", managementRouter),
 		hlog.NewHandler(m.Log.With().Str("component", "management api").Logger()),
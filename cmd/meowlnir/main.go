@@ -236,6 +236,8 @@ func (m *Meowlnir) newPolicyEvaluator(bot *bot.Bot, roomID id.RoomID) *policyeva
 		m.Config.Antispam.AutoRejectInvitesToken != "",
 		m.Config.Antispam.FilterLocalInvites,
 		m.Config.Meowlnir.DryRun,
+		m.Config.Meowlnir.EvaluationConcurrency,
+		m.Config.Meowlnir.ActionConcurrency,
 		m.HackyAutoRedactPatterns,
 	)
 }
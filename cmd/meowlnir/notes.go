@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog/hlog"
+	"go.mau.fi/util/exhttp"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/database"
+)
+
+type RespNotes struct {
+	Notes []*database.Note `json:"notes"`
+}
+
+func (m *Meowlnir) GetNotes(w http.ResponseWriter, r *http.Request) {
+	roomID := id.RoomID(r.PathValue("roomID"))
+	m.MapLock.RLock()
+	_, ok := m.EvaluatorByManagementRoom[roomID]
+	m.MapLock.RUnlock()
+	if !ok {
+		mautrix.MNotFound.WithMessage("Management room not found").Write(w)
+		return
+	}
+	var notes []*database.Note
+	var err error
+	if entity := r.URL.Query().Get("entity"); entity != "" {
+		notes, err = m.DB.Note.GetByEntity(r.Context(), roomID, entity)
+	} else {
+		notes, err = m.DB.Note.GetAll(r.Context(), roomID)
+	}
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Msg("Failed to get notes from database")
+		mautrix.MUnknown.WithMessage("Failed to get notes from database").Write(w)
+		return
+	}
+	if notes == nil {
+		notes = []*database.Note{}
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, &RespNotes{Notes: notes})
+}
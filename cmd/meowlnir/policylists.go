@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/rs/zerolog/hlog"
+	"go.mau.fi/util/exhttp"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/config"
+	"go.mau.fi/meowlnir/policyeval"
+)
+
+const maxImportFileSize = 16 * 1024 * 1024
+
+func (m *Meowlnir) getManagementRoomAndList(w http.ResponseWriter, r *http.Request) (*policyeval.PolicyEvaluator, *config.WatchedPolicyList) {
+	m.MapLock.RLock()
+	mgmtRoom, ok := m.EvaluatorByManagementRoom[id.RoomID(r.PathValue("roomID"))]
+	m.MapLock.RUnlock()
+	if !ok {
+		mautrix.MNotFound.WithMessage("Management room not found").Write(w)
+		return nil, nil
+	}
+	list := mgmtRoom.FindListByShortcode(r.PathValue("shortcode"))
+	if list == nil {
+		mautrix.MNotFound.WithMessage("Policy list not found").Write(w)
+		return nil, nil
+	}
+	return mgmtRoom, list
+}
+
+func (m *Meowlnir) PostImportPolicies(w http.ResponseWriter, r *http.Request) {
+	mgmtRoom, list := m.getManagementRoomAndList(w, r)
+	if mgmtRoom == nil {
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportFileSize))
+	if err != nil {
+		mautrix.MTooLarge.WithMessage("Failed to read request body: " + err.Error()).Write(w)
+		return
+	}
+	importFormat := policyeval.PolicyImportFormat(r.URL.Query().Get("format"))
+	if importFormat == policyeval.PolicyImportFormatAuto {
+		importFormat = policyeval.DetectPolicyImportFormat(r.URL.Query().Get("filename"), data)
+	}
+	policies, err := policyeval.ParsePolicyImport(data, importFormat, r.URL.Query().Get("reason"))
+	if err != nil {
+		mautrix.MInvalidParam.WithMessage("Failed to parse import file: " + err.Error()).Write(w)
+		return
+	}
+	// Policies are sent with a delay in between, so large imports can take a long time.
+	// The import runs in the background and the result is reported in the management room.
+	log := hlog.FromRequest(r).With().Stringer("policy_list", list.RoomID).Logger()
+	ctx := log.WithContext(context.WithoutCancel(r.Context()))
+	go func() {
+		progress := mgmtRoom.Bot.StartProgress(ctx, mgmtRoom.ManagementRoom, fmt.Sprintf(
+			"Importing %d policies from %s file to [%s](%s) via API",
+			len(policies), importFormat, format.EscapeMarkdown(list.Name), list.RoomID.URI().MatrixToURL(),
+		), len(policies))
+		res := mgmtRoom.ImportPolicies(ctx, list, policies, progress)
+		log.Info().Any("result", res).Msg("Imported policies via API")
+		progress.Finish(ctx, res.Summary())
+		if len(res.Errors) > 0 {
+			progress.Detail(ctx, res.String())
+		}
+	}()
+	exhttp.WriteJSONResponse(w, http.StatusAccepted, &RespImportPolicies{Total: len(policies)})
+}
+
+type RespImportPolicies struct {
+	// Total is the number of policies parsed from the file, which will be imported in the background.
+	Total int `json:"total"`
+}
+
+func (m *Meowlnir) GetExportPolicies(w http.ResponseWriter, r *http.Request) {
+	mgmtRoom, list := m.getManagementRoomAndList(w, r)
+	if mgmtRoom == nil {
+		return
+	}
+	query := r.URL.Query()
+	filter, err := policyeval.ParsePolicyExportFilter(query.Get("entity_type"), query.Get("recommendation"))
+	if err != nil {
+		mautrix.MInvalidParam.WithMessage(err.Error()).Write(w)
+		return
+	}
+	exportFormat := policyeval.PolicyExportFormat(query.Get("format"))
+	switch exportFormat {
+	case "":
+		exportFormat = policyeval.PolicyExportFormatJSON
+	case policyeval.PolicyExportFormatJSON, policyeval.PolicyExportFormatCSV, policyeval.PolicyExportFormatACL:
+	default:
+		mautrix.MInvalidParam.WithMessage("Unknown export format").Write(w)
+		return
+	}
+	room := mgmtRoom.Store.GetRoom(list.RoomID)
+	if room == nil {
+		mautrix.MNotFound.WithMessage("Policy list hasn't been loaded").Write(w)
+		return
+	}
+	w.Header().Set("Content-Type", exportFormat.MimeType())
+	w.WriteHeader(http.StatusOK)
+	err = policyeval.ExportPolicies(w, room.Policies(), exportFormat, filter)
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Msg("Failed to write policy export")
+	}
+}
+
+type RespLint struct {
+	Issues []*policyeval.LintedIssue `json:"issues"`
+}
+
+func (m *Meowlnir) GetLint(w http.ResponseWriter, r *http.Request) {
+	m.MapLock.RLock()
+	mgmtRoom, ok := m.EvaluatorByManagementRoom[id.RoomID(r.PathValue("roomID"))]
+	m.MapLock.RUnlock()
+	if !ok {
+		mautrix.MNotFound.WithMessage("Management room not found").Write(w)
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, &RespLint{Issues: mgmtRoom.Lint()})
+}
@@ -28,6 +28,9 @@ type MeowlnirConfig struct {
 	ManagementSecret string `yaml:"management_secret"`
 	DryRun           bool   `yaml:"dry_run"`
 
+	EvaluationConcurrency int `yaml:"evaluation_concurrency"`
+	ActionConcurrency     int `yaml:"action_concurrency"`
+
 	ReportRoom          id.RoomID `yaml:"report_room"`
 	HackyRuleFilter     []string  `yaml:"hacky_rule_filter"`
 	HackyRedactPatterns []string  `yaml:"hacky_redact_patterns"`
@@ -2,14 +2,19 @@ package config
 
 import (
 	"reflect"
+	"time"
 
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/policylist"
 )
 
 var (
 	StateWatchedLists   = event.Type{Type: "fi.mau.meowlnir.watched_lists", Class: event.StateEventType}
 	StateProtectedRooms = event.Type{Type: "fi.mau.meowlnir.protected_rooms", Class: event.StateEventType}
+	StateNoticeRouting  = event.Type{Type: "fi.mau.meowlnir.notice_routing", Class: event.StateEventType}
+	StateRoles          = event.Type{Type: "fi.mau.meowlnir.roles", Class: event.StateEventType}
 )
 
 type WatchedPolicyList struct {
@@ -21,7 +26,32 @@ type WatchedPolicyList struct {
 	AutoUnban    bool      `json:"auto_unban"`
 	AutoSuspend  bool      `json:"auto_suspend"`
 
+	// Lists with a higher priority take precedence. Lists with equal priority are ordered as in the event.
+	Priority  int                  `json:"priority,omitempty"`
+	MergeMode policylist.MergeMode `json:"merge_mode,omitempty"`
+
+	// If either of these is set, only policies from the listed senders or from senders with
+	// at least the given power level in the policy room are applied. Other policies are ignored.
+	TrustedSenders      []id.UserID `json:"trusted_senders,omitempty"`
+	MinSenderPowerLevel *int        `json:"min_sender_power_level,omitempty"`
+
 	DontNotifyOnChange bool `json:"dont_notify_on_change"`
+	// If set, policy change notices are coalesced into one digest message per this many seconds.
+	NotifyDigestSeconds int `json:"notify_digest_seconds,omitempty"`
+	// If set, the list is held when it sends more policy changes than this within a minute.
+	// Changes to held lists are stored, but not applied until the list is released with `!lists release`.
+	MaxChangesPerMinute int `json:"max_changes_per_minute,omitempty"`
+}
+
+// SenderTrust returns the sender trust settings of the list, or nil if senders aren't restricted.
+func (wpl *WatchedPolicyList) SenderTrust() *policylist.SenderTrust {
+	if len(wpl.TrustedSenders) == 0 && wpl.MinSenderPowerLevel == nil {
+		return nil
+	}
+	return &policylist.SenderTrust{
+		AllowedSenders: wpl.TrustedSenders,
+		MinPowerLevel:  wpl.MinSenderPowerLevel,
+	}
 }
 
 type WatchedListsEventContent struct {
@@ -30,12 +60,102 @@ type WatchedListsEventContent struct {
 
 type ProtectedRoomsEventContent struct {
 	Rooms []id.RoomID `json:"rooms"`
+	// All rooms in these spaces, including ones in nested spaces, are protected in addition to the rooms above.
+	Spaces []ProtectedSpace `json:"spaces,omitempty"`
 
 	// TODO make this less hacky
 	SkipACL []id.RoomID `json:"skip_acl"`
+
+	RaidProtection *RaidProtection `json:"raid_protection,omitempty"`
+}
+
+type ProtectedSpace struct {
+	RoomID id.RoomID `json:"room_id"`
+	// Rooms and subspaces which shouldn't be protected. The contents of excluded subspaces are skipped too.
+	Exclude []id.RoomID `json:"exclude,omitempty"`
+}
+
+// RaidProtection configures join rate tracking in protected rooms.
+type RaidProtection struct {
+	// MaxJoins is the number of joins to a single room within the window that is considered a raid.
+	MaxJoins int `json:"max_joins"`
+	// WindowSeconds is the length of the sliding window for counting joins. Defaults to 60 seconds.
+	WindowSeconds int `json:"window_seconds,omitempty"`
+	// Lockdown is the lockdown mode to apply automatically when a raid is detected.
+	// If empty, the management room is only notified.
+	Lockdown LockdownMode `json:"lockdown,omitempty"`
+}
+
+func (rp *RaidProtection) Window() time.Duration {
+	if rp.WindowSeconds <= 0 {
+		return time.Minute
+	}
+	return time.Duration(rp.WindowSeconds) * time.Second
+}
+
+type LockdownMode string
+
+const (
+	// LockdownModeInvite switches the join rules of the room to invite-only.
+	LockdownModeInvite LockdownMode = "invite"
+	// LockdownModeKnock switches the join rules of the room to knock, so that users have to request to join.
+	LockdownModeKnock LockdownMode = "knock"
+	// LockdownModeMute raises events_default above users_default, so that only users with a higher power level can talk.
+	LockdownModeMute LockdownMode = "mute"
+)
+
+var LockdownModes = []LockdownMode{LockdownModeInvite, LockdownModeKnock, LockdownModeMute}
+
+type NoticeCategory string
+
+const (
+	// NoticeCategoryGeneral is used for config changes, command output and other notices that don't fit elsewhere.
+	NoticeCategoryGeneral NoticeCategory = "general"
+	// NoticeCategoryActions is used for bans, unbans, redactions, suspensions and server ACL updates.
+	NoticeCategoryActions NoticeCategory = "actions"
+	// NoticeCategoryPolicies is used for policy list changes.
+	NoticeCategoryPolicies NoticeCategory = "policies"
+	// NoticeCategoryReports is used for user reports.
+	NoticeCategoryReports NoticeCategory = "reports"
+	// NoticeCategoryAntispam is used for invites and joins blocked by the antispam API.
+	NoticeCategoryAntispam NoticeCategory = "antispam"
+	// NoticeCategoryAlerts is used for problems that need attention, like losing power in a protected room.
+	NoticeCategoryAlerts NoticeCategory = "alerts"
+	// NoticeCategoryPings is used for notifications about the bot being mentioned.
+	NoticeCategoryPings NoticeCategory = "pings"
+	// NoticeCategoryWatchlist is used for activity of users matching watch policies.
+	NoticeCategoryWatchlist NoticeCategory = "watchlist"
+)
+
+var NoticeCategories = []NoticeCategory{
+	NoticeCategoryGeneral, NoticeCategoryActions, NoticeCategoryPolicies, NoticeCategoryReports,
+	NoticeCategoryAntispam, NoticeCategoryAlerts, NoticeCategoryPings, NoticeCategoryWatchlist,
+}
+
+type NoticeRoutingEventContent struct {
+	// Rooms maps notice categories to the rooms they're sent to.
+	// Categories that aren't in the map are sent to the management room.
+	Rooms map[NoticeCategory]id.RoomID `json:"rooms"`
+	// Ping lists categories whose notices should mention @room.
+	Ping []NoticeCategory `json:"ping,omitempty"`
+}
+
+type RolesEventContent struct {
+	// Roles maps role names to the commands that members of the role can use.
+	// Commands are specified by name without the prefix, e.g. `ban` or `lists release`.
+	// A top-level command also allows all of its subcommands, and `*` allows all commands.
+	Roles map[string][]string `json:"roles"`
+	// Users maps user IDs to the roles they have. Roles only apply to management room admins,
+	// other users can't use commands even if they're listed here.
+	Users map[id.UserID][]string `json:"users"`
+	// DefaultRole is given to management room admins (based on power levels) who aren't listed in Users.
+	// If empty, admins without explicit roles can't use any commands.
+	DefaultRole string `json:"default_role,omitempty"`
 }
 
 func init() {
 	event.TypeMap[StateWatchedLists] = reflect.TypeOf(WatchedListsEventContent{})
 	event.TypeMap[StateProtectedRooms] = reflect.TypeOf(ProtectedRoomsEventContent{})
+	event.TypeMap[StateNoticeRouting] = reflect.TypeOf(NoticeRoutingEventContent{})
+	event.TypeMap[StateRoles] = reflect.TypeOf(RolesEventContent{})
 }
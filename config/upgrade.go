@@ -32,6 +32,8 @@ func upgradeConfig(helper up.Helper) {
 
 	generateOrCopy(helper, "meowlnir", "management_secret")
 	helper.Copy(up.Bool, "meowlnir", "dry_run")
+	helper.Copy(up.Int, "meowlnir", "evaluation_concurrency")
+	helper.Copy(up.Int, "meowlnir", "action_concurrency")
 	helper.Copy(up.Str|up.Null, "meowlnir", "report_room")
 	helper.Copy(up.List, "meowlnir", "hacky_rule_filter")
 	helper.Copy(up.List, "meowlnir", "hacky_redact_patterns")
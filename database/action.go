@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"go.mau.fi/util/dbutil"
@@ -11,17 +12,20 @@ import (
 
 const (
 	getTakenActionBaseQuery = `
-		SELECT target_user, in_room_id, action_type, policy_list, rule_entity, action, taken_at
+		SELECT target_user, in_room_id, action_type, policy_list, rule_entity, action, taken_at, expires_at, previous_power_level
 		FROM taken_action
 	`
+	getTakenActionQuery              = getTakenActionBaseQuery + `WHERE target_user=$1 AND in_room_id=$2 AND action_type=$3`
+	getExpiredTakenActionsQuery      = getTakenActionBaseQuery + `WHERE action_type=$1 AND expires_at<=$2`
 	getTakenActionsByPolicyListQuery = getTakenActionBaseQuery + `WHERE policy_list=$1`
 	getTakenActionsByRuleEntityQuery = getTakenActionBaseQuery + `WHERE policy_list=$1 AND rule_entity=$2`
 	getTakenActionByTargetUserQuery  = getTakenActionBaseQuery + `WHERE target_user=$1 AND action_type=$2`
 	insertTakenActionQuery           = `
-		INSERT INTO taken_action (target_user, in_room_id, action_type, policy_list, rule_entity, action, taken_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO taken_action (target_user, in_room_id, action_type, policy_list, rule_entity, action, taken_at, expires_at, previous_power_level)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		ON CONFLICT (target_user, in_room_id, action_type) DO UPDATE
-			SET policy_list=excluded.policy_list, rule_entity=excluded.rule_entity, action=excluded.action, taken_at=excluded.taken_at
+			SET policy_list=excluded.policy_list, rule_entity=excluded.rule_entity, action=excluded.action, taken_at=excluded.taken_at,
+			    expires_at=excluded.expires_at, previous_power_level=excluded.previous_power_level
 	`
 	deleteTakenActionQuery = `DELETE FROM taken_action WHERE target_user=$1 AND in_room_id=$2 AND action_type=$3`
 )
@@ -38,6 +42,14 @@ func (taq *TakenActionQuery) Put(ctx context.Context, ta *TakenAction) error {
 	return taq.Exec(ctx, insertTakenActionQuery, ta.sqlVariables()...)
 }
 
+func (taq *TakenActionQuery) Get(ctx context.Context, targetUser id.UserID, inRoomID id.RoomID, actionType TakenActionType) (*TakenAction, error) {
+	return taq.QueryOne(ctx, getTakenActionQuery, targetUser, inRoomID, actionType)
+}
+
+func (taq *TakenActionQuery) GetExpired(ctx context.Context, actionType TakenActionType, now time.Time) ([]*TakenAction, error) {
+	return taq.QueryMany(ctx, getExpiredTakenActionsQuery, actionType, now.UnixMilli())
+}
+
 func (taq *TakenActionQuery) GetAllByPolicyList(ctx context.Context, policyList id.RoomID) ([]*TakenAction, error) {
 	return taq.QueryMany(ctx, getTakenActionsByPolicyListQuery, policyList)
 }
@@ -54,6 +66,7 @@ type TakenActionType string
 
 const (
 	TakenActionTypeBanOrUnban TakenActionType = "ban_or_unban"
+	TakenActionTypeMute       TakenActionType = "mute"
 )
 
 type TakenAction struct {
@@ -64,18 +77,33 @@ type TakenAction struct {
 	RuleEntity string
 	Action     event.PolicyRecommendation
 	TakenAt    time.Time
+	// ExpiresAt is the time when the action should be undone automatically. Zero means never.
+	ExpiresAt time.Time
+	// PreviousPowerLevel is the power level the user had before being muted, which is restored on unmute.
+	PreviousPowerLevel *int
 }
 
 func (t *TakenAction) sqlVariables() []any {
-	return []any{t.TargetUser, t.InRoomID, t.ActionType, t.PolicyList, t.RuleEntity, t.Action, t.TakenAt.UnixMilli()}
+	return []any{
+		t.TargetUser, t.InRoomID, t.ActionType, t.PolicyList, t.RuleEntity, t.Action, t.TakenAt.UnixMilli(),
+		dbutil.UnixMilliPtr(t.ExpiresAt), t.PreviousPowerLevel,
+	}
 }
 
 func (t *TakenAction) Scan(row dbutil.Scannable) (*TakenAction, error) {
 	var takenAt int64
-	err := row.Scan(&t.TargetUser, &t.InRoomID, &t.ActionType, &t.PolicyList, &t.RuleEntity, &t.Action, &takenAt)
+	var expiresAt, previousPowerLevel sql.NullInt64
+	err := row.Scan(&t.TargetUser, &t.InRoomID, &t.ActionType, &t.PolicyList, &t.RuleEntity, &t.Action, &takenAt, &expiresAt, &previousPowerLevel)
 	if err != nil {
 		return nil, err
 	}
 	t.TakenAt = time.UnixMilli(takenAt)
+	if expiresAt.Valid {
+		t.ExpiresAt = time.UnixMilli(expiresAt.Int64)
+	}
+	if previousPowerLevel.Valid {
+		level := int(previousPowerLevel.Int64)
+		t.PreviousPowerLevel = &level
+	}
 	return t, nil
 }
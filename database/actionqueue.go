@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getQueuedActionBaseQuery = `
+		SELECT id, management_room, action_type, room_id, target, payload, attempts, next_attempt_at, last_error, created_at
+		FROM action_queue
+	`
+	getQueuedActionsByManagementRoomQuery = getQueuedActionBaseQuery + `WHERE management_room=$1 ORDER BY next_attempt_at`
+	getDueQueuedActionsQuery              = getQueuedActionBaseQuery + `WHERE management_room=$1 AND next_attempt_at<=$2 ORDER BY next_attempt_at LIMIT $3`
+	putQueuedActionQuery                  = `
+		INSERT INTO action_queue (id, management_room, action_type, room_id, target, payload, attempts, next_attempt_at, last_error, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (id) DO UPDATE
+			SET management_room=excluded.management_room, action_type=excluded.action_type, room_id=excluded.room_id,
+			    target=excluded.target, payload=excluded.payload, attempts=excluded.attempts,
+			    next_attempt_at=excluded.next_attempt_at, last_error=excluded.last_error, created_at=excluded.created_at
+	`
+	deleteQueuedActionQuery = `DELETE FROM action_queue WHERE id=$1 AND created_at=$2`
+)
+
+type QueuedActionQuery struct {
+	*dbutil.QueryHelper[*QueuedAction]
+}
+
+// Put inserts the given action into the queue, replacing any existing action with the same ID.
+func (qaq *QueuedActionQuery) Put(ctx context.Context, qa *QueuedAction) error {
+	return qaq.Exec(ctx, putQueuedActionQuery, qa.sqlVariables()...)
+}
+
+// Delete removes the given action from the queue,
+// unless it has already been replaced by a newer action with the same ID.
+func (qaq *QueuedActionQuery) Delete(ctx context.Context, qa *QueuedAction) error {
+	return qaq.Exec(ctx, deleteQueuedActionQuery, qa.ID, qa.CreatedAt.UnixMilli())
+}
+
+func (qaq *QueuedActionQuery) GetAll(ctx context.Context, managementRoom id.RoomID) ([]*QueuedAction, error) {
+	return qaq.QueryMany(ctx, getQueuedActionsByManagementRoomQuery, managementRoom)
+}
+
+func (qaq *QueuedActionQuery) GetDue(ctx context.Context, managementRoom id.RoomID, now time.Time, limit int) ([]*QueuedAction, error) {
+	return qaq.QueryMany(ctx, getDueQueuedActionsQuery, managementRoom, now.UnixMilli(), limit)
+}
+
+type QueuedActionType string
+
+const (
+	QueuedActionTypeBan       QueuedActionType = "ban"
+	QueuedActionTypeUnban     QueuedActionType = "unban"
+	QueuedActionTypeRedact    QueuedActionType = "redact"
+	QueuedActionTypeServerACL QueuedActionType = "server_acl"
+)
+
+// QueuedActionPayload contains the type-specific parameters of a queued action.
+type QueuedActionPayload struct {
+	Reason         string                       `json:"reason,omitempty"`
+	PolicyList     id.RoomID                    `json:"policy_list,omitempty"`
+	RuleEntity     string                       `json:"rule_entity,omitempty"`
+	Recommendation event.PolicyRecommendation   `json:"recommendation,omitempty"`
+	ServerACL      *event.ServerACLEventContent `json:"server_acl,omitempty"`
+	// ShowNotes is set on one ban when a user is banned in multiple rooms at once,
+	// so that notes about the user are only included in one of the ban notices.
+	ShowNotes bool `json:"show_notes,omitempty"`
+}
+
+type QueuedAction struct {
+	ID             string
+	ManagementRoom id.RoomID
+	ActionType     QueuedActionType
+	RoomID         id.RoomID
+	// Target is the user ID for bans and unbans, the event ID for redactions and empty for server ACLs.
+	Target        string
+	Payload       QueuedActionPayload
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+}
+
+// QueuedActionID returns the queue ID for an action of the given type.
+//
+// Bans and unbans of the same user share an ID, so that only the most recent one of them is kept in the queue.
+func QueuedActionID(actionType QueuedActionType, roomID id.RoomID, target string) string {
+	switch actionType {
+	case QueuedActionTypeBan, QueuedActionTypeUnban:
+		return "membership|" + roomID.String() + "|" + target
+	default:
+		return string(actionType) + "|" + roomID.String() + "|" + target
+	}
+}
+
+func (qa *QueuedAction) sqlVariables() []any {
+	return []any{
+		qa.ID, qa.ManagementRoom, qa.ActionType, qa.RoomID, qa.Target, dbutil.JSON{Data: &qa.Payload},
+		qa.Attempts, qa.NextAttemptAt.UnixMilli(), qa.LastError, qa.CreatedAt.UnixMilli(),
+	}
+}
+
+func (qa *QueuedAction) Scan(row dbutil.Scannable) (*QueuedAction, error) {
+	var nextAttemptAt, createdAt int64
+	err := row.Scan(
+		&qa.ID, &qa.ManagementRoom, &qa.ActionType, &qa.RoomID, &qa.Target, dbutil.JSON{Data: &qa.Payload},
+		&qa.Attempts, &nextAttemptAt, &qa.LastError, &createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	qa.NextAttemptAt = time.UnixMilli(nextAttemptAt)
+	qa.CreatedAt = time.UnixMilli(createdAt)
+	return qa, nil
+}
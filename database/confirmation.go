@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getPendingConfirmationBaseQuery = `
+		SELECT event_id, management_room, command_event_id, sender, command, summary, created_at, expires_at
+		FROM pending_confirmation
+	`
+	getPendingConfirmationQuery  = getPendingConfirmationBaseQuery + `WHERE management_room=$1 AND event_id=$2`
+	getExpiredConfirmationsQuery = getPendingConfirmationBaseQuery + `WHERE management_room=$1 AND expires_at<=$2`
+	putPendingConfirmationQuery  = `
+		INSERT INTO pending_confirmation (event_id, management_room, command_event_id, sender, command, summary, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	deletePendingConfirmationQuery = `DELETE FROM pending_confirmation WHERE management_room=$1 AND event_id=$2`
+)
+
+type PendingConfirmationQuery struct {
+	*dbutil.QueryHelper[*PendingConfirmation]
+}
+
+func (pcq *PendingConfirmationQuery) Put(ctx context.Context, pc *PendingConfirmation) error {
+	return pcq.Exec(ctx, putPendingConfirmationQuery, pc.sqlVariables()...)
+}
+
+func (pcq *PendingConfirmationQuery) Get(ctx context.Context, managementRoom id.RoomID, eventID id.EventID) (*PendingConfirmation, error) {
+	return pcq.QueryOne(ctx, getPendingConfirmationQuery, managementRoom, eventID)
+}
+
+func (pcq *PendingConfirmationQuery) GetExpired(ctx context.Context, managementRoom id.RoomID, now time.Time) ([]*PendingConfirmation, error) {
+	return pcq.QueryMany(ctx, getExpiredConfirmationsQuery, managementRoom, now.UnixMilli())
+}
+
+// Delete removes the given confirmation. The returned boolean is false if the confirmation had already been removed,
+// which means another reaction or the expiry loop got to it first.
+func (pcq *PendingConfirmationQuery) Delete(ctx context.Context, pc *PendingConfirmation) (bool, error) {
+	res, err := pcq.GetDB().Exec(ctx, deletePendingConfirmationQuery, pc.ManagementRoom, pc.EventID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// PendingConfirmation is a command which is waiting for the sender to confirm it by reacting to the bot's prompt.
+type PendingConfirmation struct {
+	// EventID is the ID of the confirmation prompt sent by the bot.
+	EventID        id.EventID
+	ManagementRoom id.RoomID
+	CommandEventID id.EventID
+	Sender         id.UserID
+	// Command is the raw text of the command, which is re-parsed when the confirmation is accepted.
+	Command   string
+	Summary   string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+func (pc *PendingConfirmation) sqlVariables() []any {
+	return []any{
+		pc.EventID, pc.ManagementRoom, pc.CommandEventID, pc.Sender, pc.Command, pc.Summary,
+		pc.CreatedAt.UnixMilli(), pc.ExpiresAt.UnixMilli(),
+	}
+}
+
+func (pc *PendingConfirmation) Scan(row dbutil.Scannable) (*PendingConfirmation, error) {
+	var createdAt, expiresAt int64
+	err := row.Scan(
+		&pc.EventID, &pc.ManagementRoom, &pc.CommandEventID, &pc.Sender, &pc.Command, &pc.Summary,
+		&createdAt, &expiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	pc.CreatedAt = time.UnixMilli(createdAt)
+	pc.ExpiresAt = time.UnixMilli(expiresAt)
+	return pc, nil
+}
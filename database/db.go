@@ -11,6 +11,13 @@ type Database struct {
 	TakenAction    *TakenActionQuery
 	Bot            *BotQuery
 	ManagementRoom *ManagementRoomQuery
+	ActionQueue    *QueuedActionQuery
+	Confirmation   *PendingConfirmationQuery
+	ModerationLog  *ModerationLogQuery
+	RoomLockdown   *RoomLockdownQuery
+	Note           *NoteQuery
+	HeldList       *HeldListQuery
+	HeldPolicy     *HeldPolicyQuery
 }
 
 func New(db *dbutil.Database) *Database {
@@ -30,5 +37,40 @@ func New(db *dbutil.Database) *Database {
 		ManagementRoom: &ManagementRoomQuery{
 			Database: db,
 		},
+		ActionQueue: &QueuedActionQuery{
+			QueryHelper: dbutil.MakeQueryHelper(db, func(qh *dbutil.QueryHelper[*QueuedAction]) *QueuedAction {
+				return &QueuedAction{}
+			}),
+		},
+		Confirmation: &PendingConfirmationQuery{
+			QueryHelper: dbutil.MakeQueryHelper(db, func(qh *dbutil.QueryHelper[*PendingConfirmation]) *PendingConfirmation {
+				return &PendingConfirmation{}
+			}),
+		},
+		ModerationLog: &ModerationLogQuery{
+			QueryHelper: dbutil.MakeQueryHelper(db, func(qh *dbutil.QueryHelper[*ModerationLogEntry]) *ModerationLogEntry {
+				return &ModerationLogEntry{}
+			}),
+		},
+		RoomLockdown: &RoomLockdownQuery{
+			QueryHelper: dbutil.MakeQueryHelper(db, func(qh *dbutil.QueryHelper[*RoomLockdown]) *RoomLockdown {
+				return &RoomLockdown{}
+			}),
+		},
+		Note: &NoteQuery{
+			QueryHelper: dbutil.MakeQueryHelper(db, func(qh *dbutil.QueryHelper[*Note]) *Note {
+				return &Note{}
+			}),
+		},
+		HeldList: &HeldListQuery{
+			QueryHelper: dbutil.MakeQueryHelper(db, func(qh *dbutil.QueryHelper[*HeldList]) *HeldList {
+				return &HeldList{}
+			}),
+		},
+		HeldPolicy: &HeldPolicyQuery{
+			QueryHelper: dbutil.MakeQueryHelper(db, func(qh *dbutil.QueryHelper[*HeldPolicy]) *HeldPolicy {
+				return &HeldPolicy{}
+			}),
+		},
 	}
 }
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getHeldListsByManagementRoomQuery = `
+		SELECT management_room, policy_list, held_since FROM held_list WHERE management_room=$1
+	`
+	insertHeldListQuery = `
+		INSERT INTO held_list (management_room, policy_list, held_since)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (management_room, policy_list) DO NOTHING
+	`
+	deleteHeldListQuery = `DELETE FROM held_list WHERE management_room=$1 AND policy_list=$2`
+
+	getHeldPoliciesByManagementRoomQuery = `
+		SELECT management_room, policy_list, event_id, removed, policy FROM held_policy WHERE management_room=$1
+	`
+	putHeldPolicyQuery = `
+		INSERT INTO held_policy (management_room, policy_list, event_id, removed, policy)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (management_room, event_id) DO UPDATE
+			SET policy_list=excluded.policy_list, removed=excluded.removed, policy=excluded.policy
+	`
+	deleteHeldPolicyQuery = `DELETE FROM held_policy WHERE management_room=$1 AND event_id=$2`
+)
+
+type HeldListQuery struct {
+	*dbutil.QueryHelper[*HeldList]
+}
+
+func (hlq *HeldListQuery) Put(ctx context.Context, hl *HeldList) error {
+	return hlq.Exec(ctx, insertHeldListQuery, hl.sqlVariables()...)
+}
+
+func (hlq *HeldListQuery) GetAll(ctx context.Context, managementRoom id.RoomID) ([]*HeldList, error) {
+	return hlq.QueryMany(ctx, getHeldListsByManagementRoomQuery, managementRoom)
+}
+
+// Delete removes the held state of the given list, including all of its held policies.
+func (hlq *HeldListQuery) Delete(ctx context.Context, managementRoom, policyList id.RoomID) error {
+	return hlq.Exec(ctx, deleteHeldListQuery, managementRoom, policyList)
+}
+
+// HeldList is a watched policy list whose changes are held until an admin releases the list.
+type HeldList struct {
+	ManagementRoom id.RoomID
+	PolicyList     id.RoomID
+	HeldSince      time.Time
+}
+
+func (hl *HeldList) sqlVariables() []any {
+	return []any{hl.ManagementRoom, hl.PolicyList, hl.HeldSince.UnixMilli()}
+}
+
+func (hl *HeldList) Scan(row dbutil.Scannable) (*HeldList, error) {
+	var heldSince int64
+	err := row.Scan(&hl.ManagementRoom, &hl.PolicyList, &heldSince)
+	if err != nil {
+		return nil, err
+	}
+	hl.HeldSince = time.UnixMilli(heldSince)
+	return hl, nil
+}
+
+type HeldPolicyQuery struct {
+	*dbutil.QueryHelper[*HeldPolicy]
+}
+
+func (hpq *HeldPolicyQuery) Put(ctx context.Context, hp *HeldPolicy) error {
+	return hpq.Exec(ctx, putHeldPolicyQuery, hp.sqlVariables()...)
+}
+
+func (hpq *HeldPolicyQuery) GetAll(ctx context.Context, managementRoom id.RoomID) ([]*HeldPolicy, error) {
+	return hpq.QueryMany(ctx, getHeldPoliciesByManagementRoomQuery, managementRoom)
+}
+
+func (hpq *HeldPolicyQuery) Delete(ctx context.Context, managementRoom id.RoomID, eventID id.EventID) error {
+	return hpq.Exec(ctx, deleteHeldPolicyQuery, managementRoom, eventID)
+}
+
+// HeldPolicy is a policy whose change hasn't been applied yet, because its list is held.
+type HeldPolicy struct {
+	ManagementRoom id.RoomID
+	PolicyList     id.RoomID
+	EventID        id.EventID
+	// Removed is true if the policy was removed or replaced while the list was held, which means it stays
+	// in effect until the list is released. Otherwise, the policy was added and isn't in effect yet.
+	Removed bool
+	// Policy is the policy state event. Removed policies are no longer in the room state,
+	// so the whole event is stored to be able to keep applying them.
+	Policy *event.Event
+}
+
+func (hp *HeldPolicy) sqlVariables() []any {
+	return []any{hp.ManagementRoom, hp.PolicyList, hp.EventID, hp.Removed, dbutil.JSON{Data: hp.Policy}}
+}
+
+func (hp *HeldPolicy) Scan(row dbutil.Scannable) (*HeldPolicy, error) {
+	err := row.Scan(&hp.ManagementRoom, &hp.PolicyList, &hp.EventID, &hp.Removed, dbutil.JSON{Data: &hp.Policy})
+	if err != nil {
+		return nil, err
+	}
+	return hp, nil
+}
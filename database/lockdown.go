@@ -0,0 +1,86 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getRoomLockdownBaseQuery = `
+		SELECT room_id, management_room, mode, previous_join_rules, previous_events_default, locked_by, reason, locked_at
+		FROM room_lockdown
+	`
+	getRoomLockdownQuery                  = getRoomLockdownBaseQuery + `WHERE room_id=$1`
+	getRoomLockdownsByManagementRoomQuery = getRoomLockdownBaseQuery + `WHERE management_room=$1 ORDER BY locked_at`
+	insertRoomLockdownQuery               = `
+		INSERT INTO room_lockdown (room_id, management_room, mode, previous_join_rules, previous_events_default, locked_by, reason, locked_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	deleteRoomLockdownQuery = `DELETE FROM room_lockdown WHERE room_id=$1`
+)
+
+type RoomLockdownQuery struct {
+	*dbutil.QueryHelper[*RoomLockdown]
+}
+
+func (rlq *RoomLockdownQuery) Put(ctx context.Context, rl *RoomLockdown) error {
+	return rlq.Exec(ctx, insertRoomLockdownQuery, rl.sqlVariables()...)
+}
+
+func (rlq *RoomLockdownQuery) Get(ctx context.Context, roomID id.RoomID) (*RoomLockdown, error) {
+	return rlq.QueryOne(ctx, getRoomLockdownQuery, roomID)
+}
+
+func (rlq *RoomLockdownQuery) GetAll(ctx context.Context, managementRoom id.RoomID) ([]*RoomLockdown, error) {
+	return rlq.QueryMany(ctx, getRoomLockdownsByManagementRoomQuery, managementRoom)
+}
+
+func (rlq *RoomLockdownQuery) Delete(ctx context.Context, roomID id.RoomID) error {
+	return rlq.Exec(ctx, deleteRoomLockdownQuery, roomID)
+}
+
+// RoomLockdown is a protected room which has been locked down, either manually or due to a detected raid.
+// The previous state of the room is stored so that it can be restored when the lockdown is lifted.
+type RoomLockdown struct {
+	RoomID         id.RoomID
+	ManagementRoom id.RoomID
+	Mode           string
+	// PreviousJoinRules is set for lockdowns that changed the join rules of the room.
+	PreviousJoinRules *event.JoinRulesEventContent
+	// PreviousEventsDefault is set for lockdowns that raised the events_default power level.
+	PreviousEventsDefault *int
+	// LockedBy is the user who locked the room down, or the bot itself for automatic lockdowns.
+	LockedBy id.UserID
+	Reason   string
+	LockedAt time.Time
+}
+
+func (rl *RoomLockdown) sqlVariables() []any {
+	return []any{
+		rl.RoomID, rl.ManagementRoom, rl.Mode, dbutil.JSONPtr(rl.PreviousJoinRules), rl.PreviousEventsDefault,
+		rl.LockedBy, rl.Reason, rl.LockedAt.UnixMilli(),
+	}
+}
+
+func (rl *RoomLockdown) Scan(row dbutil.Scannable) (*RoomLockdown, error) {
+	var lockedAt int64
+	var previousEventsDefault sql.NullInt64
+	err := row.Scan(
+		&rl.RoomID, &rl.ManagementRoom, &rl.Mode, dbutil.JSON{Data: &rl.PreviousJoinRules}, &previousEventsDefault,
+		&rl.LockedBy, &rl.Reason, &lockedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if previousEventsDefault.Valid {
+		level := int(previousEventsDefault.Int64)
+		rl.PreviousEventsDefault = &level
+	}
+	rl.LockedAt = time.UnixMilli(lockedAt)
+	return rl, nil
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getModerationLogBaseQuery = `
+		SELECT management_room, command_event_id, moderator, action, target_user, room_id, reason, taken_at
+		FROM moderation_log
+	`
+	getRecentModerationLogQuery   = getModerationLogBaseQuery + `WHERE management_room=$1 ORDER BY taken_at DESC LIMIT $2`
+	getModerationLogByTargetQuery = getModerationLogBaseQuery + `WHERE management_room=$1 AND target_user=$2 ORDER BY taken_at DESC LIMIT $3`
+	insertModerationLogQuery      = `
+		INSERT INTO moderation_log (management_room, command_event_id, moderator, action, target_user, room_id, reason, taken_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (command_event_id, action, target_user, room_id) DO NOTHING
+	`
+)
+
+type ModerationLogQuery struct {
+	*dbutil.QueryHelper[*ModerationLogEntry]
+}
+
+func (mlq *ModerationLogQuery) Put(ctx context.Context, entry *ModerationLogEntry) error {
+	return mlq.Exec(ctx, insertModerationLogQuery, entry.sqlVariables()...)
+}
+
+func (mlq *ModerationLogQuery) GetRecent(ctx context.Context, managementRoom id.RoomID, limit int) ([]*ModerationLogEntry, error) {
+	return mlq.QueryMany(ctx, getRecentModerationLogQuery, managementRoom, limit)
+}
+
+func (mlq *ModerationLogQuery) GetByTarget(ctx context.Context, managementRoom id.RoomID, targetUser id.UserID, limit int) ([]*ModerationLogEntry, error) {
+	return mlq.QueryMany(ctx, getModerationLogByTargetQuery, managementRoom, targetUser, limit)
+}
+
+type ModerationAction string
+
+const (
+	ModerationActionBan    ModerationAction = "ban"
+	ModerationActionUnban  ModerationAction = "unban"
+	ModerationActionMute   ModerationAction = "mute"
+	ModerationActionUnmute ModerationAction = "unmute"
+)
+
+// ModerationLogEntry records a manual moderation action that was taken in a single room using a command,
+// as opposed to actions taken automatically based on policy lists.
+type ModerationLogEntry struct {
+	ManagementRoom id.RoomID
+	CommandEventID id.EventID
+	Moderator      id.UserID
+	Action         ModerationAction
+	TargetUser     id.UserID
+	RoomID         id.RoomID
+	Reason         string
+	TakenAt        time.Time
+}
+
+func (mle *ModerationLogEntry) sqlVariables() []any {
+	return []any{
+		mle.ManagementRoom, mle.CommandEventID, mle.Moderator, mle.Action, mle.TargetUser, mle.RoomID, mle.Reason,
+		mle.TakenAt.UnixMilli(),
+	}
+}
+
+func (mle *ModerationLogEntry) Scan(row dbutil.Scannable) (*ModerationLogEntry, error) {
+	var takenAt int64
+	err := row.Scan(
+		&mle.ManagementRoom, &mle.CommandEventID, &mle.Moderator, &mle.Action, &mle.TargetUser, &mle.RoomID, &mle.Reason,
+		&takenAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	mle.TakenAt = time.UnixMilli(takenAt)
+	return mle, nil
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getNoteBaseQuery = `
+		SELECT event_id, management_room, entity, author, text, created_at
+		FROM note
+	`
+	getNotesByManagementRoomQuery = getNoteBaseQuery + `WHERE management_room=$1 ORDER BY created_at`
+	getNotesByEntityQuery         = getNoteBaseQuery + `WHERE management_room=$1 AND entity=$2 ORDER BY created_at`
+	insertNoteQuery               = `
+		INSERT INTO note (event_id, management_room, entity, author, text, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+)
+
+type NoteQuery struct {
+	*dbutil.QueryHelper[*Note]
+}
+
+func (nq *NoteQuery) Put(ctx context.Context, note *Note) error {
+	return nq.Exec(ctx, insertNoteQuery, note.sqlVariables()...)
+}
+
+func (nq *NoteQuery) GetAll(ctx context.Context, managementRoom id.RoomID) ([]*Note, error) {
+	return nq.QueryMany(ctx, getNotesByManagementRoomQuery, managementRoom)
+}
+
+func (nq *NoteQuery) GetByEntity(ctx context.Context, managementRoom id.RoomID, entity string) ([]*Note, error) {
+	return nq.QueryMany(ctx, getNotesByEntityQuery, managementRoom, entity)
+}
+
+// Note is a free-form note written by a moderator about a user, room or server.
+type Note struct {
+	// EventID is the ID of the command event that created the note.
+	EventID        id.EventID `json:"event_id"`
+	ManagementRoom id.RoomID  `json:"management_room"`
+	Entity         string     `json:"entity"`
+	Author         id.UserID  `json:"author"`
+	Text           string     `json:"text"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+func (n *Note) sqlVariables() []any {
+	return []any{n.EventID, n.ManagementRoom, n.Entity, n.Author, n.Text, n.CreatedAt.UnixMilli()}
+}
+
+func (n *Note) Scan(row dbutil.Scannable) (*Note, error) {
+	var createdAt int64
+	err := row.Scan(&n.EventID, &n.ManagementRoom, &n.Entity, &n.Author, &n.Text, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	n.CreatedAt = time.UnixMilli(createdAt)
+	return n, nil
+}
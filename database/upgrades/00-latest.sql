@@ -1,4 +1,4 @@
--- v0 -> v1 (compatible with v1+): Latest schema
+-- v0 -> v8 (compatible with v1+): Latest schema
 CREATE TABLE bot (
     username     TEXT PRIMARY KEY NOT NULL,
     displayname  TEXT NOT NULL,
@@ -21,9 +21,114 @@ CREATE TABLE taken_action (
     rule_entity TEXT   NOT NULL,
     action      TEXT   NOT NULL,
     taken_at    BIGINT NOT NULL,
+    expires_at  BIGINT,
+    previous_power_level INTEGER,
 
     PRIMARY KEY (target_user, in_room_id, action_type)
 );
 
 CREATE INDEX taken_action_list_idx ON taken_action (policy_list);
 CREATE INDEX taken_action_entity_idx ON taken_action (policy_list, rule_entity);
+CREATE INDEX taken_action_expiry_idx ON taken_action (action_type, expires_at);
+
+CREATE TABLE action_queue (
+    id              TEXT    PRIMARY KEY NOT NULL,
+    management_room TEXT    NOT NULL,
+    action_type     TEXT    NOT NULL,
+    room_id         TEXT    NOT NULL,
+    target          TEXT    NOT NULL,
+    payload         TEXT    NOT NULL,
+    attempts        INTEGER NOT NULL,
+    next_attempt_at BIGINT  NOT NULL,
+    last_error      TEXT    NOT NULL,
+    created_at      BIGINT  NOT NULL,
+
+    CONSTRAINT action_queue_management_room_fkey FOREIGN KEY (management_room) REFERENCES management_room (room_id)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX action_queue_due_idx ON action_queue (management_room, next_attempt_at);
+
+CREATE TABLE pending_confirmation (
+    event_id         TEXT   PRIMARY KEY NOT NULL,
+    management_room  TEXT   NOT NULL,
+    command_event_id TEXT   NOT NULL,
+    sender           TEXT   NOT NULL,
+    command          TEXT   NOT NULL,
+    summary          TEXT   NOT NULL,
+    created_at       BIGINT NOT NULL,
+    expires_at       BIGINT NOT NULL,
+
+    CONSTRAINT pending_confirmation_management_room_fkey FOREIGN KEY (management_room) REFERENCES management_room (room_id)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX pending_confirmation_expiry_idx ON pending_confirmation (management_room, expires_at);
+
+CREATE TABLE moderation_log (
+    management_room  TEXT   NOT NULL,
+    command_event_id TEXT   NOT NULL,
+    moderator        TEXT   NOT NULL,
+    action           TEXT   NOT NULL,
+    target_user      TEXT   NOT NULL,
+    room_id          TEXT   NOT NULL,
+    reason           TEXT   NOT NULL,
+    taken_at         BIGINT NOT NULL,
+
+    PRIMARY KEY (command_event_id, action, target_user, room_id),
+    CONSTRAINT moderation_log_management_room_fkey FOREIGN KEY (management_room) REFERENCES management_room (room_id)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX moderation_log_time_idx ON moderation_log (management_room, taken_at);
+CREATE INDEX moderation_log_target_idx ON moderation_log (management_room, target_user);
+
+CREATE TABLE room_lockdown (
+    room_id                 TEXT    PRIMARY KEY NOT NULL,
+    management_room         TEXT    NOT NULL,
+    mode                    TEXT    NOT NULL,
+    previous_join_rules     TEXT,
+    previous_events_default INTEGER,
+    locked_by               TEXT    NOT NULL,
+    reason                  TEXT    NOT NULL,
+    locked_at               BIGINT  NOT NULL,
+
+    CONSTRAINT room_lockdown_management_room_fkey FOREIGN KEY (management_room) REFERENCES management_room (room_id)
+        ON DELETE CASCADE
+);
+
+CREATE TABLE note (
+    event_id        TEXT   PRIMARY KEY NOT NULL,
+    management_room TEXT   NOT NULL,
+    entity          TEXT   NOT NULL,
+    author          TEXT   NOT NULL,
+    text            TEXT   NOT NULL,
+    created_at      BIGINT NOT NULL,
+
+    CONSTRAINT note_management_room_fkey FOREIGN KEY (management_room) REFERENCES management_room (room_id)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX note_entity_idx ON note (management_room, entity);
+
+CREATE TABLE held_list (
+    management_room TEXT   NOT NULL,
+    policy_list     TEXT   NOT NULL,
+    held_since      BIGINT NOT NULL,
+
+    PRIMARY KEY (management_room, policy_list),
+    CONSTRAINT held_list_management_room_fkey FOREIGN KEY (management_room) REFERENCES management_room (room_id)
+        ON DELETE CASCADE
+);
+
+CREATE TABLE held_policy (
+    management_room TEXT    NOT NULL,
+    policy_list     TEXT    NOT NULL,
+    event_id        TEXT    NOT NULL,
+    removed         BOOLEAN NOT NULL,
+    policy          TEXT    NOT NULL,
+
+    PRIMARY KEY (management_room, event_id),
+    CONSTRAINT held_policy_list_fkey FOREIGN KEY (management_room, policy_list)
+        REFERENCES held_list (management_room, policy_list) ON DELETE CASCADE
+);
@@ -0,0 +1,18 @@
+-- v1 -> v2 (compatible with v1+): Add action queue
+CREATE TABLE action_queue (
+    id              TEXT    PRIMARY KEY NOT NULL,
+    management_room TEXT    NOT NULL,
+    action_type     TEXT    NOT NULL,
+    room_id         TEXT    NOT NULL,
+    target          TEXT    NOT NULL,
+    payload         TEXT    NOT NULL,
+    attempts        INTEGER NOT NULL,
+    next_attempt_at BIGINT  NOT NULL,
+    last_error      TEXT    NOT NULL,
+    created_at      BIGINT  NOT NULL,
+
+    CONSTRAINT action_queue_management_room_fkey FOREIGN KEY (management_room) REFERENCES management_room (room_id)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX action_queue_due_idx ON action_queue (management_room, next_attempt_at);
@@ -0,0 +1,16 @@
+-- v2 -> v3 (compatible with v1+): Add pending command confirmations
+CREATE TABLE pending_confirmation (
+    event_id         TEXT   PRIMARY KEY NOT NULL,
+    management_room  TEXT   NOT NULL,
+    command_event_id TEXT   NOT NULL,
+    sender           TEXT   NOT NULL,
+    command          TEXT   NOT NULL,
+    summary          TEXT   NOT NULL,
+    created_at       BIGINT NOT NULL,
+    expires_at       BIGINT NOT NULL,
+
+    CONSTRAINT pending_confirmation_management_room_fkey FOREIGN KEY (management_room) REFERENCES management_room (room_id)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX pending_confirmation_expiry_idx ON pending_confirmation (management_room, expires_at);
@@ -0,0 +1,18 @@
+-- v3 -> v4 (compatible with v1+): Add audit log for manual moderation commands
+CREATE TABLE moderation_log (
+    management_room  TEXT   NOT NULL,
+    command_event_id TEXT   NOT NULL,
+    moderator        TEXT   NOT NULL,
+    action           TEXT   NOT NULL,
+    target_user      TEXT   NOT NULL,
+    room_id          TEXT   NOT NULL,
+    reason           TEXT   NOT NULL,
+    taken_at         BIGINT NOT NULL,
+
+    PRIMARY KEY (command_event_id, action, target_user, room_id),
+    CONSTRAINT moderation_log_management_room_fkey FOREIGN KEY (management_room) REFERENCES management_room (room_id)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX moderation_log_time_idx ON moderation_log (management_room, taken_at);
+CREATE INDEX moderation_log_target_idx ON moderation_log (management_room, target_user);
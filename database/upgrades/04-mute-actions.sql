@@ -0,0 +1,5 @@
+-- v4 -> v5 (compatible with v1+): Add expiry and previous power level to taken actions for mutes
+ALTER TABLE taken_action ADD COLUMN expires_at BIGINT;
+ALTER TABLE taken_action ADD COLUMN previous_power_level INTEGER;
+
+CREATE INDEX taken_action_expiry_idx ON taken_action (action_type, expires_at);
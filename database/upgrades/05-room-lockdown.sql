@@ -0,0 +1,14 @@
+-- v5 -> v6 (compatible with v1+): Add room lockdowns
+CREATE TABLE room_lockdown (
+    room_id                 TEXT    PRIMARY KEY NOT NULL,
+    management_room         TEXT    NOT NULL,
+    mode                    TEXT    NOT NULL,
+    previous_join_rules     TEXT,
+    previous_events_default INTEGER,
+    locked_by               TEXT    NOT NULL,
+    reason                  TEXT    NOT NULL,
+    locked_at               BIGINT  NOT NULL,
+
+    CONSTRAINT room_lockdown_management_room_fkey FOREIGN KEY (management_room) REFERENCES management_room (room_id)
+        ON DELETE CASCADE
+);
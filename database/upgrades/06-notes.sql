@@ -0,0 +1,14 @@
+-- v6 -> v7 (compatible with v1+): Add moderator notes
+CREATE TABLE note (
+    event_id        TEXT   PRIMARY KEY NOT NULL,
+    management_room TEXT   NOT NULL,
+    entity          TEXT   NOT NULL,
+    author          TEXT   NOT NULL,
+    text            TEXT   NOT NULL,
+    created_at      BIGINT NOT NULL,
+
+    CONSTRAINT note_management_room_fkey FOREIGN KEY (management_room) REFERENCES management_room (room_id)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX note_entity_idx ON note (management_room, entity);
@@ -0,0 +1,22 @@
+-- v7 -> v8 (compatible with v1+): Persist held policy lists
+CREATE TABLE held_list (
+    management_room TEXT   NOT NULL,
+    policy_list     TEXT   NOT NULL,
+    held_since      BIGINT NOT NULL,
+
+    PRIMARY KEY (management_room, policy_list),
+    CONSTRAINT held_list_management_room_fkey FOREIGN KEY (management_room) REFERENCES management_room (room_id)
+        ON DELETE CASCADE
+);
+
+CREATE TABLE held_policy (
+    management_room TEXT    NOT NULL,
+    policy_list     TEXT    NOT NULL,
+    event_id        TEXT    NOT NULL,
+    removed         BOOLEAN NOT NULL,
+    policy          TEXT    NOT NULL,
+
+    PRIMARY KEY (management_room, event_id),
+    CONSTRAINT held_policy_list_fkey FOREIGN KEY (management_room, policy_list)
+        REFERENCES held_list (management_room, policy_list) ON DELETE CASCADE
+);
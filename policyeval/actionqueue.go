@@ -0,0 +1,345 @@
+package policyeval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/config"
+	"go.mau.fi/meowlnir/database"
+)
+
+const (
+	actionRetryBaseDelay    = 5 * time.Second
+	actionRetryMaxDelay     = 1 * time.Hour
+	maxActionAttempts       = 10
+	actionLeaseTime         = 5 * time.Minute
+	actionQueuePollInterval = 15 * time.Second
+	actionQueueBatchSize    = 100
+)
+
+var errActionInFlight = errors.New("the same action is already in progress")
+
+// actionRetryDelay decides whether a failed action should be retried and how long to wait before the next attempt.
+//
+// Rate limits are retried after the time requested by the server, other client errors are considered permanent,
+// and everything else (network errors, server errors) is retried with exponential backoff.
+func actionRetryDelay(err error, attempts int) (time.Duration, bool) {
+	var httpErr mautrix.HTTPError
+	if errors.As(err, &httpErr) {
+		if httpErr.RespError != nil && httpErr.RespError.ErrCode == mautrix.MLimitExceeded.ErrCode {
+			if retryAfter, ok := httpErr.RespError.ExtraData["retry_after_ms"].(float64); ok && retryAfter > 0 {
+				return time.Duration(retryAfter) * time.Millisecond, true
+			}
+		} else if httpErr.Response != nil &&
+			httpErr.Response.StatusCode >= 400 &&
+			httpErr.Response.StatusCode < 500 &&
+			httpErr.Response.StatusCode != http.StatusTooManyRequests {
+			return 0, false
+		}
+	}
+	return min(actionRetryBaseDelay<<(attempts-1), actionRetryMaxDelay), true
+}
+
+func (pe *PolicyEvaluator) describeAction(qa *database.QueuedAction) string {
+	roomLink := fmt.Sprintf("[%s](%s)", qa.RoomID, qa.RoomID.URI().MatrixToURL())
+	switch qa.ActionType {
+	case database.QueuedActionTypeBan:
+		userID := id.UserID(qa.Target)
+		return fmt.Sprintf("ban [%s](%s) in %s for %s", userID, userID.URI().MatrixToURL(), roomLink, qa.Payload.Reason)
+	case database.QueuedActionTypeUnban:
+		userID := id.UserID(qa.Target)
+		return fmt.Sprintf("unban [%s](%s) in %s", userID, userID.URI().MatrixToURL(), roomLink)
+	case database.QueuedActionTypeRedact:
+		return fmt.Sprintf("redact [%s](%s) in %s", qa.Target, qa.RoomID.EventURI(id.EventID(qa.Target)).MatrixToURL(), roomLink)
+	case database.QueuedActionTypeServerACL:
+		return fmt.Sprintf("send server ACL to %s", roomLink)
+	default:
+		return fmt.Sprintf("execute unknown action %s in %s", qa.ActionType, roomLink)
+	}
+}
+
+// runAction persists the given action in the action queue and then tries to execute it immediately.
+//
+// If the attempt fails with a transient error, the action is left in the queue and retried in the background,
+// so a nil error means the action was executed now, while a non-nil error means it either failed permanently
+// or will be retried later.
+func (pe *PolicyEvaluator) runAction(ctx context.Context, qa *database.QueuedAction) error {
+	qa.ID = database.QueuedActionID(qa.ActionType, qa.RoomID, qa.Target)
+	qa.ManagementRoom = pe.ManagementRoom
+	if pe.DryRun {
+		return pe.executeAction(ctx, qa)
+	}
+	qa.CreatedAt = time.Now()
+	qa.NextAttemptAt = qa.CreatedAt.Add(actionLeaseTime)
+	if !pe.actionsInFlight.Add(qa.ID) {
+		// Overwriting the queue row or running the action now would race with the attempt in progress.
+		// The new action may also be the opposite of the running one (e.g. an unban after a ban),
+		// so it's queued to run after the current attempt instead of being dropped.
+		pe.supersededActionsLock.Lock()
+		pe.supersededActions[qa.ID] = qa
+		pe.supersededActionsLock.Unlock()
+		zerolog.Ctx(ctx).Debug().Str("queued_action_id", qa.ID).Msg("Action is already in progress, queued new version after it")
+		return errActionInFlight
+	}
+	defer pe.finishAction(ctx, qa.ID)
+	err := pe.DB.ActionQueue.Put(ctx, qa)
+	if err != nil {
+		// Still try to execute the action, it just won't survive a restart if it fails
+		zerolog.Ctx(ctx).Err(err).Any("queued_action", qa).Msg("Failed to save action to queue")
+	}
+	return pe.attemptAction(ctx, qa)
+}
+
+// finishAction marks the given action as no longer in progress.
+// If a newer version of the action was submitted during the attempt, it's queued to run on the next queue poll.
+func (pe *PolicyEvaluator) finishAction(ctx context.Context, actionID string) {
+	if next := pe.takeSupersedingAction(actionID); next != nil {
+		next.NextAttemptAt = time.Now()
+		if err := pe.DB.ActionQueue.Put(ctx, next); err != nil {
+			zerolog.Ctx(ctx).Err(err).Str("queued_action_id", actionID).Msg("Failed to queue superseding action")
+		}
+	}
+	pe.actionsInFlight.Remove(actionID)
+}
+
+// takeSupersedingAction returns and forgets the newest version of the given action
+// that was submitted while the action was in progress, or nil if there isn't one.
+func (pe *PolicyEvaluator) takeSupersedingAction(actionID string) *database.QueuedAction {
+	pe.supersededActionsLock.Lock()
+	defer pe.supersededActionsLock.Unlock()
+	next, ok := pe.supersededActions[actionID]
+	if ok {
+		delete(pe.supersededActions, actionID)
+	}
+	return next
+}
+
+func (pe *PolicyEvaluator) isActionSuperseded(actionID string) bool {
+	pe.supersededActionsLock.Lock()
+	defer pe.supersededActionsLock.Unlock()
+	_, ok := pe.supersededActions[actionID]
+	return ok
+}
+
+// runLeasedAction attempts an action from the queue that was leased by [PolicyEvaluator.retryDueActions].
+// If a newer version of the action was submitted while it was waiting, the old one is skipped
+// and the new one is queued in its place.
+func (pe *PolicyEvaluator) runLeasedAction(ctx context.Context, qa *database.QueuedAction) {
+	defer pe.finishAction(ctx, qa.ID)
+	if pe.isActionSuperseded(qa.ID) {
+		zerolog.Ctx(ctx).Debug().Str("queued_action_id", qa.ID).Msg("Queued action was superseded, not running it")
+		return
+	}
+	_ = pe.attemptAction(ctx, qa)
+}
+
+func (pe *PolicyEvaluator) attemptAction(ctx context.Context, qa *database.QueuedAction) error {
+	log := zerolog.Ctx(ctx).With().
+		Str("queued_action_id", qa.ID).
+		Str("action_type", string(qa.ActionType)).
+		Stringer("room_id", qa.RoomID).
+		Str("target", qa.Target).
+		Logger()
+	err := pe.executeAction(ctx, qa)
+	qa.Attempts++
+	if err == nil {
+		if dbErr := pe.DB.ActionQueue.Delete(ctx, qa); dbErr != nil {
+			log.Err(dbErr).Msg("Failed to delete executed action from queue")
+		}
+		return nil
+	}
+	var httpErr mautrix.HTTPError
+	if errors.As(err, &httpErr) {
+		err = httpErr
+	}
+	retryDelay, retryable := actionRetryDelay(err, qa.Attempts)
+	if retryable && qa.Attempts < maxActionAttempts {
+		qa.NextAttemptAt = time.Now().Add(retryDelay)
+		qa.LastError = err.Error()
+		log.Warn().Err(err).
+			Int("attempts", qa.Attempts).
+			Time("next_attempt_at", qa.NextAttemptAt).
+			Msg("Failed to execute action, will retry")
+		if dbErr := pe.DB.ActionQueue.Put(ctx, qa); dbErr != nil {
+			log.Err(dbErr).Msg("Failed to save action retry to queue")
+		}
+		// Redaction failures are summarized by the caller, only report them when giving up
+		if qa.ActionType != database.QueuedActionTypeRedact {
+			pe.sendCategoryNotice(
+				ctx, config.NoticeCategoryActions, "Failed to %s: %v (attempt %d/%d, retrying in %s)",
+				pe.describeAction(qa), err, qa.Attempts, maxActionAttempts, retryDelay.Round(time.Second),
+			)
+		}
+		return err
+	}
+	log.Err(err).Int("attempts", qa.Attempts).Msg("Failed to execute action")
+	if dbErr := pe.DB.ActionQueue.Delete(ctx, qa); dbErr != nil {
+		log.Err(dbErr).Msg("Failed to delete failed action from queue")
+	}
+	if qa.Attempts > 1 {
+		pe.sendCategoryNotice(ctx, config.NoticeCategoryActions, "Failed to %s: %v (gave up after %d attempts)", pe.describeAction(qa), err, qa.Attempts)
+	} else {
+		pe.sendCategoryNotice(ctx, config.NoticeCategoryActions, "Failed to %s: %v", pe.describeAction(qa), err)
+	}
+	return err
+}
+
+func (pe *PolicyEvaluator) executeAction(ctx context.Context, qa *database.QueuedAction) error {
+	switch qa.ActionType {
+	case database.QueuedActionTypeBan:
+		return pe.executeBan(ctx, qa)
+	case database.QueuedActionTypeUnban:
+		return pe.executeUnban(ctx, qa)
+	case database.QueuedActionTypeRedact:
+		return pe.executeRedact(ctx, qa)
+	case database.QueuedActionTypeServerACL:
+		return pe.executeServerACL(ctx, qa)
+	default:
+		return fmt.Errorf("unknown action type %q", qa.ActionType)
+	}
+}
+
+func (pe *PolicyEvaluator) executeBan(ctx context.Context, qa *database.QueuedAction) error {
+	userID := id.UserID(qa.Target)
+	if !pe.DryRun {
+		_, err := pe.Bot.BanUser(ctx, qa.RoomID, &mautrix.ReqBanUser{
+			Reason: filterReason(qa.Payload.Reason),
+			UserID: userID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	ta := &database.TakenAction{
+		TargetUser: userID,
+		InRoomID:   qa.RoomID,
+		ActionType: database.TakenActionTypeBanOrUnban,
+		PolicyList: qa.Payload.PolicyList,
+		RuleEntity: qa.Payload.RuleEntity,
+		Action:     qa.Payload.Recommendation,
+		TakenAt:    time.Now(),
+	}
+	err := pe.DB.TakenAction.Put(ctx, ta)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Any("taken_action", ta).Msg("Failed to save taken action")
+		pe.sendCategoryNotice(ctx, config.NoticeCategoryActions, "Banned [%s](%s) in [%s](%s) for %s, but failed to save to database: %v", userID, userID.URI().MatrixToURL(), qa.RoomID, qa.RoomID.URI().MatrixToURL(), qa.Payload.Reason, err)
+	} else {
+		zerolog.Ctx(ctx).Info().Any("taken_action", ta).Msg("Took action")
+		var notes string
+		if qa.Payload.ShowNotes {
+			notes = pe.notesSection(ctx, userID.String())
+		}
+		pe.sendCategoryNotice(ctx, config.NoticeCategoryActions, "Banned [%s](%s) in [%s](%s) for %s%s", userID, userID.URI().MatrixToURL(), qa.RoomID, qa.RoomID.URI().MatrixToURL(), qa.Payload.Reason, notes)
+	}
+	return nil
+}
+
+func (pe *PolicyEvaluator) executeUnban(ctx context.Context, qa *database.QueuedAction) error {
+	userID := id.UserID(qa.Target)
+	if !pe.DryRun && !pe.Bot.StateStore.IsMembership(ctx, qa.RoomID, userID, event.MembershipBan) {
+		zerolog.Ctx(ctx).Trace().Msg("User is not banned in room, skipping unban")
+	} else {
+		if !pe.DryRun {
+			_, err := pe.Bot.UnbanUser(ctx, qa.RoomID, &mautrix.ReqUnbanUser{
+				UserID: userID,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		zerolog.Ctx(ctx).Debug().Msg("Unbanned user")
+		pe.sendCategoryNotice(ctx, config.NoticeCategoryActions, "Unbanned [%s](%s) in [%s](%s)", userID, userID.URI().MatrixToURL(), qa.RoomID, qa.RoomID.URI().MatrixToURL())
+	}
+	err := pe.DB.TakenAction.Delete(ctx, userID, qa.RoomID, database.TakenActionTypeBanOrUnban)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to delete taken action after unbanning")
+	} else {
+		zerolog.Ctx(ctx).Trace().Msg("Deleted taken action after unbanning")
+	}
+	return nil
+}
+
+func (pe *PolicyEvaluator) executeRedact(ctx context.Context, qa *database.QueuedAction) error {
+	var resp *mautrix.RespSendEvent
+	if !pe.DryRun {
+		var err error
+		resp, err = pe.Bot.RedactEvent(ctx, qa.RoomID, id.EventID(qa.Target), mautrix.ReqRedact{Reason: qa.Payload.Reason})
+		if err != nil {
+			return err
+		}
+	} else {
+		resp = &mautrix.RespSendEvent{EventID: "$fake-redaction-id"}
+	}
+	zerolog.Ctx(ctx).Debug().
+		Stringer("room_id", qa.RoomID).
+		Str("event_id", qa.Target).
+		Stringer("redaction_id", resp.EventID).
+		Msg("Successfully redacted event")
+	return nil
+}
+
+func (pe *PolicyEvaluator) executeServerACL(ctx context.Context, qa *database.QueuedAction) error {
+	if pe.DryRun {
+		zerolog.Ctx(ctx).Debug().
+			Stringer("room_id", qa.RoomID).
+			Msg("Dry run: would send server ACL to room")
+		return nil
+	}
+	resp, err := pe.Bot.SendStateEvent(ctx, qa.RoomID, event.StateServerACL, "", qa.Payload.ServerACL)
+	if err != nil {
+		return err
+	}
+	zerolog.Ctx(ctx).Debug().
+		Stringer("room_id", qa.RoomID).
+		Stringer("event_id", resp.EventID).
+		Msg("Sent new server ACL to room")
+	return nil
+}
+
+func (pe *PolicyEvaluator) actionQueueLoop() {
+	ctx := pe.Bot.Log.With().
+		Str("action", "action queue").
+		Stringer("management_room", pe.ManagementRoom).
+		Logger().
+		WithContext(context.Background())
+	ticker := time.NewTicker(actionQueuePollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		pe.retryDueActions(ctx)
+	}
+}
+
+func (pe *PolicyEvaluator) retryDueActions(ctx context.Context) {
+	if pe.DryRun {
+		return
+	}
+	actions, err := pe.DB.ActionQueue.GetDue(ctx, pe.ManagementRoom, time.Now(), actionQueueBatchSize)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get due actions from queue")
+		return
+	}
+	for _, qa := range actions {
+		if !pe.actionsInFlight.Add(qa.ID) {
+			continue
+		}
+		// Lease the action so that it isn't picked up again while it's waiting in the room's lane
+		qa.NextAttemptAt = time.Now().Add(actionLeaseTime)
+		err = pe.DB.ActionQueue.Put(ctx, qa)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Str("queued_action_id", qa.ID).Msg("Failed to lease queued action")
+			pe.finishAction(ctx, qa.ID)
+			continue
+		}
+		pe.roomActions.Submit(qa.RoomID, func() {
+			pe.runLeasedAction(ctx, qa)
+		})
+	}
+}
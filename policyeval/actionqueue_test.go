@@ -0,0 +1,49 @@
+package policyeval
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mau.fi/util/exsync"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/database"
+)
+
+func TestPolicyEvaluator_RunActionSupersedesInFlight(t *testing.T) {
+	const room id.RoomID = "!room:example.com"
+	const user = "@spam:example.com"
+	pe := &PolicyEvaluator{
+		ManagementRoom:    "!management:example.com",
+		actionsInFlight:   exsync.NewSet[string](),
+		supersededActions: make(map[string]*database.QueuedAction),
+	}
+	ctx := context.Background()
+	// Mark the ban as in progress like retryDueActions does when handing it to a room lane
+	banID := database.QueuedActionID(database.QueuedActionTypeBan, room, user)
+	pe.actionsInFlight.Add(banID)
+
+	err := pe.runAction(ctx, &database.QueuedAction{ActionType: database.QueuedActionTypeUnban, RoomID: room, Target: user})
+	if !errors.Is(err, errActionInFlight) {
+		t.Fatalf("runAction() error = %v; expected %v", err, errActionInFlight)
+	}
+	if !pe.isActionSuperseded(banID) {
+		t.Fatal("Unban submitted during ban wasn't queued after it")
+	}
+	next := pe.takeSupersedingAction(banID)
+	if next == nil || next.ActionType != database.QueuedActionTypeUnban || next.ID != banID {
+		t.Fatalf("takeSupersedingAction() = %+v; expected unban with ID %q", next, banID)
+	}
+	if pe.takeSupersedingAction(banID) != nil {
+		t.Error("takeSupersedingAction() didn't forget the returned action")
+	}
+
+	// Only the newest intent is kept if there are multiple submissions during one attempt
+	for _, actionType := range []database.QueuedActionType{database.QueuedActionTypeUnban, database.QueuedActionTypeBan} {
+		_ = pe.runAction(ctx, &database.QueuedAction{ActionType: actionType, RoomID: room, Target: user})
+	}
+	if next = pe.takeSupersedingAction(banID); next == nil || next.ActionType != database.QueuedActionTypeBan {
+		t.Errorf("takeSupersedingAction() = %+v; expected the newest ban", next)
+	}
+}
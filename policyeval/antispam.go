@@ -11,6 +11,7 @@ import (
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
+	"go.mau.fi/meowlnir/config"
 	"go.mau.fi/meowlnir/policylist"
 	"go.mau.fi/meowlnir/util"
 )
@@ -45,8 +46,8 @@ func (pe *PolicyEvaluator) HandleUserMayInvite(ctx context.Context, inviter, inv
 
 	defer func() {
 		if rec != nil {
-			go pe.sendNotice(
-				context.WithoutCancel(ctx),
+			go pe.sendCategoryNotice(
+				context.WithoutCancel(ctx), config.NoticeCategoryAntispam,
 				"Blocked [%s](%s) from inviting [%s](%s) to [%s](%s) due to policy banning `%s` for `%s`",
 				inviter, inviter.URI().MatrixToURL(),
 				invitee, invitee.URI().MatrixToURL(),
@@ -56,7 +57,7 @@ func (pe *PolicyEvaluator) HandleUserMayInvite(ctx context.Context, inviter, inv
 		}
 	}()
 
-	if rec = pe.Store.MatchUser(lists, inviter).Recommendations().BanOrUnban; rec != nil && rec.Recommendation != event.PolicyRecommendationUnban {
+	if rec = pe.recommendations(pe.matchUser(lists, inviter)).BanOrUnban; rec != nil && rec.Recommendation != event.PolicyRecommendationUnban {
 		log.Debug().
 			Str("policy_entity", rec.EntityOrHash()).
 			Str("policy_reason", rec.Reason).
@@ -64,7 +65,7 @@ func (pe *PolicyEvaluator) HandleUserMayInvite(ctx context.Context, inviter, inv
 		return ptr.Ptr(mautrix.MForbidden.WithMessage("You're not allowed to send invites"))
 	}
 
-	if rec = pe.Store.MatchRoom(lists, roomID).Recommendations().BanOrUnban; rec != nil && rec.Recommendation != event.PolicyRecommendationUnban {
+	if rec = pe.recommendations(pe.matchRoom(lists, roomID)).BanOrUnban; rec != nil && rec.Recommendation != event.PolicyRecommendationUnban {
 		log.Debug().
 			Str("policy_entity", rec.EntityOrHash()).
 			Str("policy_reason", rec.Reason).
@@ -72,7 +73,7 @@ func (pe *PolicyEvaluator) HandleUserMayInvite(ctx context.Context, inviter, inv
 		return ptr.Ptr(mautrix.MForbidden.WithMessage("Inviting users to this room is not allowed"))
 	}
 
-	if rec = pe.Store.MatchServer(lists, inviterServer).Recommendations().BanOrUnban; rec != nil && rec.Recommendation != event.PolicyRecommendationUnban {
+	if rec = pe.recommendations(pe.matchServer(lists, inviterServer)).BanOrUnban; rec != nil && rec.Recommendation != event.PolicyRecommendationUnban {
 		log.Debug().
 			Str("policy_entity", rec.EntityOrHash()).
 			Str("policy_reason", rec.Reason).
@@ -83,7 +84,7 @@ func (pe *PolicyEvaluator) HandleUserMayInvite(ctx context.Context, inviter, inv
 	// Parsing room IDs is generally not allowed, but in this case,
 	// if a room was created on a banned server, there's no reason to allow invites to it.
 	_, _, roomServer := id.ParseCommonIdentifier(roomID)
-	if rec = pe.Store.MatchServer(lists, roomServer).Recommendations().BanOrUnban; rec != nil && rec.Recommendation != event.PolicyRecommendationUnban {
+	if rec = pe.recommendations(pe.matchServer(lists, roomServer)).BanOrUnban; rec != nil && rec.Recommendation != event.PolicyRecommendationUnban {
 		log.Debug().
 			Str("policy_entity", rec.EntityOrHash()).
 			Str("policy_reason", rec.Reason).
@@ -115,9 +116,9 @@ func (pe *PolicyEvaluator) HandleUserMayInvite(ctx context.Context, inviter, inv
 
 func (pe *PolicyEvaluator) HandleAcceptMakeJoin(ctx context.Context, roomID id.RoomID, userID id.UserID) *mautrix.RespError {
 	lists := pe.GetWatchedLists()
-	rec := pe.Store.MatchUser(lists, userID).Recommendations().BanOrUnban
+	rec := pe.recommendations(pe.matchUser(lists, userID)).BanOrUnban
 	if rec == nil {
-		rec = pe.Store.MatchServer(lists, userID.Homeserver()).Recommendations().BanOrUnban
+		rec = pe.recommendations(pe.matchServer(lists, userID.Homeserver())).BanOrUnban
 	}
 	if rec != nil && rec.Recommendation != event.PolicyRecommendationUnban {
 		zerolog.Ctx(ctx).Debug().
@@ -126,8 +127,8 @@ func (pe *PolicyEvaluator) HandleAcceptMakeJoin(ctx context.Context, roomID id.R
 			Str("policy_entity", rec.EntityOrHash()).
 			Str("policy_reason", rec.Reason).
 			Msg("Blocking restricted join from banned user")
-		go pe.sendNotice(
-			context.WithoutCancel(ctx),
+		go pe.sendCategoryNotice(
+			context.WithoutCancel(ctx), config.NoticeCategoryAntispam,
 			"Blocked [%s](%s) from joining [%s](%s) due to policy banning `%s` for `%s`",
 			userID, userID.URI().MatrixToURL(),
 			roomID, roomID.URI().MatrixToURL(),
@@ -225,8 +226,8 @@ func (pe *PolicyEvaluator) RejectPendingInvites(ctx context.Context, inviter id.
 				successfullyRejected++
 			}
 		}
-		pe.sendNotice(
-			ctx,
+		pe.sendCategoryNotice(
+			ctx, config.NoticeCategoryAntispam,
 			"Rejected %d/%d invites to [%s](%s) from [%s](%s) due to policy banning `%s` for `%s`",
 			successfullyRejected, len(rooms),
 			userID, userID.URI().MatrixToURL(),
@@ -1,6 +1,8 @@
 package policyeval
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"crypto/sha256"
 	"encoding/base64"
@@ -20,7 +22,9 @@ import (
 	"maunium.net/go/mautrix/id"
 	"maunium.net/go/mautrix/synapseadmin"
 
+	"go.mau.fi/meowlnir/bot"
 	"go.mau.fi/meowlnir/config"
+	"go.mau.fi/meowlnir/database"
 	"go.mau.fi/meowlnir/policylist"
 	"go.mau.fi/meowlnir/util"
 )
@@ -31,27 +35,35 @@ type CommandHandler = commands.Handler[*PolicyEvaluator]
 const SuccessReaction = "✅"
 
 func (pe *PolicyEvaluator) HandleCommand(ctx context.Context, evt *event.Event) {
+	if !pe.checkEventTrust(ctx, evt) || !pe.checkCommandPermission(ctx, evt) {
+		return
+	}
+	pe.commandProcessor.Process(ctx, evt)
+}
+
+// checkEventTrust checks that an event in the management room was encrypted and sent from a trusted device.
+func (pe *PolicyEvaluator) checkEventTrust(ctx context.Context, evt *event.Event) bool {
 	if !evt.Mautrix.WasEncrypted && pe.Bot.CryptoHelper != nil {
 		zerolog.Ctx(ctx).Warn().
-			Msg("Dropping unencrypted command event")
-		return
+			Stringer("event_type", &evt.Type).
+			Msg("Dropping unencrypted management room event")
+		return false
 	} else if evt.Mautrix.WasEncrypted && evt.Mautrix.TrustState < id.TrustStateCrossSignedTOFU {
 		zerolog.Ctx(ctx).Warn().
+			Stringer("event_type", &evt.Type).
 			Stringer("trust_state", evt.Mautrix.TrustState).
 			Msg("Dropping encrypted event with insufficient trust state")
-		return
+		return false
 	}
-	pe.commandProcessor.Process(ctx, evt)
+	return true
 }
 
-var cmdJoin = &CommandHandler{
-	Name: "join",
-	Func: func(ce *CommandEvent) {
-		if len(ce.Args) == 0 {
-			ce.Reply("Usage: `!join <room ID>...`")
-			return
-		}
-		for _, arg := range ce.Args {
+var cmdJoin = &Command{
+	Name:        "join",
+	Description: "Join a room",
+	Args:        []ArgSpec{{Name: "rooms", Variadic: true}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		for _, arg := range args.List("rooms") {
 			_, err := ce.Meta.Bot.JoinRoom(ce.Ctx, arg, nil)
 			if err != nil {
 				ce.Reply("Failed to join room %s: %v", format.SafeMarkdownCode(arg), err)
@@ -63,14 +75,12 @@ var cmdJoin = &CommandHandler{
 	},
 }
 
-var cmdKnock = &CommandHandler{
-	Name: "knock",
-	Func: func(ce *CommandEvent) {
-		if len(ce.Args) == 0 {
-			ce.Reply("Usage: `!knock <rooms...>`")
-			return
-		}
-		for _, arg := range ce.Args {
+var cmdKnock = &Command{
+	Name:        "knock",
+	Description: "Ask to join a room",
+	Args:        []ArgSpec{{Name: "rooms", Variadic: true}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		for _, arg := range args.List("rooms") {
 			_, err := ce.Meta.Bot.KnockRoom(ce.Ctx, arg, nil)
 			if err != nil {
 				ce.Reply("Failed to knock on room %s: %v", format.SafeMarkdownCode(arg), err)
@@ -82,51 +92,65 @@ var cmdKnock = &CommandHandler{
 	},
 }
 
-var cmdLeave = &CommandHandler{
-	Name: "leave",
-	Func: func(ce *CommandEvent) {
-		if len(ce.Args) == 0 {
-			ce.Reply("Usage: `!leave <room ID>...`")
-			return
-		}
-		for _, arg := range ce.Args {
+var cmdLeave = &Command{
+	Name:        "leave",
+	Description: "Leave a room",
+	Help:        "The bot will ask for confirmation before leaving.",
+	Args:        []ArgSpec{{Name: "rooms", Variadic: true}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		rooms := args.List("rooms")
+		targets := make([]id.RoomID, 0, len(rooms))
+		targetNames := make([]string, 0, len(rooms))
+		for _, arg := range rooms {
 			target := resolveRoom(ce, arg)
 			if target == "" {
-				continue
+				return
 			}
+			targets = append(targets, target)
+			targetNames = append(targetNames, format.SafeMarkdownCode(arg))
+		}
+		if !ce.Meta.confirm(ce, "This will make the bot leave %s.", strings.Join(targetNames, ", ")) {
+			return
+		}
+		for i, target := range targets {
 			_, err := ce.Meta.Bot.LeaveRoom(ce.Ctx, target)
 			if err != nil {
-				ce.Reply("Failed to leave room %s: %v", format.SafeMarkdownCode(arg), err)
+				ce.Reply("Failed to leave room %s: %v", targetNames[i], err)
 			} else {
-				ce.Reply("Left room %s", format.SafeMarkdownCode(arg))
+				ce.Reply("Left room %s", targetNames[i])
 			}
 		}
 	},
 }
 
-var cmdPowerLevel = &CommandHandler{
-	Name:    "powerlevel",
-	Aliases: []string{"pl"},
-	Func: func(ce *CommandEvent) {
-
-		if len(ce.Args) < 1 {
-			ce.Reply("Usage: `!powerlevel <room|all> <key> <level>`")
-			return
-		}
+var cmdPowerLevel = &Command{
+	Name:        "powerlevel",
+	Aliases:     []string{"pl"},
+	Description: "Set a power level",
+	Help: "The key can be `invite`, `kick`, `ban`, `redact`, `users_default`, `state_default`, `events_default`, " +
+		"`notifications.room`, a user ID or an event type. Setting a power level in all protected rooms requires confirmation.",
+	Args: []ArgSpec{{Name: "room|all"}, {Name: "key"}, {Name: "level"}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
 		var rooms []id.RoomID
-		if ce.Args[0] == "all" {
+		allRooms := args.Get("room|all") == "all"
+		if allRooms {
 			rooms = ce.Meta.GetProtectedRooms()
 		} else {
-			room := resolveRoom(ce, ce.Args[0])
+			room := resolveRoom(ce, args.Get("room|all"))
 			if room == "" {
 				return
 			}
 			rooms = []id.RoomID{room}
 		}
-		key := ce.Args[1]
-		level, err := strconv.Atoi(ce.Args[2])
+		key := args.Get("key")
+		level, err := strconv.Atoi(args.Get("level"))
 		if err != nil {
-			ce.Reply("Invalid power level %s: %v", format.SafeMarkdownCode(ce.Args[2]), err)
+			args.ReplyUsage(ce, "Invalid power level %s: %v", format.SafeMarkdownCode(args.Get("level")), err)
+			return
+		}
+		if allRooms && !ce.Meta.confirm(
+			ce, "This will set %s to %d in all %d protected rooms.", format.SafeMarkdownCode(key), level, len(rooms),
+		) {
 			return
 		}
 		for _, room := range rooms {
@@ -201,28 +225,27 @@ var cmdPowerLevel = &CommandHandler{
 	},
 }
 
-var cmdRedact = &CommandHandler{
-	Name: "redact",
-	Func: func(ce *CommandEvent) {
-		if len(ce.Args) < 1 {
-			ce.Reply("Usage: `!redact <event link or user ID> [reason]`")
-			return
-		}
+var cmdRedact = &Command{
+	Name:        "redact",
+	Description: "Redact all messages from a user, or a single event",
+	Args:        []ArgSpec{{Name: "event link or user ID"}, {Name: "reason", Optional: true, Variadic: true}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		rawTarget := args.Get("event link or user ID")
 		var target *id.MatrixURI
 		var err error
-		if ce.Args[0][0] == '@' {
+		if rawTarget[0] == '@' {
 			target = &id.MatrixURI{
 				Sigil1: '@',
-				MXID1:  ce.Args[0],
+				MXID1:  rawTarget,
 			}
 		} else {
-			target, err = id.ParseMatrixURIOrMatrixToURL(ce.Args[0])
+			target, err = id.ParseMatrixURIOrMatrixToURL(rawTarget)
 			if err != nil {
-				ce.Reply("Failed to parse %s: %v", format.SafeMarkdownCode(ce.Args[0]), err)
+				ce.Reply("Failed to parse %s: %v", format.SafeMarkdownCode(rawTarget), err)
 				return
 			}
 		}
-		reason := strings.Join(ce.Args[1:], " ")
+		reason := args.Text("reason")
 		if target.Sigil1 == '@' {
 			ce.Meta.RedactUser(ce.Ctx, target.UserID(), reason, false)
 		} else if target.Sigil1 == '!' && target.Sigil2 == '$' {
@@ -232,30 +255,28 @@ var cmdRedact = &CommandHandler{
 				return
 			}
 		} else {
-			ce.Reply("Invalid target %s (must be a user ID or event link)", format.SafeMarkdownCode(ce.Args[0]))
+			ce.Reply("Invalid target %s (must be a user ID or event link)", format.SafeMarkdownCode(rawTarget))
 			return
 		}
 		ce.React(SuccessReaction)
 	},
 }
 
-var cmdRedactRecent = &CommandHandler{
-	Name: "redact-recent",
-	Func: func(ce *CommandEvent) {
-		if len(ce.Args) < 2 {
-			ce.Reply("Usage: `!redact-recent <room ID> <since duration> [reason]`")
-			return
-		}
-		room := resolveRoom(ce, ce.Args[0])
+var cmdRedactRecent = &Command{
+	Name:        "redact-recent",
+	Description: "Redact all recent messages in a room",
+	Args:        []ArgSpec{{Name: "room"}, {Name: "since duration"}, {Name: "reason", Optional: true, Variadic: true}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		room := resolveRoom(ce, args.Get("room"))
 		if room == "" {
 			return
 		}
-		since, err := time.ParseDuration(ce.Args[1])
+		since, err := time.ParseDuration(args.Get("since duration"))
 		if err != nil {
-			ce.Reply("Invalid duration %s: %v", format.SafeMarkdownCode(ce.Args[1]), err)
+			args.ReplyUsage(ce, "Invalid duration %s: %v", format.SafeMarkdownCode(args.Get("since duration")), err)
 			return
 		}
-		reason := strings.Join(ce.Args[2:], " ")
+		reason := args.Text("reason")
 		redactedCount, err := ce.Meta.redactRecentMessages(ce.Ctx, room, "", since, false, reason)
 		if err != nil {
 			ce.Reply("Failed to redact recent messages: %v", err)
@@ -266,29 +287,42 @@ var cmdRedactRecent = &CommandHandler{
 	},
 }
 
-var cmdKick = &CommandHandler{
-	Name: "kick",
-	Func: func(ce *CommandEvent) {
-		if len(ce.Args) < 1 {
-			ce.Reply("Usage: `!kick <user ID> [reason]`")
+var cmdKick = &Command{
+	Name:        "kick",
+	Description: "Kick a user from all rooms",
+	Help:        "The user ID can be a glob. Kicking more than 10 users at once requires confirmation.",
+	Args:        []ArgSpec{{Name: "user ID"}, {Name: "reason", Optional: true, Variadic: true}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		rawPattern := args.Get("user ID")
+		pattern := glob.Compile(rawPattern)
+		reason := args.Text("reason")
+		users := slices.Collect(ce.Meta.findMatchingUsers(pattern, nil, true))
+		if len(users) == 0 {
+			ce.Reply("No users matching %s found in any rooms", format.SafeMarkdownCode(rawPattern))
+			return
+		} else if len(users) > wideTargetThreshold && !ce.Meta.confirm(
+			ce, "This will kick %d users matching %s from all protected rooms.", len(users), format.SafeMarkdownCode(rawPattern),
+		) {
 			return
 		}
-		ignoreUserLimit := ce.Args[0] == "--force"
-		if ignoreUserLimit {
-			ce.Args = ce.Args[1:]
+		// Kicking a single user reports directly, multiple users get a progress message with per-user details in a thread
+		var progress *bot.Progress
+		if len(users) > 1 {
+			progress = ce.Meta.Bot.StartProgress(ce.Ctx, ce.RoomID, fmt.Sprintf("Kicking users matching %s", format.SafeMarkdownCode(rawPattern)), len(users))
 		}
-		pattern := glob.Compile(ce.Args[0])
-		reason := strings.Join(ce.Args[1:], " ")
-		users := slices.Collect(ce.Meta.findMatchingUsers(pattern, nil, true))
-		if len(users) > 10 && !ignoreUserLimit {
-			// TODO replace the force flag with a reaction confirmation
-			ce.Reply("%d users matching %s found, use `--force` to kick all of them.", len(users), format.SafeMarkdownCode(ce.Args[0]))
-			return
+		reply := func(message string, args ...any) {
+			if progress != nil {
+				progress.Detail(ce.Ctx, message, args...)
+			} else {
+				ce.Reply(message, args...)
+			}
 		}
+		var totalKicks int
 		for _, userID := range users {
 			successCount := 0
 			rooms := ce.Meta.getRoomsUserIsIn(userID)
 			if len(rooms) == 0 {
+				progress.Add(ce.Ctx, 1, 0)
 				continue
 			}
 			roomStrings := make([]string, len(rooms))
@@ -302,55 +336,396 @@ var cmdKick = &CommandHandler{
 					})
 				}
 				if err != nil {
-					ce.Reply("Failed to kick %s from %s: %v", format.SafeMarkdownCode(userID), format.SafeMarkdownCode(room), err)
+					reply("Failed to kick %s from %s: %v", format.SafeMarkdownCode(userID), format.SafeMarkdownCode(room), err)
 				} else {
 					successCount++
 				}
 			}
-			ce.Reply("Kicked %s from %d rooms: %s", format.SafeMarkdownCode(userID), successCount, strings.Join(roomStrings, ", "))
+			totalKicks += successCount
+			if successCount < len(rooms) {
+				progress.Add(ce.Ctx, 0, 1)
+			} else {
+				progress.Add(ce.Ctx, 1, 0)
+			}
+			reply("Kicked %s from %d rooms: %s", format.SafeMarkdownCode(userID), successCount, strings.Join(roomStrings, ", "))
 		}
-		if len(users) == 0 {
-			ce.Reply("No users matching %s found in any rooms", format.SafeMarkdownCode(ce.Args[0]))
+		progress.Finish(ce.Ctx, "kicked %d users (%d kicks in total)", len(users), totalKicks)
+		ce.React(SuccessReaction)
+	},
+}
+
+var cmdRoomBan = &Command{
+	Name:        "room-ban",
+	Aliases:     []string{"room-unban"},
+	Description: "Ban or unban a user directly in protected rooms without publishing a policy",
+	Help:        scopeHelp + " Actions are recorded in the moderation log, which can be viewed with `!audit`.",
+	Args:        []ArgSpec{{Name: "user ID"}, {Name: "reason", Optional: true, Variadic: true}},
+	Flags:       scopeFlags,
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		userID, ok := parseUserIDArg(ce, args)
+		if !ok {
+			return
+		}
+		rooms, scope, ok := ce.Meta.resolveCommandScope(ce, args)
+		if !ok {
+			return
+		}
+		action := database.ModerationActionBan
+		if ce.Command == "room-unban" {
+			action = database.ModerationActionUnban
+		}
+		reason := args.Text("reason")
+		successCount, errs := ce.Meta.runScopedAction(ce, action, userID, reason, rooms, func(roomID id.RoomID) error {
+			qa := &database.QueuedAction{
+				ActionType: database.QueuedActionTypeUnban,
+				RoomID:     roomID,
+				Target:     string(userID),
+			}
+			if action == database.ModerationActionBan {
+				qa.ActionType = database.QueuedActionTypeBan
+				qa.Payload = database.QueuedActionPayload{
+					Reason:         reason,
+					Recommendation: event.PolicyRecommendationBan,
+					// Only show notes in one of the ban notices
+					ShowNotes: roomID == rooms[0],
+				}
+				if qa.Payload.Reason == "" {
+					qa.Payload.Reason = "<no reason supplied>"
+				}
+			}
+			return ce.Meta.runAction(ce.Ctx, qa)
+		})
+		ce.Meta.replyScopedAction(ce, action, userID, scope, successCount, errs)
+	},
+}
+
+var cmdMute = &Command{
+	Name:        "mute",
+	Description: "Mute a user in protected rooms by lowering their power level",
+	Help: "The user's power level is set one below the level required to send messages, " +
+		"and restored when the user is unmuted with `!unmute` or the optional duration (e.g. `30m`, `12h` or `7d`) expires. " +
+		scopeHelp + " Actions are recorded in the moderation log, which can be viewed with `!audit`.",
+	Args:  []ArgSpec{{Name: "user ID"}, {Name: "duration", Optional: true}, {Name: "reason", Optional: true, Variadic: true}},
+	Flags: scopeFlags,
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		userID, ok := parseUserIDArg(ce, args)
+		if !ok {
+			return
+		}
+		reason := args.Text("reason")
+		var expiresAt time.Time
+		if rawDuration := args.Get("duration"); rawDuration != "" {
+			// The duration is optional, so if it doesn't look like one, it's the first word of the reason
+			if duration, err := parseMuteDuration(rawDuration); err == nil {
+				expiresAt = time.Now().Add(duration)
+			} else {
+				reason = strings.TrimSpace(rawDuration + " " + reason)
+			}
+		}
+		rooms, scope, ok := ce.Meta.resolveCommandScope(ce, args)
+		if !ok {
+			return
+		}
+		successCount, errs := ce.Meta.runScopedAction(ce, database.ModerationActionMute, userID, reason, rooms, func(roomID id.RoomID) error {
+			return ce.Meta.ApplyMute(ce.Ctx, &database.TakenAction{
+				TargetUser: userID,
+				InRoomID:   roomID,
+				ExpiresAt:  expiresAt,
+			})
+		})
+		if !expiresAt.IsZero() {
+			scope += fmt.Sprintf(", until %s", expiresAt.UTC().Format(time.DateTime))
+		}
+		ce.Meta.replyScopedAction(ce, database.ModerationActionMute, userID, scope, successCount, errs)
+	},
+}
+
+var cmdUnmute = &Command{
+	Name:        "unmute",
+	Description: "Unmute a user muted by the bot and restore their previous power level",
+	Help:        scopeHelp + " Actions are recorded in the moderation log, which can be viewed with `!audit`.",
+	Args:        []ArgSpec{{Name: "user ID"}},
+	Flags:       scopeFlags,
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		userID, ok := parseUserIDArg(ce, args)
+		if !ok {
+			return
+		}
+		rooms, scope, ok := ce.Meta.resolveCommandScope(ce, args)
+		if !ok {
+			return
+		}
+		mutes, err := ce.Meta.DB.TakenAction.GetAllByTargetUser(ce.Ctx, userID, database.TakenActionTypeMute)
+		if err != nil {
+			ce.Reply("Failed to get mutes from database: %v", err)
+			return
+		}
+		rooms = slices.DeleteFunc(rooms, func(roomID id.RoomID) bool {
+			return !slices.ContainsFunc(mutes, func(ta *database.TakenAction) bool {
+				return ta.InRoomID == roomID
+			})
+		})
+		if len(rooms) == 0 {
+			ce.Reply("[%s](%s) hasn't been muted by the bot in %s", userID, userID.URI().MatrixToURL(), scope)
+			return
+		}
+		successCount, errs := ce.Meta.runScopedAction(ce, database.ModerationActionUnmute, userID, "", rooms, func(roomID id.RoomID) error {
+			return ce.Meta.UndoMute(ce.Ctx, userID, roomID)
+		})
+		ce.Meta.replyScopedAction(ce, database.ModerationActionUnmute, userID, scope, successCount, errs)
+	},
+}
+
+var cmdAudit = &Command{
+	Name:        "audit",
+	Aliases:     []string{"modlog"},
+	Description: "Show recent manual moderation actions, optionally only ones targeting the given user",
+	Args:        []ArgSpec{{Name: "user ID", Optional: true}},
+	Flags:       []FlagSpec{{Name: "limit", Description: "Maximum number of entries to fetch (default 50)", Value: "count"}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		limit := 50
+		if args.HasFlag("limit") {
+			var err error
+			limit, err = strconv.Atoi(args.Flag("limit"))
+			if err != nil || limit <= 0 {
+				args.ReplyUsage(ce, "Invalid limit %s", format.SafeMarkdownCode(args.Flag("limit")))
+				return
+			}
+		}
+		var entries []*database.ModerationLogEntry
+		var err error
+		if args.Get("user ID") != "" {
+			userID, ok := parseUserIDArg(ce, args)
+			if !ok {
+				return
+			}
+			entries, err = ce.Meta.DB.ModerationLog.GetByTarget(ce.Ctx, ce.Meta.ManagementRoom, userID, limit)
+		} else {
+			entries, err = ce.Meta.DB.ModerationLog.GetRecent(ce.Ctx, ce.Meta.ManagementRoom, limit)
+		}
+		if err != nil {
+			ce.Reply("Failed to get moderation log: %v", err)
+			return
+		} else if len(entries) == 0 {
+			ce.Reply("No moderation log entries found")
+			return
+		}
+		ce.Reply("Moderation log:\n\n%s", formatModerationLog(entries))
+	},
+}
+
+var cmdNote = &Command{
+	Name:        "note",
+	Description: "Write a note about a user, room or server",
+	Help:        "Notes are only stored in the Meowlnir database. They're shown in `!match` output, as well as in report and ban notices about the entity.",
+	Args:        []ArgSpec{{Name: "entity"}, {Name: "text", Variadic: true}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		entity := args.Get("entity")
+		if _, ok := validateEntity(entity); !ok {
+			args.ReplyUsage(ce, "Invalid entity %s", format.SafeMarkdownCode(entity))
+			return
+		}
+		note := &database.Note{
+			EventID:        ce.Event.ID,
+			ManagementRoom: ce.Meta.ManagementRoom,
+			Entity:         entity,
+			Author:         ce.Event.Sender,
+			Text:           args.Text("text"),
+			CreatedAt:      time.Now(),
+		}
+		err := ce.Meta.DB.Note.Put(ce.Ctx, note)
+		if err != nil {
+			zerolog.Ctx(ce.Ctx).Err(err).Any("note", note).Msg("Failed to save note")
+			ce.Reply("Failed to save note: %v", err)
 			return
 		}
 		ce.React(SuccessReaction)
 	},
 }
 
+var cmdNotes = &Command{
+	Name:        "notes",
+	Description: "List notes about a user, room or server",
+	Args:        []ArgSpec{{Name: "entity"}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		entity := args.Get("entity")
+		notes, err := ce.Meta.DB.Note.GetByEntity(ce.Ctx, ce.Meta.ManagementRoom, entity)
+		if err != nil {
+			ce.Reply("Failed to get notes: %v", err)
+			return
+		} else if len(notes) == 0 {
+			ce.Reply("No notes found about %s", format.SafeMarkdownCode(entity))
+			return
+		}
+		ce.Reply("Notes about %s:\n\n%s", format.SafeMarkdownCode(entity), formatNotes(notes))
+	},
+}
+
+var cmdLockdown = &Command{
+	Name:        "lockdown",
+	Description: "Lock down protected rooms during a raid",
+	Help: "The `invite` and `knock` modes change the join rules of the room, while the `mute` mode raises " +
+		"the power level required to send messages above the default user level. The default mode is the one " +
+		"configured for automatic raid lockdowns, or `invite` if there isn't one. Use `!unlock` to restore the rooms. " + scopeHelp,
+	Args: []ArgSpec{{Name: "reason", Optional: true, Variadic: true}},
+	Flags: append([]FlagSpec{{
+		Name:        "mode",
+		Description: "How to lock down the rooms",
+		Choices:     []string{string(config.LockdownModeInvite), string(config.LockdownModeKnock), string(config.LockdownModeMute)},
+	}}, scopeFlags...),
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		mode := config.LockdownMode(strings.ToLower(args.Flag("mode")))
+		if mode == "" {
+			if cfg := ce.Meta.getRaidProtection(); cfg != nil && slices.Contains(config.LockdownModes, cfg.Lockdown) {
+				mode = cfg.Lockdown
+			} else {
+				mode = config.LockdownModeInvite
+			}
+		}
+		rooms, scope, ok := ce.Meta.resolveCommandScope(ce, args)
+		if !ok {
+			return
+		}
+		successCount, errs := ce.Meta.runRoomAction(rooms, func(roomID id.RoomID) error {
+			return ce.Meta.LockdownRoom(ce.Ctx, roomID, mode, ce.Event.Sender, args.Text("reason"))
+		})
+		replyRoomAction(ce, fmt.Sprintf("Locked down (%s)", mode), scope, successCount, errs)
+	},
+}
+
+var cmdUnlock = &Command{
+	Name:        "unlock",
+	Description: "Lift lockdowns created with `!lockdown` or automatic raid protection",
+	Help:        scopeHelp,
+	Flags:       scopeFlags,
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		rooms, scope, ok := ce.Meta.resolveCommandScope(ce, args)
+		if !ok {
+			return
+		}
+		lockdowns, err := ce.Meta.DB.RoomLockdown.GetAll(ce.Ctx, ce.Meta.ManagementRoom)
+		if err != nil {
+			ce.Reply("Failed to get lockdowns from database: %v", err)
+			return
+		}
+		rooms = slices.DeleteFunc(rooms, func(roomID id.RoomID) bool {
+			return !slices.ContainsFunc(lockdowns, func(rl *database.RoomLockdown) bool {
+				return rl.RoomID == roomID
+			})
+		})
+		if len(rooms) == 0 {
+			ce.Reply("None of %s are locked down", scope)
+			return
+		}
+		successCount, errs := ce.Meta.runRoomAction(rooms, func(roomID id.RoomID) error {
+			return ce.Meta.UnlockRoom(ce.Ctx, roomID)
+		})
+		replyRoomAction(ce, "Unlocked", scope, successCount, errs)
+	},
+}
+
+func parseUserIDArg(ce *CommandEvent, args *CommandArgs) (id.UserID, bool) {
+	userID := id.UserID(args.Get("user ID"))
+	if _, _, err := userID.Parse(); err != nil {
+		args.ReplyUsage(ce, "Invalid user ID %s", format.SafeMarkdownCode(userID))
+		return "", false
+	}
+	return userID, true
+}
+
+func (pe *PolicyEvaluator) replyScopedAction(
+	ce *CommandEvent, action database.ModerationAction, userID id.UserID, scope string, successCount int, errs []string,
+) {
+	zerolog.Ctx(ce.Ctx).Info().
+		Str("moderation_action", string(action)).
+		Stringer("target_user_id", userID).
+		Int("success_count", successCount).
+		Int("error_count", len(errs)).
+		Msg("Executed manual moderation command")
+	message := fmt.Sprintf(
+		"Successfully %s [%s](%s) in %d/%d rooms (%s)",
+		describeModerationAction(action), userID, userID.URI().MatrixToURL(), successCount, successCount+len(errs), scope,
+	)
+	if len(errs) > 0 {
+		message += "\n\nErrors:\n\n" + strings.Join(errs, "\n")
+	}
+	ce.Reply(message)
+	if successCount > 0 {
+		ce.React(SuccessReaction)
+	}
+}
+
 func (pe *PolicyEvaluator) deduplicatePolicy(
 	ce *CommandEvent,
 	list *config.WatchedPolicyList,
 	policy *event.ModPolicyContent,
 ) (entityType policylist.EntityType, existingStateKey string, ok bool) {
-	entityType, ok = validateEntity(policy.Entity)
+	entityType, existingStateKey, problem := pe.checkPolicyDuplicate(list, policy)
+	if problem != "" {
+		ce.Reply(problem)
+		return entityType, "", false
+	}
+	return entityType, existingStateKey, true
+}
+
+// countGlobTargets returns the number of joined users in protected rooms that a user or server glob matches.
+// Non-glob entities always return zero.
+func (pe *PolicyEvaluator) countGlobTargets(entityType policylist.EntityType, entity string) (count int) {
+	pattern := glob.Compile(entity)
+	if _, isExact := pattern.(glob.ExactGlob); isExact {
+		return 0
+	}
+	switch entityType {
+	case policylist.EntityTypeUser:
+		for range pe.findMatchingUsers(pattern, nil, true) {
+			count++
+		}
+	case policylist.EntityTypeServer:
+		pe.protectedRoomsLock.RLock()
+		defer pe.protectedRoomsLock.RUnlock()
+		for userID, rooms := range pe.protectedRoomMembers {
+			if len(rooms) > 0 && pattern.Match(userID.Homeserver()) {
+				count++
+			}
+		}
+	}
+	return
+}
+
+// checkPolicyDuplicate checks whether the given policy can be sent to the list.
+// If the policy is invalid, already exists or conflicts with an existing policy,
+// a human-readable description of the problem is returned.
+func (pe *PolicyEvaluator) checkPolicyDuplicate(
+	list *config.WatchedPolicyList,
+	policy *event.ModPolicyContent,
+) (entityType policylist.EntityType, existingStateKey, problem string) {
+	entityType, ok := validateEntity(policy.Entity)
 	if !ok {
-		ce.Reply("Invalid entity %s", format.SafeMarkdownCode(policy.Entity))
-		return
+		return "", "", fmt.Sprintf("Invalid entity %s", format.SafeMarkdownCode(policy.Entity))
 	}
-	match := ce.Meta.Store.MatchExact([]id.RoomID{list.RoomID}, entityType, policy.Entity)
+	match := pe.Store.MatchExact([]id.RoomID{list.RoomID}, entityType, policy.Entity)
 	rec := match.Recommendations().BanOrUnban
 	if rec == nil {
-		return entityType, "", true
+		return entityType, "", ""
 	} else if rec.Recommendation == policy.Recommendation && rec.EntityOrHash() == policy.EntityOrHash() {
 		if rec.Reason == policy.Reason {
-			ce.Reply(
+			return entityType, "", fmt.Sprintf(
 				"%s already has a %s recommendation in [%s](%s) for %s (sent by [%s](%s) at %s)",
 				format.SafeMarkdownCode(policy.EntityOrHash()),
 				format.SafeMarkdownCode(rec.Recommendation),
 				format.EscapeMarkdown(list.Name),
-				list.RoomID.URI(ce.Meta.Bot.ServerName).MatrixToURL(),
+				list.RoomID.URI(pe.Bot.ServerName).MatrixToURL(),
 				format.SafeMarkdownCode(rec.Reason),
 				format.EscapeMarkdown(rec.Sender.String()),
 				rec.Sender.URI().MatrixToURL(),
 				time.UnixMilli(rec.Timestamp).String(),
 			)
-			return entityType, "", false
 		} else {
-			return entityType, rec.StateKey, true
+			return entityType, rec.StateKey, ""
 		}
 	} else if (policy.Recommendation != event.PolicyRecommendationUnban && rec.Recommendation == event.PolicyRecommendationUnban) ||
 		(policy.Recommendation == event.PolicyRecommendationUnban && rec.Recommendation != event.PolicyRecommendationUnban) {
-		ce.Reply(
+		return entityType, "", fmt.Sprintf(
 			"%s has a conflicting %s recommendation for %s (sent by [%s](%s) at %s)",
 			format.SafeMarkdownCode(policy.EntityOrHash()),
 			format.SafeMarkdownCode(rec.Recommendation),
@@ -359,32 +734,28 @@ func (pe *PolicyEvaluator) deduplicatePolicy(
 			rec.Sender.URI().MatrixToURL(),
 			time.UnixMilli(rec.Timestamp).String(),
 		)
-		return entityType, "", false
 	} else {
-		return entityType, "", true
+		return entityType, "", ""
 	}
 }
 
-var cmdBan = &CommandHandler{
-	Name:    "ban",
-	Aliases: []string{"takedown"},
-	Func: func(ce *CommandEvent) {
-		if len(ce.Args) < 2 {
-			ce.Reply("Usage: `%s [--hash] <list shortcode> <entity> [reason]`", ce.Command)
-			return
-		}
-		hash := ce.Args[0] == "--hash"
-		if hash {
-			ce.Args = ce.Args[1:]
-		}
-		list := ce.Meta.FindListByShortcode(ce.Args[0])
+var cmdBan = &Command{
+	Name:        "ban",
+	Aliases:     []string{"takedown"},
+	Description: "Add a ban policy, or a takedown policy when called as `!takedown`",
+	Help:        "Bans with globs that match more than 10 users in protected rooms require confirmation.",
+	Args:        []ArgSpec{{Name: "list shortcode"}, {Name: "entity"}, {Name: "reason", Optional: true, Variadic: true}},
+	Flags:       []FlagSpec{{Name: "hash", Description: "Only publish the hash of the entity in the policy"}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		hash := args.HasFlag("hash")
+		list := ce.Meta.FindListByShortcode(args.Get("list shortcode"))
 		if list == nil {
-			ce.Reply("List %s not found", format.SafeMarkdownCode(ce.Args[0]))
+			ce.Reply("List %s not found", format.SafeMarkdownCode(args.Get("list shortcode")))
 			return
 		}
 		policy := &event.ModPolicyContent{
-			Entity:         ce.Args[1],
-			Reason:         strings.Join(ce.Args[2:], " "),
+			Entity:         args.Get("entity"),
+			Reason:         args.Text("reason"),
 			Recommendation: event.PolicyRecommendationBan,
 		}
 		if hash {
@@ -400,6 +771,11 @@ var cmdBan = &CommandHandler{
 		if !ok {
 			return
 		}
+		if affected := ce.Meta.countGlobTargets(entityType, policy.Entity); affected > wideTargetThreshold && !ce.Meta.confirm(
+			ce, "The %s glob %s matches %d users in protected rooms.", entityType, format.SafeMarkdownCode(policy.Entity), affected,
+		) {
+			return
+		}
 		target := policy.Entity
 		if hash {
 			policy.Entity = ""
@@ -418,20 +794,19 @@ var cmdBan = &CommandHandler{
 	},
 }
 
-var cmdRemovePolicy = &CommandHandler{
-	Name:    "remove-policy",
-	Aliases: []string{"remove-ban", "remove-unban"},
-	Func: func(ce *CommandEvent) {
-		if len(ce.Args) < 2 {
-			ce.Reply("Usage: `!remove-policy <list> <entity>`")
-			return
-		}
-		list := ce.Meta.FindListByShortcode(ce.Args[0])
+var cmdRemovePolicy = &Command{
+	Name:        "remove-policy",
+	Aliases:     []string{"remove-ban", "remove-unban"},
+	Description: "Remove a policy",
+	Help:        "`!remove-ban` and `!remove-unban` only remove the policy if it has the corresponding recommendation.",
+	Args:        []ArgSpec{{Name: "list shortcode"}, {Name: "entity"}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		list := ce.Meta.FindListByShortcode(args.Get("list shortcode"))
 		if list == nil {
-			ce.Reply("List %s not found", format.SafeMarkdownCode(ce.Args[0]))
+			ce.Reply("List %s not found", format.SafeMarkdownCode(args.Get("list shortcode")))
 			return
 		}
-		target := ce.Args[1]
+		target := args.Get("entity")
 		entityType, ok := validateEntity(target)
 		if !ok {
 			ce.Reply("Invalid entity %s", format.SafeMarkdownCode(target))
@@ -474,21 +849,19 @@ var cmdRemovePolicy = &CommandHandler{
 	},
 }
 
-var cmdAddUnban = &CommandHandler{
-	Name: "add-unban",
-	Func: func(ce *CommandEvent) {
-		if len(ce.Args) < 2 {
-			ce.Reply("Usage: `!add-unban <list shortcode> <entity> <reason>`")
-			return
-		}
-		list := ce.Meta.FindListByShortcode(ce.Args[0])
+var cmdAddUnban = &Command{
+	Name:        "add-unban",
+	Description: "Add a ban exclusion policy",
+	Args:        []ArgSpec{{Name: "list shortcode"}, {Name: "entity"}, {Name: "reason", Optional: true, Variadic: true}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		list := ce.Meta.FindListByShortcode(args.Get("list shortcode"))
 		if list == nil {
-			ce.Reply("List %s not found", format.SafeMarkdownCode(ce.Args[0]))
+			ce.Reply("List %s not found", format.SafeMarkdownCode(args.Get("list shortcode")))
 			return
 		}
 		policy := &event.ModPolicyContent{
-			Entity:         ce.Args[1],
-			Reason:         strings.Join(ce.Args[2:], " "),
+			Entity:         args.Get("entity"),
+			Reason:         args.Text("reason"),
 			Recommendation: event.PolicyRecommendationUnban,
 		}
 		entityType, existingStateKey, ok := ce.Meta.deduplicatePolicy(ce, list, policy)
@@ -509,10 +882,13 @@ var cmdAddUnban = &CommandHandler{
 	},
 }
 
-var cmdMatch = &CommandHandler{
-	Name: "match",
-	Func: func(ce *CommandEvent) {
-		target := ce.Args[0]
+var cmdMatch = &Command{
+	Name:        "match",
+	Description: "Match an entity against all lists",
+	Help:        "The entity can be a user ID, room ID, server name or a base64-encoded SHA-256 hash of a user ID.",
+	Args:        []ArgSpec{{Name: "entity"}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		target := args.Get("entity")
 		targetUser := id.UserID(target)
 		userIDHash, ok := util.DecodeBase64Hash(target)
 		if ok {
@@ -559,14 +935,15 @@ var cmdMatch = &CommandHandler{
 			return
 		}
 		if match != nil {
-			eventStrings := make([]string, len(match))
-			for i, policy := range match {
+			sorted, winner, reasons := ce.Meta.explainMatch(match)
+			eventStrings := make([]string, len(sorted))
+			for i, policy := range sorted {
 				policyRoomName := policy.RoomID.String()
 				if meta := ce.Meta.GetWatchedListMeta(policy.RoomID); meta != nil {
 					policyRoomName = meta.Name
 				}
 				eventStrings[i] = fmt.Sprintf(
-					"* [%s] [%s](%s) set recommendation %s for %s at %s for %s",
+					"* [%s] [%s](%s) set recommendation %s for %s at %s for %s (%s)",
 					format.EscapeMarkdown(policyRoomName),
 					policy.Sender,
 					policy.Sender.URI().MatrixToURL(),
@@ -574,24 +951,32 @@ var cmdMatch = &CommandHandler{
 					format.SafeMarkdownCode(policy.EntityOrHash()),
 					format.EscapeMarkdown(time.UnixMilli(policy.Timestamp).String()),
 					format.SafeMarkdownCode(policy.Reason),
+					reasons[policy],
 				)
 			}
+			var recommendation event.PolicyRecommendation
+			if winner != nil {
+				recommendation = winner.Recommendation
+			}
 			ce.Reply(
-				"Matched in %s with recommendation %s\n\n%s",
+				"Matched in %s with recommendation %s\n\n%s%s",
 				dur.String(),
-				format.SafeMarkdownCode(match.Recommendations().String()),
+				format.SafeMarkdownCode(recommendation),
 				strings.Join(eventStrings, "\n"),
+				ce.Meta.notesSection(ce.Ctx, target),
 			)
 		} else {
-			ce.Reply("No match in %s", dur)
+			ce.Reply("No match in %s%s", dur, ce.Meta.notesSection(ce.Ctx, target))
 		}
 	},
 }
 
-var cmdSearch = &CommandHandler{
-	Name: "search",
-	Func: func(ce *CommandEvent) {
-		target := ce.Args[0]
+var cmdSearch = &Command{
+	Name:        "search",
+	Description: "Search for rules by a pattern in all lists",
+	Args:        []ArgSpec{{Name: "pattern"}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		target := args.Get("pattern")
 		start := time.Now()
 		match := ce.Meta.Store.Search(nil, target)
 		dur := time.Since(start)
@@ -642,20 +1027,18 @@ var cmdSearch = &CommandHandler{
 	},
 }
 
-var cmdSendAsBot = &CommandHandler{
-	Name: "send-as-bot",
-	Func: func(ce *CommandEvent) {
-		if len(ce.Args) < 2 {
-			ce.Reply("Usage: `!send-as-bot <room ID> <message>`")
-			return
-		}
-		target := resolveRoom(ce, ce.Args[0])
+var cmdSendAsBot = &Command{
+	Name:        "send-as-bot",
+	Description: "Send a message as the bot",
+	Args:        []ArgSpec{{Name: "room"}, {Name: "message", Variadic: true}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		target := resolveRoom(ce, args.Get("room"))
 		if target == "" {
 			return
 		}
 		resp, err := ce.Meta.Bot.SendMessageEvent(ce.Ctx, target, event.EventMessage, &event.MessageEventContent{
 			MsgType: event.MsgText,
-			Body:    strings.Join(ce.Args[1:], " "),
+			Body:    args.Text("message"),
 		})
 		if err != nil {
 			ce.Reply("Failed to send message to [%s](%s): %v", target, target.URI().MatrixToURL(), err)
@@ -665,20 +1048,20 @@ var cmdSendAsBot = &CommandHandler{
 	},
 }
 
-var cmdRooms = &CommandHandler{
-	Name:    "rooms",
-	Aliases: []string{"room"},
-	Subcommands: []*CommandHandler{
+var cmdRooms = &Command{
+	Name:        "rooms",
+	Aliases:     []string{"room"},
+	Description: "Manage protected rooms",
+	Subcommands: []*Command{
 		cmdListProtectedRooms,
 		cmdProtectRoom,
-		commands.MakeUnknownCommandHandler[*PolicyEvaluator]("!"),
 	},
-	Func: cmdListProtectedRooms.Func,
 }
 
-var cmdListProtectedRooms = &CommandHandler{
-	Name: "list",
-	Func: func(ce *CommandEvent) {
+var cmdListProtectedRooms = &Command{
+	Name:        "list",
+	Description: "List protected rooms",
+	Func: func(ce *CommandEvent, args *CommandArgs) {
 		var buf strings.Builder
 		buf.WriteString("Protected rooms:\n\n")
 		ce.Meta.protectedRoomsLock.RLock()
@@ -690,11 +1073,13 @@ var cmdListProtectedRooms = &CommandHandler{
 	},
 }
 
-var cmdSuspend = &CommandHandler{
-	Name:    "suspend",
-	Aliases: []string{"unsuspend"},
-	Func: func(ce *CommandEvent) {
-		err := ce.Meta.Bot.SynapseAdmin.SuspendAccount(ce.Ctx, id.UserID(ce.Args[0]), synapseadmin.ReqSuspendUser{
+var cmdSuspend = &Command{
+	Name:        "suspend",
+	Aliases:     []string{"unsuspend"},
+	Description: "Suspend or unsuspend a user",
+	Args:        []ArgSpec{{Name: "user ID"}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		err := ce.Meta.Bot.SynapseAdmin.SuspendAccount(ce.Ctx, id.UserID(args.Get("user ID")), synapseadmin.ReqSuspendUser{
 			Suspend: ce.Command != "unsuspend",
 		})
 		if err != nil {
@@ -705,15 +1090,20 @@ var cmdSuspend = &CommandHandler{
 	},
 }
 
-var cmdDeactivate = &CommandHandler{
-	Name: "deactivate",
-	Func: func(ce *CommandEvent) {
-		if len(ce.Args) > 1 && ce.Args[1] != "--erase" {
-			ce.Reply("Usage: `!deactivate <user ID> [--erase]`")
+var cmdDeactivate = &Command{
+	Name:        "deactivate",
+	Description: "Deactivate a user's account",
+	Args:        []ArgSpec{{Name: "user ID"}},
+	Flags:       []FlagSpec{{Name: "erase", Description: "Also erase the user's data (requires confirmation)"}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		erase := args.HasFlag("erase")
+		if erase && !ce.Meta.confirm(
+			ce, "This will deactivate %s and erase their data. This can't be undone.", format.SafeMarkdownCode(args.Get("user ID")),
+		) {
 			return
 		}
-		err := ce.Meta.Bot.SynapseAdmin.DeactivateAccount(ce.Ctx, id.UserID(ce.Args[0]), synapseadmin.ReqDeleteUser{
-			Erase: len(ce.Args) > 1 && ce.Args[1] == "--erase",
+		err := ce.Meta.Bot.SynapseAdmin.DeactivateAccount(ce.Ctx, id.UserID(args.Get("user ID")), synapseadmin.ReqDeleteUser{
+			Erase: erase,
 		})
 		if err != nil {
 			ce.Reply("Failed to deactivate: %v", err)
@@ -723,20 +1113,18 @@ var cmdDeactivate = &CommandHandler{
 	},
 }
 
-var cmdProtectRoom = &CommandHandler{
-	Name:    "protect",
-	Aliases: []string{"unprotect"},
-	Func: func(ce *CommandEvent) {
-		if len(ce.Args) < 1 {
-			ce.Reply("Usage: `!rooms <protect/unprotect> <room ID or alias>...`")
-			return
-		}
+var cmdProtectRoom = &Command{
+	Name:        "protect",
+	Aliases:     []string{"unprotect"},
+	Description: "Protect or unprotect rooms",
+	Args:        []ArgSpec{{Name: "rooms", Variadic: true}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
 		ce.Meta.protectedRoomsLock.RLock()
 		contentCopy := *ce.Meta.protectedRoomsEvent
 		contentCopy.Rooms = slices.Clone(contentCopy.Rooms)
 		ce.Meta.protectedRoomsLock.RUnlock()
 		changed := false
-		for _, room := range ce.Args {
+		for _, room := range args.List("rooms") {
 			roomID := resolveRoom(ce, room)
 			if roomID == "" {
 				continue
@@ -769,38 +1157,484 @@ var cmdProtectRoom = &CommandHandler{
 	},
 }
 
-var cmdHelp = &CommandHandler{
-	Name: "help",
-	Func: func(ce *CommandEvent) {
-		if len(ce.Args) == 0 {
-			ce.Reply("Available commands:\n" +
-				"* `!join <rooms...>` - Join a room\n" +
-				"* `!knock <rooms...>` - Ask to join a room\n" +
-				"* `!leave <rooms...>` - Leave a room\n" +
-				"* `!powerlevel <room|all> <key> <level>` - Set a power level\n" +
-				"* `!redact <event link or user ID> [reason]` - Redact all messages from a user\n" +
-				"* `!redact-recent <room> <since duration> [reason]` - Redact all recent messages in a room\n" +
-				"* `!kick <user ID> [reason]` - Kick a user from all rooms\n" +
-				"* `!ban [--hash] <list shortcode> <entity> [reason]` - Add a ban policy\n" +
-				"* `!takedown [--hash] <list shortcode> <entity>` - Add a takedown policy\n" +
-				"* `!remove-ban <list shortcode> <entity>` - Remove a ban policy\n" +
-				"* `!add-unban <list shortcode> <entity> [reason]` - Add a ban exclusion policy\n" +
-				"* `!match <entity>` - Match an entity against all lists\n" +
-				"* `!search <pattern>` - Search for rules by a pattern in all lists\n" +
-				"* `!send-as-bot <room> <message>` - Send a message as the bot\n" +
-				"* `![un]suspend <user ID>` - Suspend or unsuspend a user\n" +
-				"* `!rooms <protect/unprotect> <room ID or alias>...` - Protect or unprotect a room\n" +
-				// "* `!help <command>` - Show detailed help for a command\n" +
-				"* `!help` - Show this help message\n" +
-				"\n" +
-				"All fields that want a room will accept both room IDs and aliases.\n",
+var cmdImport = &Command{
+	Name:        "import",
+	Description: "Import policies from a file (send as a reply to the file)",
+	Args:        []ArgSpec{{Name: "list shortcode"}, {Name: "default reason", Optional: true, Variadic: true}},
+	Flags: []FlagSpec{{
+		Name:        "format",
+		Description: "The format of the file, detected automatically by default",
+		Choices:     []string{string(PolicyImportFormatLines), string(PolicyImportFormatCSV), string(PolicyImportFormatJSON)},
+	}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		importFormat := PolicyImportFormat(strings.ToLower(args.Flag("format")))
+		replyTo := ce.Event.Content.AsMessage().RelatesTo.GetReplyTo()
+		if replyTo == "" {
+			args.ReplyUsage(ce, "The command must be sent as a reply to a file")
+			return
+		}
+		list := ce.Meta.FindListByShortcode(args.Get("list shortcode"))
+		if list == nil {
+			ce.Reply("List %s not found", format.SafeMarkdownCode(args.Get("list shortcode")))
+			return
+		}
+		fileEvt, err := ce.Meta.Bot.GetMessage(ce.Ctx, ce.RoomID, replyTo)
+		if err != nil {
+			ce.Reply("Failed to get replied-to event: %v", err)
+			return
+		}
+		fileContent := fileEvt.Content.AsMessage()
+		if fileContent.MsgType != event.MsgFile {
+			ce.Reply("The replied-to message is not a file")
+			return
+		}
+		data, err := ce.Meta.Bot.DownloadMedia(ce.Ctx, fileContent)
+		if err != nil {
+			ce.Reply("Failed to download file: %v", err)
+			return
+		}
+		if importFormat == PolicyImportFormatAuto {
+			importFormat = DetectPolicyImportFormat(fileContent.GetFileName(), data)
+		}
+		policies, err := ParsePolicyImport(data, importFormat, args.Text("default reason"))
+		if err != nil {
+			ce.Reply("Failed to parse file: %v", err)
+			return
+		} else if len(policies) == 0 {
+			ce.Reply("No policies found in file")
+			return
+		}
+		progress := ce.Meta.Bot.StartProgress(ce.Ctx, ce.RoomID, fmt.Sprintf(
+			"Importing %d policies from %s file to [%s](%s)",
+			len(policies), importFormat, format.EscapeMarkdown(list.Name), list.RoomID.URI().MatrixToURL(),
+		), len(policies))
+		res := ce.Meta.ImportPolicies(ce.Ctx, list, policies, progress)
+		progress.Finish(ce.Ctx, res.Summary())
+		if len(res.Errors) > 0 {
+			progress.Detail(ce.Ctx, res.String())
+		}
+		ce.React(SuccessReaction)
+	},
+}
+
+var cmdExport = &Command{
+	Name:        "export",
+	Description: "Export policies from a list as a file",
+	Args: []ArgSpec{{Name: "list shortcode"}, {
+		Name:     "format",
+		Optional: true,
+		Choices:  []string{string(PolicyExportFormatJSON), string(PolicyExportFormatCSV), string(PolicyExportFormatACL)},
+	}},
+	Flags: []FlagSpec{
+		{Name: "type", Value: "entity type", Description: "Only export policies for the given entity type (`user`, `room` or `server`)"},
+		{Name: "recommendation", Value: "recommendation", Description: "Only export policies with the given recommendation"},
+	},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		list := ce.Meta.FindListByShortcode(args.Get("list shortcode"))
+		if list == nil {
+			ce.Reply("List %s not found", format.SafeMarkdownCode(args.Get("list shortcode")))
+			return
+		}
+		exportFormat := PolicyExportFormatJSON
+		if args.Get("format") != "" {
+			exportFormat = PolicyExportFormat(strings.ToLower(args.Get("format")))
+		}
+		filter, err := ParsePolicyExportFilter(args.Flag("type"), args.Flag("recommendation"))
+		if err != nil {
+			ce.Reply("Invalid filter: %v", err)
+			return
+		}
+		room := ce.Meta.Store.GetRoom(list.RoomID)
+		if room == nil {
+			ce.Reply("List %s hasn't been loaded", format.SafeMarkdownCode(list.Shortcode))
+			return
+		}
+		var buf bytes.Buffer
+		err = ExportPolicies(&buf, room.Policies(), exportFormat, filter)
+		if err != nil {
+			ce.Reply("Failed to export policies: %v", err)
+			return
+		}
+		fileName := fmt.Sprintf("%s-%s.%s", list.Shortcode, time.Now().Format("2006-01-02"), exportFormat.FileExtension())
+		err = ce.Meta.Bot.SendFile(ce.Ctx, ce.RoomID, buf.Bytes(), fileName, exportFormat.MimeType())
+		if err != nil {
+			ce.Reply("Failed to send export: %v", err)
+			return
+		}
+	},
+}
+
+var cmdLint = &Command{
+	Name:        "lint",
+	Description: "Check watched lists for conflicting, duplicate and ignored policies",
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		ce.Reply(FormatLintReport(ce.Meta.Lint()))
+	},
+}
+
+var cmdQueue = &Command{
+	Name:        "queue",
+	Description: "List pending and retrying actions",
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		actions, err := ce.Meta.DB.ActionQueue.GetAll(ce.Ctx, ce.Meta.ManagementRoom)
+		if err != nil {
+			ce.Reply("Failed to get queued actions: %v", err)
+			return
+		} else if len(actions) == 0 {
+			ce.Reply("No pending actions")
+			return
+		}
+		var buf strings.Builder
+		_, _ = fmt.Fprintf(&buf, "%d pending actions:\n\n", len(actions))
+		for i, qa := range actions {
+			if i >= 50 {
+				_, _ = fmt.Fprintf(&buf, "* ...and %d more\n", len(actions)-i)
+				break
+			}
+			_, _ = fmt.Fprintf(&buf, "* %s", ce.Meta.describeAction(qa))
+			if qa.Attempts > 0 {
+				_, _ = fmt.Fprintf(
+					&buf, " - %d failed attempts, next attempt in %s, last error: %s",
+					qa.Attempts, time.Until(qa.NextAttemptAt).Round(time.Second), format.SafeMarkdownCode(qa.LastError),
+				)
+			} else {
+				buf.WriteString(" - in progress")
+			}
+			buf.WriteByte('\n')
+		}
+		ce.Reply(buf.String())
+	},
+}
+
+var cmdLists = &Command{
+	Name:        "lists",
+	Aliases:     []string{"watched-lists"},
+	Description: "Manage watched policy lists",
+	Subcommands: []*Command{
+		cmdListWatchedLists,
+		cmdWatchList,
+		cmdUnwatchList,
+		cmdSetListFlag,
+		cmdReleaseList,
+		cmdCreateList,
+		cmdListWriters,
+		cmdAddListWriter,
+	},
+}
+
+var cmdListWatchedLists = &Command{
+	Name:        "list",
+	Description: "List watched policy lists",
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		ce.Meta.watchedListsLock.RLock()
+		var watchedLists []config.WatchedPolicyList
+		if ce.Meta.watchedListsEvent != nil {
+			watchedLists = slices.Clone(ce.Meta.watchedListsEvent.Lists)
+		}
+		ce.Meta.watchedListsLock.RUnlock()
+		if len(watchedLists) == 0 {
+			ce.Reply("No watched lists")
+			return
+		}
+		var buf strings.Builder
+		buf.WriteString("Watched lists (in priority order):\n\n")
+		slices.SortStableFunc(watchedLists, func(a, b config.WatchedPolicyList) int {
+			return cmp.Compare(b.Priority, a.Priority)
+		})
+		for _, meta := range watchedLists {
+			flags := []string{fmt.Sprintf("priority %d", meta.Priority)}
+			if meta.MergeMode != "" && meta.MergeMode != policylist.MergeModeAuthoritative {
+				flags = append(flags, string(meta.MergeMode))
+			}
+			if meta.DontApply {
+				flags = append(flags, "not applied")
+			} else if meta.DontApplyACL {
+				flags = append(flags, "ACLs not applied")
+			}
+			if meta.AutoUnban {
+				flags = append(flags, "auto unban")
+			}
+			if meta.AutoSuspend {
+				flags = append(flags, "auto suspend")
+			}
+			if meta.DontNotifyOnChange {
+				flags = append(flags, "change notices disabled")
+			}
+			if heldSince := ce.Meta.IsListHeld(meta.RoomID); heldSince != nil {
+				flags = append(flags, fmt.Sprintf("**held** since %s", heldSince.Format(time.RFC3339)))
+			}
+			_, _ = fmt.Fprintf(
+				&buf, "* [%s](%s) (%s) - %s\n",
+				format.EscapeMarkdown(meta.Name), meta.RoomID.URI().MatrixToURL(), format.SafeMarkdownCode(meta.Shortcode),
+				strings.Join(flags, ", "),
 			)
-		} else {
-			switch strings.ToLower(strings.TrimLeft(ce.Args[0], "!")) {
-			case "join":
-				// TODO
+		}
+		ce.Reply(buf.String())
+	},
+}
+
+var cmdWatchList = &Command{
+	Name:        "watch",
+	Aliases:     []string{"subscribe"},
+	Description: "Start watching a policy list",
+	Help:        "The bot will join the list room if it isn't in it already. If the name is omitted, the room name is used.",
+	Args:        []ArgSpec{{Name: "room"}, {Name: "shortcode"}, {Name: "name", Optional: true, Variadic: true}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		roomID := resolveRoom(ce, args.Get("room"))
+		if roomID == "" {
+			return
+		}
+		shortcode := args.Get("shortcode")
+		if existing := ce.Meta.GetWatchedListMeta(roomID); existing != nil {
+			ce.Reply("Already watching [%s](%s) as %s", format.EscapeMarkdown(existing.Name), roomID.URI().MatrixToURL(), format.SafeMarkdownCode(existing.Shortcode))
+			return
+		} else if existing = ce.Meta.FindListByShortcode(shortcode); existing != nil {
+			ce.Reply("Shortcode %s is already used by [%s](%s)", format.SafeMarkdownCode(shortcode), format.EscapeMarkdown(existing.Name), existing.RoomID.URI().MatrixToURL())
+			return
+		}
+		err := ce.Meta.joinPolicyList(ce.Ctx, roomID, args.Get("room"))
+		if err != nil {
+			ce.Reply("Failed to join %s: %v", format.SafeMarkdownCode(args.Get("room")), err)
+			return
+		}
+		name := args.Text("name")
+		if name == "" {
+			var nameContent event.RoomNameEventContent
+			err = ce.Meta.Bot.StateEvent(ce.Ctx, roomID, event.StateRoomName, "", &nameContent)
+			if err != nil {
+				zerolog.Ctx(ce.Ctx).Debug().Err(err).Stringer("room_id", roomID).Msg("Failed to get policy list room name")
 			}
+			name = cmp.Or(nameContent.Name, shortcode)
 		}
+		content := ce.Meta.cloneWatchedListsEvent()
+		content.Lists = append(content.Lists, config.WatchedPolicyList{
+			RoomID:    roomID,
+			Name:      name,
+			Shortcode: shortcode,
+		})
+		_, err = ce.Meta.Bot.SendStateEvent(ce.Ctx, ce.Meta.ManagementRoom, config.StateWatchedLists, "", content)
+		if err != nil {
+			ce.Reply("Failed to update watched lists: %v", err)
+			return
+		}
+		ce.React(SuccessReaction)
+	},
+}
+
+var cmdUnwatchList = &Command{
+	Name:        "unwatch",
+	Aliases:     []string{"unsubscribe"},
+	Description: "Stop watching a policy list",
+	Args:        []ArgSpec{{Name: "list shortcode"}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		list := ce.Meta.FindListByShortcode(args.Get("list shortcode"))
+		if list == nil {
+			ce.Reply("List %s not found", format.SafeMarkdownCode(args.Get("list shortcode")))
+			return
+		}
+		content := ce.Meta.cloneWatchedListsEvent()
+		content.Lists = slices.DeleteFunc(content.Lists, func(item config.WatchedPolicyList) bool {
+			return item.RoomID == list.RoomID
+		})
+		_, err := ce.Meta.Bot.SendStateEvent(ce.Ctx, ce.Meta.ManagementRoom, config.StateWatchedLists, "", content)
+		if err != nil {
+			ce.Reply("Failed to update watched lists: %v", err)
+			return
+		}
+		ce.React(SuccessReaction)
+	},
+}
+
+var cmdSetListFlag = &Command{
+	Name:        "set",
+	Aliases:     []string{"toggle"},
+	Description: "Change an option of a watched list",
+	Help:        "If the value is omitted, the option is toggled.",
+	Args: []ArgSpec{
+		{Name: "list shortcode"},
+		{Name: "option", Choices: WatchedListFlags},
+		{Name: "value", Optional: true, Choices: []string{"true", "false"}},
+	},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		list := ce.Meta.FindListByShortcode(args.Get("list shortcode"))
+		if list == nil {
+			ce.Reply("List %s not found", format.SafeMarkdownCode(args.Get("list shortcode")))
+			return
+		}
+		option := strings.ToLower(args.Get("option"))
+		content := ce.Meta.cloneWatchedListsEvent()
+		idx := slices.IndexFunc(content.Lists, func(item config.WatchedPolicyList) bool {
+			return item.RoomID == list.RoomID
+		})
+		if idx < 0 {
+			ce.Reply("List %s not found in watched lists event", format.SafeMarkdownCode(list.Shortcode))
+			return
+		}
+		flag := getWatchedListFlag(&content.Lists[idx], option)
+		newValue := !*flag
+		if args.Get("value") != "" {
+			newValue = strings.EqualFold(args.Get("value"), "true")
+		}
+		if *flag == newValue {
+			ce.Reply("%s is already %t for %s", format.SafeMarkdownCode(option), newValue, format.SafeMarkdownCode(list.Shortcode))
+			return
+		}
+		*flag = newValue
+		_, err := ce.Meta.Bot.SendStateEvent(ce.Ctx, ce.Meta.ManagementRoom, config.StateWatchedLists, "", content)
+		if err != nil {
+			ce.Reply("Failed to update watched lists: %v", err)
+			return
+		}
+		ce.Reply("Set %s to %t for %s", format.SafeMarkdownCode(option), newValue, format.SafeMarkdownCode(list.Shortcode))
+	},
+}
+
+var cmdReleaseList = &Command{
+	Name:        "release",
+	Description: "Apply held changes and resume a list that exceeded its change rate limit",
+	Args:        []ArgSpec{{Name: "list shortcode"}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		list := ce.Meta.FindListByShortcode(args.Get("list shortcode"))
+		if list == nil {
+			ce.Reply("List %s not found", format.SafeMarkdownCode(args.Get("list shortcode")))
+			return
+		}
+		if !ce.Meta.ReleaseList(context.WithoutCancel(ce.Ctx), list.RoomID) {
+			ce.Reply("List %s isn't held", format.SafeMarkdownCode(list.Shortcode))
+			return
+		}
+		ce.React(SuccessReaction)
+	},
+}
+
+var cmdHelp = &Command{
+	Name:        "help",
+	Description: "Show the list of commands, or detailed help for a command",
+	Args:        []ArgSpec{{Name: "command", Optional: true, Variadic: true}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		path := args.List("command")
+		if len(path) == 0 {
+			var buf strings.Builder
+			buf.WriteString("Available commands:\n\n")
+			canUse := func(path ...string) bool {
+				return slices.Contains(alwaysAllowedCommands, path[0]) || ce.Meta.CanUseCommand(ce.Event.Sender, path...)
+			}
+			for _, cmd := range ce.Meta.allCommands {
+				if (cmd.Func != nil || len(cmd.Subcommands) == 0) && canUse(cmd.Name) {
+					buf.WriteString(cmd.helpLine())
+					buf.WriteByte('\n')
+				}
+				for _, sub := range cmd.Subcommands {
+					if canUse(cmd.Name, sub.Name) {
+						buf.WriteString(sub.helpLine())
+						buf.WriteByte('\n')
+					}
+				}
+			}
+			buf.WriteString("\nAll fields that want a room will accept both room IDs and aliases. " +
+				"Use `!help <command>` for details about a specific command.\n")
+			ce.Reply(buf.String())
+			return
+		}
+		cmd := findCommand(ce.Meta.allCommands, path[0])
+		for _, subName := range path[1:] {
+			if cmd == nil {
+				break
+			}
+			cmd = findCommand(cmd.Subcommands, subName)
+		}
+		if cmd == nil {
+			ce.Reply("Unknown command %s", format.SafeMarkdownCode("!"+strings.Join(path, " ")))
+			return
+		}
+		ce.Reply(cmd.DetailedHelp())
+	},
+}
+
+var cmdCreateList = &Command{
+	Name:        "create",
+	Description: "Create a new policy list and start watching it",
+	Help: "If the last word of the name is a room alias (`#alias:server`), the alias is created for the room. " +
+		"The alias must be on the bot's server.\n\n" +
+		"The room is created with power levels that only allow moderators to send policies, and you are made a moderator.",
+	Args:  []ArgSpec{{Name: "shortcode"}, {Name: "name", Variadic: true}},
+	Flags: []FlagSpec{{Name: "public", Description: "Publish the list in the room directory and allow anyone to join"}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		shortcode := args.Get("shortcode")
+		if existing := ce.Meta.FindListByShortcode(shortcode); existing != nil {
+			ce.Reply("Shortcode %s is already used by [%s](%s)", format.SafeMarkdownCode(shortcode), format.EscapeMarkdown(existing.Name), existing.RoomID.URI().MatrixToURL())
+			return
+		}
+		nameParts := args.List("name")
+		params := &CreatePolicyListParams{
+			Shortcode: shortcode,
+			Public:    args.HasFlag("public"),
+			Creator:   ce.Event.Sender,
+		}
+		if last := nameParts[len(nameParts)-1]; len(nameParts) > 1 && strings.HasPrefix(last, "#") {
+			localpart, server, _ := strings.Cut(strings.TrimPrefix(last, "#"), ":")
+			if server != ce.Meta.Bot.ServerName {
+				args.ReplyUsage(ce, "The alias must be on %s", format.SafeMarkdownCode(ce.Meta.Bot.ServerName))
+				return
+			}
+			params.Alias = localpart
+			nameParts = nameParts[:len(nameParts)-1]
+		}
+		params.Name = strings.Join(nameParts, " ")
+		roomID, err := ce.Meta.CreatePolicyList(ce.Ctx, params)
+		if err != nil {
+			ce.Reply("Failed to create policy list: %v", err)
+			return
+		}
+		ce.Reply("Created policy list [%s](%s) with shortcode %s", format.EscapeMarkdown(params.Name), roomID.URI().MatrixToURL(), format.SafeMarkdownCode(shortcode))
+	},
+}
+
+var cmdListWriters = &Command{
+	Name:        "writers",
+	Description: "List users who can send policies to a list",
+	Args:        []ArgSpec{{Name: "list shortcode"}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		list := ce.Meta.FindListByShortcode(args.Get("list shortcode"))
+		if list == nil {
+			ce.Reply("List %s not found", format.SafeMarkdownCode(args.Get("list shortcode")))
+			return
+		}
+		writers, err := ce.Meta.GetPolicyListWriters(ce.Ctx, list.RoomID)
+		if err != nil {
+			ce.Reply("Failed to get writers: %v", err)
+			return
+		}
+		writerStrings := make([]string, len(writers))
+		for i, userID := range writers {
+			writerStrings[i] = fmt.Sprintf("* [%s](%s)", userID, userID.URI().MatrixToURL())
+		}
+		ce.Reply("Users who can write to %s:\n\n%s", format.SafeMarkdownCode(list.Shortcode), strings.Join(writerStrings, "\n"))
+	},
+}
+
+var cmdAddListWriter = &Command{
+	Name:        "add-writer",
+	Aliases:     []string{"remove-writer"},
+	Description: "Allow or disallow a user to send policies to a list",
+	Args:        []ArgSpec{{Name: "list shortcode"}, {Name: "user ID"}},
+	Func: func(ce *CommandEvent, args *CommandArgs) {
+		list := ce.Meta.FindListByShortcode(args.Get("list shortcode"))
+		if list == nil {
+			ce.Reply("List %s not found", format.SafeMarkdownCode(args.Get("list shortcode")))
+			return
+		}
+		userID := id.UserID(args.Get("user ID"))
+		if _, _, err := userID.Parse(); err != nil {
+			args.ReplyUsage(ce, "Invalid user ID %s", format.SafeMarkdownCode(userID))
+			return
+		}
+		err := ce.Meta.SetPolicyListWriter(ce.Ctx, list.RoomID, userID, ce.Command == "add-writer")
+		if err != nil {
+			ce.Reply("Failed to update writers of %s: %v", format.SafeMarkdownCode(list.Shortcode), err)
+			return
+		}
+		ce.React(SuccessReaction)
 	},
 }
 
@@ -0,0 +1,317 @@
+package policyeval
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"maunium.net/go/mautrix/commands"
+	"maunium.net/go/mautrix/format"
+)
+
+// Command is a management room command with a declarative argument and flag spec.
+//
+// The spec is used to validate and parse the arguments before Func is called,
+// as well as to generate usage errors and the output of `!help <command>`.
+type Command struct {
+	Name        string
+	Aliases     []string
+	Description string
+	// Help is an optional longer explanation shown in `!help <command>`.
+	Help        string
+	Args        []ArgSpec
+	Flags       []FlagSpec
+	Subcommands []*Command
+	// Func is called with the parsed arguments. Commands with subcommands can leave this empty,
+	// in which case the first subcommand is run if no subcommand is specified.
+	Func func(ce *CommandEvent, args *CommandArgs)
+
+	parent      *Command
+	handler     *CommandHandler
+	handlerOnce sync.Once
+}
+
+// ArgSpec describes a positional argument of a command.
+type ArgSpec struct {
+	Name     string
+	Optional bool
+	// Variadic arguments consume all remaining arguments, including ones that look like flags.
+	// Only the last argument of a command can be variadic.
+	Variadic bool
+	// Choices limits the allowed values of the argument (case-insensitive).
+	Choices []string
+}
+
+// FlagSpec describes a `--flag` of a command. Flags without a Value or Choices are boolean flags.
+type FlagSpec struct {
+	Name        string
+	Description string
+	// Value is the placeholder for the value of the flag in usage strings.
+	Value string
+	// Choices limits the allowed values of the flag (case-insensitive).
+	Choices []string
+}
+
+func (flag *FlagSpec) takesValue() bool {
+	return flag.Value != "" || len(flag.Choices) > 0
+}
+
+func (flag *FlagSpec) usage() string {
+	if len(flag.Choices) > 0 {
+		return fmt.Sprintf("--%s=<%s>", flag.Name, strings.Join(flag.Choices, "|"))
+	} else if flag.Value != "" {
+		return fmt.Sprintf("--%s=<%s>", flag.Name, flag.Value)
+	}
+	return "--" + flag.Name
+}
+
+func (arg *ArgSpec) usage() string {
+	name := arg.Name
+	if len(arg.Choices) > 0 {
+		name = strings.Join(arg.Choices, "|")
+	}
+	if arg.Variadic {
+		name += "..."
+	}
+	if arg.Optional {
+		return "[" + name + "]"
+	}
+	return "<" + name + ">"
+}
+
+func containsFold(choices []string, value string) bool {
+	return slices.ContainsFunc(choices, func(choice string) bool {
+		return strings.EqualFold(choice, value)
+	})
+}
+
+// CommandArgs contains the arguments and flags of a command, parsed according to its spec.
+type CommandArgs struct {
+	usage  string
+	values map[string][]string
+	flags  map[string]string
+}
+
+// Get returns the value of the given positional argument, or an empty string if it wasn't specified.
+// For variadic arguments, only the first value is returned.
+func (ca *CommandArgs) Get(name string) string {
+	if values := ca.values[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// List returns all values of the given variadic argument.
+func (ca *CommandArgs) List(name string) []string {
+	return ca.values[name]
+}
+
+// Text returns all values of the given variadic argument joined with spaces.
+func (ca *CommandArgs) Text(name string) string {
+	return strings.Join(ca.values[name], " ")
+}
+
+// Flag returns the value of the given flag, or an empty string if it wasn't specified.
+func (ca *CommandArgs) Flag(name string) string {
+	return ca.flags[name]
+}
+
+// HasFlag returns true if the given flag was specified.
+func (ca *CommandArgs) HasFlag(name string) bool {
+	_, ok := ca.flags[name]
+	return ok
+}
+
+// ReplyUsage replies to the command with the given problem and the usage of the command.
+func (ca *CommandArgs) ReplyUsage(ce *CommandEvent, problem string, args ...any) {
+	if len(args) > 0 {
+		problem = fmt.Sprintf(problem, args...)
+	}
+	ce.Reply("%s\n\nUsage: %s", problem, format.SafeMarkdownCode(ca.usage))
+}
+
+func (cmd *Command) getFlag(name string) *FlagSpec {
+	for i := range cmd.Flags {
+		if cmd.Flags[i].Name == name {
+			return &cmd.Flags[i]
+		}
+	}
+	return nil
+}
+
+func (cmd *Command) path() []string {
+	if cmd.parent != nil {
+		return append(cmd.parent.path(), cmd.Name)
+	}
+	return []string{cmd.Name}
+}
+
+// Usage returns the usage string of the command, e.g. `!ban [--hash] <list shortcode> <entity> [reason...]`.
+func (cmd *Command) Usage() string {
+	return cmd.usage(cmd.Name)
+}
+
+func (cmd *Command) usage(name string) string {
+	path := cmd.path()
+	path[len(path)-1] = name
+	parts := []string{"!" + strings.Join(path, " ")}
+	for _, flag := range cmd.Flags {
+		parts = append(parts, "["+flag.usage()+"]")
+	}
+	for _, arg := range cmd.Args {
+		parts = append(parts, arg.usage())
+	}
+	return strings.Join(parts, " ")
+}
+
+func (cmd *Command) parseArgs(rawArgs []string) (*CommandArgs, error) {
+	args := &CommandArgs{
+		values: make(map[string][]string),
+		flags:  make(map[string]string),
+	}
+	argIdx := 0
+	for i := 0; i < len(rawArgs); i++ {
+		raw := rawArgs[i]
+		if argIdx < len(cmd.Args) && cmd.Args[argIdx].Variadic && len(args.values[cmd.Args[argIdx].Name]) > 0 {
+			args.values[cmd.Args[argIdx].Name] = append(args.values[cmd.Args[argIdx].Name], raw)
+			continue
+		}
+		if flagName, isFlag := strings.CutPrefix(raw, "--"); isFlag && flagName != "" {
+			flagName, value, hasValue := strings.Cut(flagName, "=")
+			flag := cmd.getFlag(flagName)
+			if flag == nil {
+				return nil, fmt.Errorf("unknown flag %s", format.SafeMarkdownCode("--"+flagName))
+			} else if !flag.takesValue() && hasValue {
+				return nil, fmt.Errorf("flag %s doesn't take a value", format.SafeMarkdownCode("--"+flagName))
+			} else if flag.takesValue() && !hasValue {
+				if i+1 >= len(rawArgs) {
+					return nil, fmt.Errorf("flag %s requires a value", format.SafeMarkdownCode("--"+flagName))
+				}
+				i++
+				value = rawArgs[i]
+			}
+			if len(flag.Choices) > 0 && !containsFold(flag.Choices, value) {
+				return nil, fmt.Errorf(
+					"invalid value %s for %s, must be one of %s",
+					format.SafeMarkdownCode(value), format.SafeMarkdownCode("--"+flagName), strings.Join(flag.Choices, ", "),
+				)
+			}
+			args.flags[flagName] = value
+			continue
+		}
+		if argIdx >= len(cmd.Args) {
+			return nil, fmt.Errorf("too many arguments")
+		}
+		spec := &cmd.Args[argIdx]
+		if len(spec.Choices) > 0 && !containsFold(spec.Choices, raw) {
+			return nil, fmt.Errorf(
+				"invalid value %s for %s, must be one of %s",
+				format.SafeMarkdownCode(raw), format.SafeMarkdownCode(spec.Name), strings.Join(spec.Choices, ", "),
+			)
+		}
+		args.values[spec.Name] = append(args.values[spec.Name], raw)
+		if !spec.Variadic {
+			argIdx++
+		}
+	}
+	for _, spec := range cmd.Args[min(argIdx, len(cmd.Args)):] {
+		if !spec.Optional && len(args.values[spec.Name]) == 0 {
+			return nil, fmt.Errorf("missing %s", format.SafeMarkdownCode(spec.usage()))
+		}
+	}
+	return args, nil
+}
+
+func (cmd *Command) run(ce *CommandEvent) {
+	if cmd.Func == nil {
+		cmd.Subcommands[0].run(ce)
+		return
+	}
+	// Use the alias in usage strings if the command was called with one
+	name := ce.Command
+	if name != cmd.Name && !slices.Contains(cmd.Aliases, name) {
+		name = cmd.Name
+	}
+	args, err := cmd.parseArgs(ce.Args)
+	if err != nil {
+		ce.Reply("Invalid arguments: %v\n\nUsage: %s", err, format.SafeMarkdownCode(cmd.usage(name)))
+		return
+	}
+	args.usage = cmd.usage(name)
+	cmd.Func(ce, args)
+}
+
+// Handler returns the command handler for registering the command in a [commands.Processor].
+func (cmd *Command) Handler() *CommandHandler {
+	cmd.handlerOnce.Do(func() {
+		handler := &CommandHandler{
+			Name:    cmd.Name,
+			Aliases: cmd.Aliases,
+			Func:    cmd.run,
+		}
+		for _, sub := range cmd.Subcommands {
+			sub.parent = cmd
+			handler.Subcommands = append(handler.Subcommands, sub.Handler())
+		}
+		if len(handler.Subcommands) > 0 {
+			handler.Subcommands = append(handler.Subcommands, commands.MakeUnknownCommandHandler[*PolicyEvaluator]("!"))
+		}
+		cmd.handler = handler
+	})
+	return cmd.handler
+}
+
+func findCommand(cmds []*Command, name string) *Command {
+	name = strings.ToLower(strings.TrimPrefix(name, "!"))
+	for _, cmd := range cmds {
+		if cmd.Name == name || slices.Contains(cmd.Aliases, name) {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func (cmd *Command) helpLine() string {
+	line := fmt.Sprintf("* %s - %s", format.SafeMarkdownCode(cmd.Usage()), cmd.Description)
+	if len(cmd.Aliases) > 0 {
+		aliases := make([]string, len(cmd.Aliases))
+		for i, alias := range cmd.Aliases {
+			aliases[i] = format.SafeMarkdownCode(alias)
+		}
+		line += fmt.Sprintf(" (also %s)", strings.Join(aliases, ", "))
+	}
+	return line
+}
+
+// DetailedHelp returns the output of `!help <command>` for the command.
+func (cmd *Command) DetailedHelp() string {
+	var buf strings.Builder
+	_, _ = fmt.Fprintf(&buf, "%s\n\n%s\n", format.SafeMarkdownCode(cmd.Usage()), cmd.Description)
+	if cmd.Help != "" {
+		_, _ = fmt.Fprintf(&buf, "\n%s\n", cmd.Help)
+	}
+	if len(cmd.Aliases) > 0 {
+		aliases := make([]string, len(cmd.Aliases))
+		for i, alias := range cmd.Aliases {
+			path := cmd.path()
+			path[len(path)-1] = alias
+			aliases[i] = format.SafeMarkdownCode("!" + strings.Join(path, " "))
+		}
+		_, _ = fmt.Fprintf(&buf, "\nAliases: %s\n", strings.Join(aliases, ", "))
+	}
+	if len(cmd.Flags) > 0 {
+		buf.WriteString("\nFlags:\n\n")
+		for _, flag := range cmd.Flags {
+			_, _ = fmt.Fprintf(&buf, "* %s - %s\n", format.SafeMarkdownCode(flag.usage()), flag.Description)
+		}
+	}
+	if len(cmd.Subcommands) > 0 {
+		buf.WriteString("\nSubcommands:\n\n")
+		for _, sub := range cmd.Subcommands {
+			buf.WriteString(sub.helpLine())
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,111 @@
+package policyeval
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCommand_ParseArgs(t *testing.T) {
+	cmd := &Command{
+		Name: "test",
+		Args: []ArgSpec{
+			{Name: "target"},
+			{Name: "scope", Optional: true, Choices: []string{"room", "all"}},
+			{Name: "reason", Optional: true, Variadic: true},
+		},
+		Flags: []FlagSpec{
+			{Name: "dry-run"},
+			{Name: "duration", Value: "duration"},
+			{Name: "format", Choices: []string{"json", "csv"}},
+		},
+	}
+	// The last argument of the main command is variadic, so there's no way to pass too many arguments to it
+	twoArgs := &Command{Name: "two", Args: []ArgSpec{{Name: "a"}, {Name: "b", Optional: true}}}
+	tests := []struct {
+		name   string
+		cmd    *Command
+		input  string
+		values map[string][]string
+		flags  map[string]string
+		err    string
+	}{
+		{name: "required only", input: "@user:example.com", values: map[string][]string{"target": {"@user:example.com"}}},
+		{name: "missing required", input: "", err: "missing `<target>`"},
+		{
+			name:   "all positionals",
+			input:  "@user:example.com room spamming a lot",
+			values: map[string][]string{"target": {"@user:example.com"}, "scope": {"room"}, "reason": {"spamming", "a", "lot"}},
+		},
+		{
+			name:   "choices are case-insensitive",
+			input:  "@user:example.com ALL",
+			values: map[string][]string{"target": {"@user:example.com"}, "scope": {"ALL"}},
+		},
+		{name: "invalid choice", input: "@user:example.com everywhere", err: "invalid value `everywhere` for `scope`"},
+		{name: "too many arguments", cmd: twoArgs, input: "a b c", err: "too many arguments"},
+		{name: "optional omitted", cmd: twoArgs, input: "a", values: map[string][]string{"a": {"a"}}},
+		{
+			name:   "boolean flag",
+			input:  "--dry-run @user:example.com",
+			values: map[string][]string{"target": {"@user:example.com"}},
+			flags:  map[string]string{"dry-run": ""},
+		},
+		{
+			name:   "flag value with equals",
+			input:  "@user:example.com --duration=1h",
+			values: map[string][]string{"target": {"@user:example.com"}},
+			flags:  map[string]string{"duration": "1h"},
+		},
+		{
+			name:   "flag value as next argument",
+			input:  "@user:example.com --duration 1h room",
+			values: map[string][]string{"target": {"@user:example.com"}, "scope": {"room"}},
+			flags:  map[string]string{"duration": "1h"},
+		},
+		{
+			name:   "flag choice",
+			input:  "--format=CSV @user:example.com",
+			values: map[string][]string{"target": {"@user:example.com"}},
+			flags:  map[string]string{"format": "CSV"},
+		},
+		{name: "invalid flag choice", input: "--format=xml @user:example.com", err: "invalid value `xml` for `--format`"},
+		{name: "unknown flag", input: "--force @user:example.com", err: "unknown flag `--force`"},
+		{name: "boolean flag with value", input: "--dry-run=yes @user:example.com", err: "flag `--dry-run` doesn't take a value"},
+		{name: "missing flag value", input: "@user:example.com --duration", err: "flag `--duration` requires a value"},
+		{
+			name:   "variadic consumes flags",
+			input:  "@user:example.com all see --dry-run",
+			values: map[string][]string{"target": {"@user:example.com"}, "scope": {"all"}, "reason": {"see", "--dry-run"}},
+		},
+		{
+			name:   "double dash alone is an argument",
+			input:  "--",
+			values: map[string][]string{"target": {"--"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testCmd := test.cmd
+			if testCmd == nil {
+				testCmd = cmd
+			}
+			args, err := testCmd.parseArgs(strings.Fields(test.input))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("parseArgs(%q) error = %v; expected %q", test.input, err, test.err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("parseArgs(%q) returned unexpected error: %v", test.input, err)
+			}
+			if !maps.EqualFunc(args.values, test.values, slices.Equal) {
+				t.Errorf("parseArgs(%q) values = %v; expected %v", test.input, args.values, test.values)
+			}
+			if !maps.Equal(args.flags, test.flags) {
+				t.Errorf("parseArgs(%q) flags = %v; expected %v", test.input, args.flags, test.flags)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package policyeval
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/variationselector"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/bot"
+	"go.mau.fi/meowlnir/database"
+)
+
+const (
+	ConfirmReaction = "✅"
+	CancelReaction  = "❌"
+
+	confirmationTimeout      = 10 * time.Minute
+	confirmationPollInterval = 1 * time.Minute
+
+	// wideTargetThreshold is the number of affected users above which bulk commands require confirmation.
+	wideTargetThreshold = 10
+)
+
+type confirmedCommandContextKey struct{}
+
+// confirm asks the sender of the command to confirm the action described by the summary.
+//
+// When the command is first run, this sends a prompt, stores the command in the database and returns false,
+// in which case the command must return without doing anything. If the sender reacts to the prompt with
+// [ConfirmReaction] before it expires, the command is run again, and this will return true.
+func (pe *PolicyEvaluator) confirm(ce *CommandEvent, summary string, args ...any) bool {
+	if confirmedID, _ := ce.Ctx.Value(confirmedCommandContextKey{}).(id.EventID); confirmedID != "" && confirmedID == ce.Event.ID {
+		return true
+	}
+	if len(args) > 0 {
+		summary = fmt.Sprintf(summary, args...)
+	}
+	promptID := ce.Reply(
+		"%s\n\nReact with %s within %d minutes to confirm, or %s to cancel.",
+		summary, ConfirmReaction, int(confirmationTimeout.Minutes()), CancelReaction,
+	)
+	if promptID == "" {
+		return false
+	}
+	pc := &database.PendingConfirmation{
+		EventID:        promptID,
+		ManagementRoom: pe.ManagementRoom,
+		CommandEventID: ce.Event.ID,
+		Sender:         ce.Event.Sender,
+		Command:        ce.RawInput,
+		Summary:        summary,
+		CreatedAt:      time.Now(),
+		ExpiresAt:      time.Now().Add(confirmationTimeout),
+	}
+	err := pe.DB.Confirmation.Put(ce.Ctx, pc)
+	if err != nil {
+		zerolog.Ctx(ce.Ctx).Err(err).Msg("Failed to save pending confirmation")
+		pe.editConfirmation(ce.Ctx, pc, fmt.Sprintf("Failed to save pending confirmation: %v", err))
+		return false
+	}
+	for _, key := range []string{ConfirmReaction, CancelReaction} {
+		_, err = pe.Bot.SendReaction(ce.Ctx, ce.RoomID, promptID, key)
+		if err != nil {
+			zerolog.Ctx(ce.Ctx).Err(err).Str("key", key).Msg("Failed to send confirmation reaction")
+		}
+	}
+	return false
+}
+
+func (pe *PolicyEvaluator) editConfirmation(ctx context.Context, pc *database.PendingConfirmation, status string) {
+	pe.Bot.SendNoticeOpts(ctx, pc.ManagementRoom, fmt.Sprintf("%s\n\n%s", pc.Summary, status), &bot.SendNoticeOpts{
+		Edit: pc.EventID,
+	})
+}
+
+// HandleReaction handles reactions to confirmation prompts in the management room.
+// Reactions from anyone other than the user who sent the original command are ignored.
+func (pe *PolicyEvaluator) HandleReaction(ctx context.Context, evt *event.Event) {
+	content, ok := evt.Content.Parsed.(*event.ReactionEventContent)
+	if !ok || !pe.checkEventTrust(ctx, evt) {
+		return
+	}
+	key := variationselector.Remove(content.RelatesTo.Key)
+	if key != variationselector.Remove(ConfirmReaction) && key != variationselector.Remove(CancelReaction) {
+		return
+	}
+	log := zerolog.Ctx(ctx).With().
+		Stringer("confirmation_event_id", content.RelatesTo.EventID).
+		Stringer("sender", evt.Sender).
+		Logger()
+	pc, err := pe.DB.Confirmation.Get(ctx, pe.ManagementRoom, content.RelatesTo.EventID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get pending confirmation")
+		return
+	} else if pc == nil || pc.Sender != evt.Sender {
+		return
+	} else if time.Now().After(pc.ExpiresAt) {
+		pe.expireConfirmation(ctx, pc)
+		return
+	}
+	// Deleting first ensures that the command can't be run twice if there are multiple reactions at once
+	if deleted, err := pe.DB.Confirmation.Delete(ctx, pc); err != nil {
+		log.Err(err).Msg("Failed to delete pending confirmation")
+		return
+	} else if !deleted {
+		return
+	}
+	if key == variationselector.Remove(CancelReaction) {
+		log.Debug().Msg("Command cancelled")
+		pe.editConfirmation(ctx, pc, CancelReaction+" Cancelled")
+		return
+	}
+	log.Info().Str("command", pc.Command).Msg("Command confirmed, running it")
+	pe.editConfirmation(ctx, pc, ConfirmReaction+" Confirmed")
+	cmdEvt := &event.Event{
+		Sender: pc.Sender,
+		Type:   event.EventMessage,
+		ID:     pc.CommandEventID,
+		RoomID: pc.ManagementRoom,
+		Content: event.Content{Parsed: &event.MessageEventContent{
+			MsgType: event.MsgText,
+			Body:    pc.Command,
+		}},
+	}
+	// Permissions are checked again in case the user's roles were changed while the confirmation was pending
+	if !pe.checkCommandPermission(ctx, cmdEvt) {
+		return
+	}
+	pe.commandProcessor.Process(context.WithValue(ctx, confirmedCommandContextKey{}, pc.CommandEventID), cmdEvt)
+}
+
+func (pe *PolicyEvaluator) expireConfirmation(ctx context.Context, pc *database.PendingConfirmation) {
+	if deleted, err := pe.DB.Confirmation.Delete(ctx, pc); err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("confirmation_event_id", pc.EventID).Msg("Failed to delete expired confirmation")
+	} else if deleted {
+		pe.editConfirmation(ctx, pc, "Confirmation expired, the command was not run.")
+	}
+}
+
+func (pe *PolicyEvaluator) confirmationExpiryLoop() {
+	ctx := pe.Bot.Log.With().
+		Str("action", "confirmation expiry").
+		Stringer("management_room", pe.ManagementRoom).
+		Logger().
+		WithContext(context.Background())
+	ticker := time.NewTicker(confirmationPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		expired, err := pe.DB.Confirmation.GetExpired(ctx, pe.ManagementRoom, time.Now())
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Msg("Failed to get expired confirmations")
+			continue
+		}
+		for _, pc := range expired {
+			pe.expireConfirmation(ctx, pc)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package policyeval
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/bot"
+	"go.mau.fi/meowlnir/config"
+	"go.mau.fi/meowlnir/policylist"
+)
+
+const maxDigestDetails = 200
+
+type digestChangeType int
+
+const (
+	digestAdded digestChangeType = iota
+	digestRemoved
+	digestReAdded
+	digestReasonChanged
+)
+
+var digestChangeNames = map[digestChangeType]string{
+	digestAdded:         "added",
+	digestRemoved:       "removed",
+	digestReAdded:       "re-added",
+	digestReasonChanged: "reason changed",
+}
+
+type digestEntry struct {
+	changeType digestChangeType
+	policy     *policylist.Policy
+	oldReason  string
+}
+
+type digestGroupKey struct {
+	recommendation event.PolicyRecommendation
+	entityType     policylist.EntityType
+}
+
+type policyDigest struct {
+	started time.Time
+	entries []digestEntry
+}
+
+// addToDigest queues a policy change notice to be sent as part of the list's next digest message.
+// The digest is sent when the list's digest window passes after the first queued change.
+func (pe *PolicyEvaluator) addToDigest(ctx context.Context, meta *config.WatchedPolicyList, entry digestEntry) {
+	pe.digestsLock.Lock()
+	defer pe.digestsLock.Unlock()
+	digest, ok := pe.digests[meta.RoomID]
+	if !ok {
+		digest = &policyDigest{started: time.Now()}
+		pe.digests[meta.RoomID] = digest
+		ctx = context.WithoutCancel(ctx)
+		time.AfterFunc(time.Duration(meta.NotifyDigestSeconds)*time.Second, func() {
+			pe.flushDigest(ctx, meta.RoomID)
+		})
+	}
+	digest.entries = append(digest.entries, entry)
+}
+
+func (pe *PolicyEvaluator) flushDigest(ctx context.Context, policyRoom id.RoomID) {
+	pe.digestsLock.Lock()
+	digest, ok := pe.digests[policyRoom]
+	delete(pe.digests, policyRoom)
+	pe.digestsLock.Unlock()
+	if !ok || len(digest.entries) == 0 {
+		return
+	}
+	name := policyRoom.String()
+	if meta := pe.GetWatchedListMeta(policyRoom); meta != nil {
+		name = meta.Name
+	}
+	pe.sendCategoryNoticeOpts(ctx, config.NoticeCategoryPolicies, formatDigest(name, digest), &bot.SendNoticeOpts{AllowHTML: true})
+}
+
+func (de *digestEntry) groupKey() digestGroupKey {
+	return digestGroupKey{recommendation: de.policy.Recommendation, entityType: de.policy.EntityType}
+}
+
+func (de *digestEntry) actionString() string {
+	switch de.changeType {
+	case digestRemoved:
+		return removeActionString(de.policy.Recommendation)
+	case digestReAdded:
+		return "re-" + addActionString(de.policy.Recommendation)
+	case digestReasonChanged:
+		return "changed reason for"
+	default:
+		return addActionString(de.policy.Recommendation)
+	}
+}
+
+func (de *digestEntry) htmlDetails() string {
+	policy := de.policy
+	reason := fmt.Sprintf("<code>%s</code>", html.EscapeString(policy.Reason))
+	if de.changeType == digestReasonChanged {
+		reason = fmt.Sprintf("<code>%s</code> (was <code>%s</code>)", html.EscapeString(policy.Reason), html.EscapeString(de.oldReason))
+	}
+	var suffix string
+	if policy.Ignored {
+		suffix = " (ignored)"
+	}
+	return fmt.Sprintf(
+		`<li><a href="%s">%s</a> <a href="%s">%s</a> <code>%s</code> for %s%s</li>`,
+		html.EscapeString(policy.Sender.URI().MatrixToURL()), html.EscapeString(policy.Sender.String()),
+		html.EscapeString(policy.RoomID.EventURI(policy.ID).MatrixToURL()), html.EscapeString(de.actionString()),
+		html.EscapeString(policy.EntityOrHash()), reason, suffix,
+	)
+}
+
+func formatDigest(listName string, digest *policyDigest) string {
+	groups := make(map[digestGroupKey][]digestEntry)
+	for _, entry := range digest.entries {
+		groups[entry.groupKey()] = append(groups[entry.groupKey()], entry)
+	}
+	keys := make([]digestGroupKey, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b digestGroupKey) int {
+		return cmp.Or(cmp.Compare(a.entityType, b.entityType), cmp.Compare(a.recommendation, b.recommendation))
+	})
+	var summary, details strings.Builder
+	_, _ = fmt.Fprintf(
+		&summary, "[%s] %d policy changes in the last %s:\n\n",
+		format.EscapeMarkdown(listName), len(digest.entries), time.Since(digest.started).Round(time.Second),
+	)
+	detailCount := 0
+	for _, key := range keys {
+		entries := groups[key]
+		counts := make(map[digestChangeType]int)
+		for _, entry := range entries {
+			counts[entry.changeType]++
+		}
+		var parts []string
+		for _, changeType := range []digestChangeType{digestAdded, digestRemoved, digestReAdded, digestReasonChanged} {
+			if counts[changeType] == 0 {
+				continue
+			}
+			parts = append(parts, fmt.Sprintf("%d %s", counts[changeType], digestChangeNames[changeType]))
+		}
+		_, _ = fmt.Fprintf(
+			&summary, "* %s %s policies: %s\n",
+			key.entityType, format.SafeMarkdownCode(key.recommendation), strings.Join(parts, ", "),
+		)
+		if detailCount >= maxDigestDetails {
+			continue
+		}
+		_, _ = fmt.Fprintf(&details, "<p>%s <code>%s</code> policies:</p><ul>", key.entityType, html.EscapeString(string(key.recommendation)))
+		for _, entry := range entries {
+			if detailCount >= maxDigestDetails {
+				_, _ = fmt.Fprintf(&details, "<li>...and more changes not shown</li>")
+				break
+			}
+			details.WriteString(entry.htmlDetails())
+			detailCount++
+		}
+		details.WriteString("</ul>")
+	}
+	// The details block must not contain blank lines, otherwise markdown rendering would split it.
+	_, _ = fmt.Fprintf(&summary, "\n<details><summary>Details</summary>%s</details>\n", details.String())
+	return summary.String()
+}
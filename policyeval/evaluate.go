@@ -5,12 +5,16 @@ import (
 	"iter"
 	"maps"
 	"slices"
+	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 	"go.mau.fi/util/glob"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
+	"go.mau.fi/meowlnir/bot"
+	"go.mau.fi/meowlnir/config"
 	"go.mau.fi/meowlnir/database"
 	"go.mau.fi/meowlnir/policylist"
 )
@@ -85,14 +89,47 @@ func (pe *PolicyEvaluator) EvaluateAll(ctx context.Context) {
 	pe.UpdateACL(ctx)
 }
 
+const evaluationProgressThreshold = 1000
+
+// EvaluateAllMembers evaluates the given users against all watched lists using a bounded number of workers.
+//
+// If there are many users, a progress message is sent to the management room and edited periodically.
 func (pe *PolicyEvaluator) EvaluateAllMembers(ctx context.Context, members []id.UserID) {
+	if len(members) == 0 {
+		return
+	}
+	start := time.Now()
+	var progress *bot.Progress
+	if len(members) >= evaluationProgressThreshold {
+		progress = pe.Bot.StartProgress(ctx, pe.ManagementRoom, "Evaluating users", len(members))
+	}
+	queue := make(chan id.UserID)
+	var wg sync.WaitGroup
+	wg.Add(pe.evaluationConcurrency)
+	for range pe.evaluationConcurrency {
+		go func() {
+			defer wg.Done()
+			for member := range queue {
+				pe.EvaluateUser(ctx, member, false)
+				progress.Add(ctx, 1, 0)
+			}
+		}()
+	}
 	for _, member := range members {
-		pe.EvaluateUser(ctx, member, false)
+		queue <- member
 	}
+	close(queue)
+	wg.Wait()
+	dur := time.Since(start)
+	zerolog.Ctx(ctx).Debug().
+		Int("user_count", len(members)).
+		Dur("duration", dur).
+		Msg("Finished evaluating users")
+	progress.Finish(ctx, "evaluated %d users", len(members))
 }
 
 func (pe *PolicyEvaluator) EvaluateUser(ctx context.Context, userID id.UserID, isNewRule bool) {
-	match := pe.Store.MatchUser(pe.GetWatchedLists(), userID)
+	match := pe.matchUser(pe.GetWatchedLists(), userID)
 	if match == nil {
 		return
 	}
@@ -114,7 +151,7 @@ func (pe *PolicyEvaluator) EvaluateRemovedRule(ctx context.Context, policy *poli
 			if err != nil {
 				zerolog.Ctx(ctx).Err(err).Str("policy_entity", policy.EntityOrHash()).
 					Msg("Failed to get actions taken for removed policy")
-				pe.sendNotice(ctx, "Database error in EvaluateRemovedRule (GetAllByRuleEntity): %v", err)
+				pe.sendCategoryNotice(ctx, config.NoticeCategoryAlerts, "Database error in EvaluateRemovedRule (GetAllByRuleEntity): %v", err)
 			} else if len(reevalTargets) > 0 {
 				zerolog.Ctx(ctx).Debug().
 					Int("reeval_targets", len(reevalTargets)).
@@ -158,7 +195,7 @@ func (pe *PolicyEvaluator) ReevaluateAffectedByLists(ctx context.Context, policy
 		if err != nil {
 			zerolog.Ctx(ctx).Err(err).Stringer("policy_list_id", list).
 				Msg("Failed to get actions taken from policy list")
-			pe.sendNotice(ctx, "Database error in ReevaluateAffectedByLists (GetAllByPolicyList): %v", err)
+			pe.sendCategoryNotice(ctx, config.NoticeCategoryAlerts, "Database error in ReevaluateAffectedByLists (GetAllByPolicyList): %v", err)
 			continue
 		}
 		if reevalTargets == nil {
@@ -171,11 +208,23 @@ func (pe *PolicyEvaluator) ReevaluateAffectedByLists(ctx context.Context, policy
 }
 
 func (pe *PolicyEvaluator) ReevaluateActions(ctx context.Context, actions []*database.TakenAction) {
+	var wg sync.WaitGroup
 	for _, action := range actions {
 		if action.ActionType == database.TakenActionTypeBanOrUnban && action.Action == event.PolicyRecommendationBan {
-			pe.ReevaluateBan(ctx, action)
+			wg.Add(1)
+			pe.roomActions.Submit(action.InRoomID, func() {
+				defer wg.Done()
+				pe.ReevaluateBan(ctx, action)
+			})
+		} else if action.ActionType == database.TakenActionTypeMute {
+			wg.Add(1)
+			pe.roomActions.Submit(action.InRoomID, func() {
+				defer wg.Done()
+				pe.ReevaluateMute(ctx, action)
+			})
 		}
 	}
+	wg.Wait()
 }
 
 func (pe *PolicyEvaluator) ReevaluateBan(ctx context.Context, action *database.TakenAction) {
@@ -187,8 +236,8 @@ func (pe *PolicyEvaluator) ReevaluateBan(ctx context.Context, action *database.T
 		log.Debug().Msg("Policy list does not have auto-unban enabled, skipping")
 		return
 	}
-	match := pe.Store.MatchUser(pe.GetWatchedLists(), action.TargetUser)
-	if rec := match.Recommendations().BanOrUnban; rec != nil && rec.Recommendation != event.PolicyRecommendationUnban {
+	match := pe.matchUser(pe.GetWatchedLists(), action.TargetUser)
+	if rec := pe.recommendations(match).BanOrUnban; rec != nil && rec.Recommendation != event.PolicyRecommendationUnban {
 		action.PolicyList = rec.RoomID
 		action.RuleEntity = rec.EntityOrHash()
 		err := pe.DB.TakenAction.Put(ctx, action)
@@ -203,14 +252,5 @@ func (pe *PolicyEvaluator) ReevaluateBan(ctx context.Context, action *database.T
 		return
 	}
 	log.Debug().Msg("Unbanning user")
-	ok := pe.UndoBan(ctx, action.TargetUser, action.InRoomID)
-	if !ok {
-		return
-	}
-	err := pe.DB.TakenAction.Delete(ctx, action.TargetUser, action.InRoomID, action.ActionType)
-	if err != nil {
-		log.Err(err).Msg("Failed to delete taken action after unbanning")
-	} else {
-		log.Trace().Msg("Deleted taken action after unbanning")
-	}
+	pe.UndoBan(ctx, action.TargetUser, action.InRoomID)
 }
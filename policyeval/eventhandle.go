@@ -28,6 +28,14 @@ func (pe *PolicyEvaluator) HandleConfigChange(ctx context.Context, evt *event.Ev
 		successMsgs, errorMsgs := pe.handleProtectedRooms(ctx, evt, false)
 		successMsg = strings.Join(successMsgs, "\n")
 		errorMsg = strings.Join(errorMsgs, "\n")
+	case config.StateRoles:
+		successMsgs, errorMsgs := pe.handleRoles(evt)
+		successMsg = strings.Join(successMsgs, "\n")
+		errorMsg = strings.Join(errorMsgs, "\n")
+	case config.StateNoticeRouting:
+		successMsgs, errorMsgs := pe.handleNoticeRouting(ctx, evt)
+		successMsg = strings.Join(successMsgs, "\n")
+		errorMsg = strings.Join(errorMsgs, "\n")
 	}
 	var output string
 	if successMsg != "" {
@@ -57,7 +65,7 @@ func (pe *PolicyEvaluator) HandleMember(ctx context.Context, evt *event.Event) {
 			return
 		}
 		if isProtecting && (content.Membership == event.MembershipLeave || content.Membership == event.MembershipBan) {
-			pe.sendNotice(ctx, "⚠️ Bot was removed from [%s](%s)", evt.RoomID, evt.RoomID.URI().MatrixToURL())
+			pe.sendCategoryNotice(ctx, config.NoticeCategoryAlerts, "⚠️ Bot was removed from [%s](%s)", evt.RoomID, evt.RoomID.URI().MatrixToURL())
 		} else if wantToProtect && (content.Membership == event.MembershipJoin || content.Membership == event.MembershipInvite) {
 			_, err := pe.Bot.JoinRoomByID(ctx, evt.RoomID)
 			if err != nil {
@@ -70,7 +78,13 @@ func (pe *PolicyEvaluator) HandleMember(ctx context.Context, evt *event.Event) {
 		}
 	} else {
 		checkRules := pe.updateUser(userID, evt.RoomID, content.Membership)
+		if pe.IsProtectedRoom(evt.RoomID) {
+			pe.checkWatchlistMembership(ctx, evt, userID, content.Membership)
+		}
 		if checkRules {
+			if content.Membership == event.MembershipJoin {
+				pe.trackJoin(ctx, evt.RoomID)
+			}
 			pe.EvaluateUser(ctx, userID, false)
 		}
 	}
@@ -82,6 +96,8 @@ func addActionString(rec event.PolicyRecommendation) string {
 		return "banned"
 	case event.PolicyRecommendationUnban:
 		return "added a ban exclusion for"
+	case policylist.PolicyRecommendationWatch:
+		return "started watching"
 	default:
 		return fmt.Sprintf("added a `%s` rule for", rec)
 	}
@@ -93,6 +109,8 @@ func changeActionString(rec event.PolicyRecommendation) string {
 		return "ban"
 	case event.PolicyRecommendationUnban:
 		return "ban exclusion"
+	case policylist.PolicyRecommendationWatch:
+		return "watch"
 	default:
 		return fmt.Sprintf("`%s`", rec)
 	}
@@ -104,17 +122,26 @@ func removeActionString(rec event.PolicyRecommendation) string {
 		return "unbanned"
 	case event.PolicyRecommendationUnban:
 		return "removed a ban exclusion for"
+	case policylist.PolicyRecommendationWatch:
+		return "stopped watching"
 	default:
 		return fmt.Sprintf("removed a `%s` rule for", rec)
 	}
 }
 
-func noopSendNotice(_ context.Context, _ string, _ ...any) {}
+func noopSendNotice(_ context.Context, _ config.NoticeCategory, _ string, _ ...any) {}
 
 func (pe *PolicyEvaluator) HandlePolicyListChange(ctx context.Context, policyRoom id.RoomID, added, removed *policylist.Policy) {
+	pe.checkPendingListUpgrade(ctx, policyRoom, added, removed)
 	policyRoomMeta := pe.GetWatchedListMeta(policyRoom)
 	if policyRoomMeta == nil {
 		return
+	} else if pe.holdPolicyListChange(ctx, policyRoomMeta, added, removed) {
+		zerolog.Ctx(ctx).Debug().
+			Any("added", added).
+			Any("removed", removed).
+			Msg("Holding policy list change")
+		return
 	}
 	zerolog.Ctx(ctx).Info().
 		Bool("dont_apply", policyRoomMeta.DontApply).
@@ -122,47 +149,111 @@ func (pe *PolicyEvaluator) HandlePolicyListChange(ctx context.Context, policyRoo
 		Any("removed", removed).
 		Msg("Policy list change")
 	removedAndAddedAreEquivalent := removed != nil && added != nil && removed.EntityOrHash() == added.EntityOrHash() && removed.Recommendation == added.Recommendation
-	sendNotice := pe.sendNotice
+	sendNotice := pe.sendCategoryNotice
 	if policyRoomMeta.DontNotifyOnChange {
 		sendNotice = noopSendNotice
 	}
+	useDigest := policyRoomMeta.NotifyDigestSeconds > 0 && !policyRoomMeta.DontNotifyOnChange
 	if removedAndAddedAreEquivalent {
-		if removed.Reason == added.Reason {
-			sendNotice(ctx,
+		if useDigest {
+			entry := digestEntry{changeType: digestReAdded, policy: added}
+			if removed.Reason != added.Reason {
+				entry = digestEntry{changeType: digestReasonChanged, policy: added, oldReason: removed.Reason}
+			}
+			pe.addToDigest(ctx, policyRoomMeta, entry)
+		} else if removed.Reason == added.Reason {
+			sendNotice(ctx, config.NoticeCategoryPolicies,
 				"[%s] [%s](%s) re-%s `%s` for `%s`",
 				policyRoomMeta.Name, added.Sender, added.Sender.URI().MatrixToURL(),
 				addActionString(added.Recommendation), added.EntityOrHash(), added.Reason)
 		} else {
-			sendNotice(ctx,
+			sendNotice(ctx, config.NoticeCategoryPolicies,
 				"[%s] [%s](%s) changed the %s reason for `%s` from `%s` to `%s`",
 				policyRoomMeta.Name, added.Sender, added.Sender.URI().MatrixToURL(),
 				changeActionString(added.Recommendation), added.EntityOrHash(), removed.Reason, added.Reason)
 		}
 	} else {
 		if removed != nil {
-			sendNotice(ctx,
-				"[%s] [%s](%s) %s %ss matching `%s` for `%s`",
-				policyRoomMeta.Name, removed.Sender, removed.Sender.URI().MatrixToURL(),
-				removeActionString(removed.Recommendation), removed.EntityType, removed.EntityOrHash(), removed.Reason,
-			)
+			if useDigest {
+				pe.addToDigest(ctx, policyRoomMeta, digestEntry{changeType: digestRemoved, policy: removed})
+			} else {
+				sendNotice(ctx, config.NoticeCategoryPolicies,
+					"[%s] [%s](%s) %s %ss matching `%s` for `%s`",
+					policyRoomMeta.Name, removed.Sender, removed.Sender.URI().MatrixToURL(),
+					removeActionString(removed.Recommendation), removed.EntityType, removed.EntityOrHash(), removed.Reason,
+				)
+			}
 			if !policyRoomMeta.DontApply {
 				pe.EvaluateRemovedRule(ctx, removed)
 			}
 		}
 		if added != nil {
 			var suffix string
-			if added.Ignored {
+			category := config.NoticeCategoryPolicies
+			untrusted := !pe.isTrusted(added)
+			if untrusted {
+				category = config.NoticeCategoryAlerts
+				suffix = " (rule was ignored because the sender isn't trusted)"
+				// Always notify about untrusted policies immediately, as they may indicate a compromised account
+				sendNotice = pe.sendCategoryNotice
+			} else if added.Ignored {
 				suffix = " (rule was ignored)"
 			}
-			sendNotice(ctx,
-				"[%s] [%s](%s) %s %ss matching `%s` for `%s`%s",
-				policyRoomMeta.Name, added.Sender, added.Sender.URI().MatrixToURL(),
-				addActionString(added.Recommendation), added.EntityType, added.EntityOrHash(), added.Reason,
-				suffix,
-			)
+			if useDigest && !untrusted {
+				pe.addToDigest(ctx, policyRoomMeta, digestEntry{changeType: digestAdded, policy: added})
+			} else {
+				sendNotice(ctx, category,
+					"[%s] [%s](%s) %s %ss matching `%s` for `%s`%s",
+					policyRoomMeta.Name, added.Sender, added.Sender.URI().MatrixToURL(),
+					addActionString(added.Recommendation), added.EntityType, added.EntityOrHash(), added.Reason,
+					suffix,
+				)
+			}
 			if !policyRoomMeta.DontApply {
 				pe.EvaluateAddedRule(ctx, added)
 			}
 		}
 	}
 }
+
+func summarizeTrustChanges(changes []policylist.TrustChange) string {
+	var nowIgnored, nowApplied int
+	for _, change := range changes {
+		if change.Trusted {
+			nowApplied++
+		} else {
+			nowIgnored++
+		}
+	}
+	return fmt.Sprintf("%d policies are now ignored and %d policies are no longer ignored", nowIgnored, nowApplied)
+}
+
+func (pe *PolicyEvaluator) applyTrustChanges(ctx context.Context, changes []policylist.TrustChange) {
+	for _, change := range changes {
+		meta := pe.GetWatchedListMeta(change.Policy.RoomID)
+		if meta == nil || meta.DontApply {
+			continue
+		}
+		if change.Trusted {
+			pe.EvaluateAddedRule(ctx, change.Policy)
+		} else {
+			pe.EvaluateRemovedRule(ctx, change.Policy)
+		}
+	}
+}
+
+// HandlePolicyListPowerLevels handles policies in a watched list being ignored or unignored
+// because the power levels in the policy room changed.
+func (pe *PolicyEvaluator) HandlePolicyListPowerLevels(ctx context.Context, room *policylist.Room, prevPL *event.PowerLevelsEventContent) {
+	policyRoomMeta := pe.GetWatchedListMeta(room.RoomID)
+	if policyRoomMeta == nil {
+		return
+	}
+	trust := pe.GetWatchedListTrust()[room.RoomID]
+	changes := room.TrustChanges(trust, trust, prevPL, room.PowerLevels())
+	if len(changes) == 0 {
+		return
+	}
+	pe.sendCategoryNotice(ctx, config.NoticeCategoryPolicies, "[%s] Power levels changed: %s", policyRoomMeta.Name, summarizeTrustChanges(changes))
+	pe.applyTrustChanges(ctx, changes)
+}
@@ -2,7 +2,6 @@ package policyeval
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"slices"
 	"strings"
@@ -14,6 +13,8 @@ import (
 	"maunium.net/go/mautrix/id"
 	"maunium.net/go/mautrix/synapseadmin"
 
+	"go.mau.fi/meowlnir/bot"
+	"go.mau.fi/meowlnir/config"
 	"go.mau.fi/meowlnir/database"
 	"go.mau.fi/meowlnir/policylist"
 )
@@ -29,7 +30,7 @@ func (pe *PolicyEvaluator) ApplyPolicy(ctx context.Context, userID id.UserID, po
 	if userID == pe.Bot.UserID {
 		return
 	}
-	recs := policy.Recommendations()
+	recs := pe.recommendations(policy)
 	rooms := pe.getRoomsUserIsIn(userID)
 	if !isNew && len(rooms) == 0 {
 		// Don't apply policies to left users when re-evaluating rules,
@@ -44,9 +45,9 @@ func (pe *PolicyEvaluator) ApplyPolicy(ctx context.Context, userID id.UserID, po
 				Stringer("user_id", userID).
 				Any("matches", policy).
 				Msg("Applying ban recommendation")
-			for _, room := range rooms {
-				pe.ApplyBan(ctx, userID, room, recs.BanOrUnban)
-			}
+			pe.roomActions.SubmitAndWait(rooms, func(room id.RoomID) {
+				pe.ApplyBan(ctx, userID, room, recs.BanOrUnban, room == rooms[0])
+			})
 			shouldRedact := recs.BanOrUnban.Recommendation == event.PolicyRecommendationUnstableTakedown
 			if !shouldRedact && recs.BanOrUnban.Reason != "" {
 				for _, pattern := range pe.autoRedactPatterns {
@@ -68,11 +69,17 @@ func (pe *PolicyEvaluator) ApplyPolicy(ctx context.Context, userID id.UserID, po
 			//takenActions, err := pe.DB.TakenAction.GetAllByTargetUser(ctx, userID, database.TakenActionTypeBanOrUnban)
 			//if err != nil {
 			//	zerolog.Ctx(ctx).Err(err).Stringer("user_id", userID).Msg("Failed to get taken actions")
-			//	pe.sendNotice(ctx, "Database error in ApplyPolicy (GetAllByTargetUser): %v", err)
+			//	pe.sendCategoryNotice(ctx, config.NoticeCategoryActions, "Database error in ApplyPolicy (GetAllByTargetUser): %v", err)
 			//	return
 			//}
 		}
 	}
+	// Muting is pointless if the user is being banned anyway
+	if recs.Mute != nil && (recs.BanOrUnban == nil || recs.BanOrUnban.Recommendation == event.PolicyRecommendationUnban) && len(rooms) > 0 {
+		pe.roomActions.SubmitAndWait(rooms, func(room id.RoomID) {
+			pe.applyMutePolicy(ctx, userID, room, recs.Mute)
+		})
+	}
 }
 
 func filterReason(reason string) string {
@@ -93,73 +100,36 @@ func (pe *PolicyEvaluator) maybeApplySuspend(ctx context.Context, userID id.User
 	err := pe.Bot.SynapseAdmin.SuspendAccount(ctx, userID, synapseadmin.ReqSuspendUser{Suspend: true})
 	if err != nil {
 		zerolog.Ctx(ctx).Err(err).Stringer("user_id", userID).Msg("Failed to suspend user")
-		pe.sendNotice(ctx, "Failed to suspend [%s](%s): %v", userID, userID.URI().MatrixToURL(), err)
+		pe.sendCategoryNotice(ctx, config.NoticeCategoryActions, "Failed to suspend [%s](%s): %v", userID, userID.URI().MatrixToURL(), err)
 	} else {
 		zerolog.Ctx(ctx).Info().Stringer("user_id", userID).Msg("Suspended user")
-		pe.sendNotice(ctx, "Suspended [%s](%s) due to received ban policy", userID, userID.URI().MatrixToURL())
+		pe.sendCategoryNotice(ctx, config.NoticeCategoryActions, "Suspended [%s](%s) due to received ban policy", userID, userID.URI().MatrixToURL())
 	}
 }
 
-func (pe *PolicyEvaluator) ApplyBan(ctx context.Context, userID id.UserID, roomID id.RoomID, policy *policylist.Policy) {
-	ta := &database.TakenAction{
-		TargetUser: userID,
-		InRoomID:   roomID,
-		ActionType: database.TakenActionTypeBanOrUnban,
-		PolicyList: policy.RoomID,
-		RuleEntity: policy.EntityOrHash(),
-		Action:     policy.Recommendation,
-		TakenAt:    time.Now(),
-	}
-	var err error
-	if !pe.DryRun {
-		_, err = pe.Bot.BanUser(ctx, roomID, &mautrix.ReqBanUser{
-			Reason: filterReason(policy.Reason),
-			UserID: userID,
-		})
-	}
-	if err != nil {
-		var respErr mautrix.HTTPError
-		if errors.As(err, &respErr) {
-			err = respErr
-		}
-		zerolog.Ctx(ctx).Err(err).Any("attempted_action", ta).Msg("Failed to ban user")
-		pe.sendNotice(ctx, "Failed to ban [%s](%s) in [%s](%s) for %s: %v", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL(), policy.Reason, err)
-		return
-	}
-	err = pe.DB.TakenAction.Put(ctx, ta)
-	if err != nil {
-		zerolog.Ctx(ctx).Err(err).Any("taken_action", ta).Msg("Failed to save taken action")
-		pe.sendNotice(ctx, "Banned [%s](%s) in [%s](%s) for %s, but failed to save to database: %v", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL(), policy.Reason, err)
-	} else {
-		zerolog.Ctx(ctx).Info().Any("taken_action", ta).Msg("Took action")
-		pe.sendNotice(ctx, "Banned [%s](%s) in [%s](%s) for %s", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL(), policy.Reason)
-	}
+// ApplyBan bans the given user in the given room based on a policy.
+// Notes about the user are included in the ban notice if showNotes is true.
+func (pe *PolicyEvaluator) ApplyBan(ctx context.Context, userID id.UserID, roomID id.RoomID, policy *policylist.Policy, showNotes bool) {
+	_ = pe.runAction(ctx, &database.QueuedAction{
+		ActionType: database.QueuedActionTypeBan,
+		RoomID:     roomID,
+		Target:     string(userID),
+		Payload: database.QueuedActionPayload{
+			Reason:         policy.Reason,
+			PolicyList:     policy.RoomID,
+			RuleEntity:     policy.EntityOrHash(),
+			Recommendation: policy.Recommendation,
+			ShowNotes:      showNotes,
+		},
+	})
 }
 
-func (pe *PolicyEvaluator) UndoBan(ctx context.Context, userID id.UserID, roomID id.RoomID) bool {
-	if !pe.DryRun && !pe.Bot.StateStore.IsMembership(ctx, roomID, userID, event.MembershipBan) {
-		zerolog.Ctx(ctx).Trace().Msg("User is not banned in room, skipping unban")
-		return true
-	}
-
-	var err error
-	if !pe.DryRun {
-		_, err = pe.Bot.UnbanUser(ctx, roomID, &mautrix.ReqUnbanUser{
-			UserID: userID,
-		})
-	}
-	if err != nil {
-		var respErr mautrix.HTTPError
-		if errors.As(err, &respErr) {
-			err = respErr
-		}
-		zerolog.Ctx(ctx).Err(err).Msg("Failed to unban user")
-		pe.sendNotice(ctx, "Failed to unban [%s](%s) in [%s](%s): %v", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL(), err)
-		return false
-	}
-	zerolog.Ctx(ctx).Debug().Msg("Unbanned user")
-	pe.sendNotice(ctx, "Unbanned [%s](%s) in [%s](%s)", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL())
-	return true
+func (pe *PolicyEvaluator) UndoBan(ctx context.Context, userID id.UserID, roomID id.RoomID) {
+	_ = pe.runAction(ctx, &database.QueuedAction{
+		ActionType: database.QueuedActionTypeUnban,
+		RoomID:     roomID,
+		Target:     string(userID),
+	})
 }
 
 func pluralize(value int, unit string) string {
@@ -208,7 +178,7 @@ func (pe *PolicyEvaluator) redactUserSynapse(ctx context.Context, userID id.User
 			Stringer("user_id", userID).
 			Dur("query_duration", dur).
 			Msg("Failed to get events to redact")
-		pe.sendNotice(ctx,
+		pe.sendCategoryNotice(ctx, config.NoticeCategoryActions,
 			"Failed to get events to redact for [%s](%s): %v",
 			userID, userID.URI().MatrixToURL(), err)
 		return
@@ -231,18 +201,34 @@ func (pe *PolicyEvaluator) redactUserSynapse(ctx context.Context, userID id.User
 		Str("reason", reason).
 		Dur("query_duration", dur).
 		Msg("Got events to redact")
+	var totalEvents int
+	for _, roomEvents := range events {
+		totalEvents += len(roomEvents)
+	}
+	var progress *bot.Progress
+	if totalEvents >= redactionProgressThreshold {
+		noticeRoom, _ := pe.getNoticeRoute(config.NoticeCategoryActions)
+		progress = pe.Bot.StartProgress(ctx, noticeRoom, fmt.Sprintf("Redacting events from %s", userID), totalEvents)
+	}
 	var errorMessages []string
 	var redactedCount int
 	for roomID, roomEvents := range events {
 		successCount, failedCount := pe.redactEventsInRoom(ctx, userID, roomID, roomEvents, reason)
+		progress.Add(ctx, successCount, failedCount)
 		if failedCount > 0 {
-			errorMessages = append(errorMessages, fmt.Sprintf(
-				"* Failed to redact %d/%d events from [%s](%s) in [%s](%s)",
-				failedCount, failedCount+successCount, userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL()))
+			errorMessage := fmt.Sprintf(
+				"Failed to redact %d/%d events from [%s](%s) in [%s](%s)",
+				failedCount, failedCount+successCount, userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL())
+			errorMessages = append(errorMessages, "* "+errorMessage)
+			progress.Detail(ctx, errorMessage)
 		}
 		redactedCount += successCount
 	}
-	pe.sendRedactResult(ctx, redactedCount, len(events), userID, errorMessages)
+	if progress != nil {
+		progress.Finish(ctx, "redacted %s across %s", pluralize(redactedCount, "event"), pluralize(len(events), "room"))
+	} else {
+		pe.sendRedactResult(ctx, redactedCount, len(events), userID, errorMessages)
+	}
 	if needsReredact {
 		time.Sleep(15 * time.Second)
 		zerolog.Ctx(ctx).Debug().
@@ -252,6 +238,9 @@ func (pe *PolicyEvaluator) redactUserSynapse(ctx context.Context, userID id.User
 	}
 }
 
+// redactionProgressThreshold is the number of events above which redactions are reported with a progress message.
+const redactionProgressThreshold = 100
+
 func (pe *PolicyEvaluator) sendRedactResult(ctx context.Context, events, rooms int, userID id.UserID, errorMessages []string) {
 	if events == 0 && len(errorMessages) == 0 {
 		// Skip sending a message if no events were redacted and there were no errors
@@ -263,7 +252,7 @@ func (pe *PolicyEvaluator) sendRedactResult(ctx context.Context, events, rooms i
 	if len(errorMessages) > 0 {
 		output += "\n\n" + strings.Join(errorMessages, "\n")
 	}
-	pe.sendNotice(ctx, output)
+	pe.sendCategoryNotice(ctx, config.NoticeCategoryActions, output)
 }
 
 func (pe *PolicyEvaluator) RedactUser(ctx context.Context, userID id.UserID, reason string, allowReredact bool) {
@@ -285,7 +274,7 @@ func (pe *PolicyEvaluator) RedactUser(ctx context.Context, userID id.UserID, rea
 				continue
 			}
 			if redactedCount > 0 {
-				pe.sendNotice(ctx, "Redacted %d events from [%s](%s) in [%s](%s)", redactedCount, userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL())
+				pe.sendCategoryNotice(ctx, config.NoticeCategoryActions, "Redacted %d events from [%s](%s) in [%s](%s)", redactedCount, userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL())
 			}
 		}
 	}
@@ -293,13 +282,12 @@ func (pe *PolicyEvaluator) RedactUser(ctx context.Context, userID id.UserID, rea
 
 func (pe *PolicyEvaluator) redactEventsInRoom(ctx context.Context, userID id.UserID, roomID id.RoomID, events []id.EventID, reason string) (successCount, failedCount int) {
 	for _, evtID := range events {
-		var resp *mautrix.RespSendEvent
-		var err error
-		if !pe.DryRun {
-			resp, err = pe.Bot.RedactEvent(ctx, roomID, evtID, mautrix.ReqRedact{Reason: reason})
-		} else {
-			resp = &mautrix.RespSendEvent{EventID: "$fake-redaction-id"}
-		}
+		err := pe.runAction(ctx, &database.QueuedAction{
+			ActionType: database.QueuedActionTypeRedact,
+			RoomID:     roomID,
+			Target:     string(evtID),
+			Payload:    database.QueuedActionPayload{Reason: reason},
+		})
 		if err != nil {
 			zerolog.Ctx(ctx).Err(err).
 				Stringer("sender", userID).
@@ -308,12 +296,6 @@ func (pe *PolicyEvaluator) redactEventsInRoom(ctx context.Context, userID id.Use
 				Msg("Failed to redact event")
 			failedCount++
 		} else {
-			zerolog.Ctx(ctx).Debug().
-				Stringer("sender", userID).
-				Stringer("room_id", roomID).
-				Stringer("event_id", evtID).
-				Stringer("redaction_id", resp.EventID).
-				Msg("Successfully redacted event")
 			successCount++
 		}
 	}
@@ -0,0 +1,160 @@
+package policyeval
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/policylist"
+)
+
+type PolicyExportFormat string
+
+const (
+	PolicyExportFormatJSON PolicyExportFormat = "json"
+	PolicyExportFormatCSV  PolicyExportFormat = "csv"
+	PolicyExportFormatACL  PolicyExportFormat = "acl"
+)
+
+// MimeType returns the MIME type of files in the given export format.
+func (pef PolicyExportFormat) MimeType() string {
+	switch pef {
+	case PolicyExportFormatJSON:
+		return "application/json"
+	case PolicyExportFormatCSV:
+		return "text/csv"
+	default:
+		return "text/plain"
+	}
+}
+
+// FileExtension returns the file extension for files in the given export format.
+func (pef PolicyExportFormat) FileExtension() string {
+	switch pef {
+	case PolicyExportFormatJSON:
+		return "json"
+	case PolicyExportFormatCSV:
+		return "csv"
+	default:
+		return "txt"
+	}
+}
+
+// PolicyExportFilter limits which policies are included in an export. Empty fields match everything.
+type PolicyExportFilter struct {
+	EntityType     policylist.EntityType
+	Recommendation event.PolicyRecommendation
+}
+
+// ParsePolicyExportFilter parses an export filter from user input.
+// The recommendation accepts the same shorthands as imports (ban, unban, takedown).
+func ParsePolicyExportFilter(entityType, recommendation string) (filter PolicyExportFilter, err error) {
+	switch policylist.EntityType(entityType) {
+	case "", policylist.EntityTypeUser, policylist.EntityTypeRoom, policylist.EntityTypeServer:
+		filter.EntityType = policylist.EntityType(entityType)
+	default:
+		return filter, fmt.Errorf("unknown entity type %q", entityType)
+	}
+	if recommendation != "" {
+		var ok bool
+		filter.Recommendation, ok = parseImportRecommendation(recommendation)
+		if !ok {
+			return filter, fmt.Errorf("unknown recommendation %q", recommendation)
+		}
+	}
+	return filter, nil
+}
+
+func (pef *PolicyExportFilter) Match(policy *policylist.Policy) bool {
+	return !policy.Ignored &&
+		(pef.EntityType == "" || policy.EntityType == pef.EntityType) &&
+		(pef.Recommendation == "" || policy.Recommendation == pef.Recommendation)
+}
+
+type exportedPolicyEvent struct {
+	Type      event.Type              `json:"type"`
+	StateKey  string                  `json:"state_key"`
+	RoomID    id.RoomID               `json:"room_id"`
+	Sender    id.UserID               `json:"sender"`
+	Timestamp int64                   `json:"origin_server_ts"`
+	ID        id.EventID              `json:"event_id"`
+	Content   *event.ModPolicyContent `json:"content"`
+}
+
+func encodePolicyHash(policy *policylist.Policy) string {
+	if policy.EntityHash == nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(policy.EntityHash[:])
+}
+
+// ExportPolicies writes the given policies in the given format.
+//
+// The JSON format is a list of state events (which can be imported back with ParsePolicyImport),
+// the CSV format contains one row per policy, and the ACL format is a plain list of banned server name globs
+// that can be used in m.room.server_acl events.
+func ExportPolicies(w io.Writer, policies []*policylist.Policy, exportFormat PolicyExportFormat, filter PolicyExportFilter) error {
+	if exportFormat == PolicyExportFormatACL {
+		filter.EntityType = policylist.EntityTypeServer
+	}
+	switch exportFormat {
+	case PolicyExportFormatJSON:
+		events := make([]*exportedPolicyEvent, 0, len(policies))
+		for _, policy := range policies {
+			if !filter.Match(policy) {
+				continue
+			}
+			events = append(events, &exportedPolicyEvent{
+				Type:      policy.Type,
+				StateKey:  policy.StateKey,
+				RoomID:    policy.RoomID,
+				Sender:    policy.Sender,
+				Timestamp: policy.Timestamp,
+				ID:        policy.ID,
+				Content:   policy.ModPolicyContent,
+			})
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(events)
+	case PolicyExportFormatCSV:
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"entity_type", "entity", "hash", "recommendation", "reason", "sender", "timestamp"})
+		for _, policy := range policies {
+			if !filter.Match(policy) {
+				continue
+			}
+			_ = cw.Write([]string{
+				string(policy.EntityType),
+				policy.Entity,
+				encodePolicyHash(policy),
+				string(policy.Recommendation),
+				policy.Reason,
+				policy.Sender.String(),
+				time.UnixMilli(policy.Timestamp).UTC().Format(time.RFC3339),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	case PolicyExportFormatACL:
+		for _, policy := range policies {
+			// Hashed policies can't be used in ACLs, and unbans aren't bans
+			if !filter.Match(policy) || policy.Entity == "" || policy.Recommendation == event.PolicyRecommendationUnban {
+				continue
+			}
+			_, err := io.WriteString(w, policy.Entity+"\n")
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown export format %q", exportFormat)
+	}
+}
@@ -0,0 +1,303 @@
+package policyeval
+
+import (
+	"cmp"
+	"context"
+	"iter"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/config"
+	"go.mau.fi/meowlnir/database"
+	"go.mau.fi/meowlnir/policylist"
+)
+
+const listChangeRateWindow = time.Minute
+
+type listChangeRate struct {
+	windowStart time.Time
+	count       int
+}
+
+// heldList is a watched list whose changes are stored, but not applied until an admin releases the list.
+//
+// While a list is held, it's evaluated as if it still had the policies it had when it was held.
+type heldList struct {
+	since time.Time
+	// Policies that were added while the list was held. They're in the store, but must not be applied.
+	added map[id.EventID]*policylist.Policy
+	// Policies that were removed or replaced while the list was held.
+	// They're no longer in the store, but stay in effect until the list is released.
+	removed map[id.EventID]*policylist.Policy
+}
+
+func newHeldList(since time.Time) *heldList {
+	return &heldList{
+		since:   since,
+		added:   make(map[id.EventID]*policylist.Policy),
+		removed: make(map[id.EventID]*policylist.Policy),
+	}
+}
+
+// holdPolicyListChange checks the change rate of the given list and holds the change
+// if the list is held or the change pushes it over the configured threshold.
+//
+// Returns true if the change was held and must not be applied.
+func (pe *PolicyEvaluator) holdPolicyListChange(ctx context.Context, meta *config.WatchedPolicyList, added, removed *policylist.Policy) bool {
+	pe.heldListsLock.Lock()
+	held, isHeld := pe.heldLists[meta.RoomID]
+	if !isHeld {
+		if meta.MaxChangesPerMinute <= 0 {
+			pe.heldListsLock.Unlock()
+			return false
+		}
+		now := time.Now()
+		rate, ok := pe.listChangeRates[meta.RoomID]
+		if !ok || now.Sub(rate.windowStart) >= listChangeRateWindow {
+			rate = &listChangeRate{windowStart: now}
+			pe.listChangeRates[meta.RoomID] = rate
+		}
+		rate.count++
+		if rate.count <= meta.MaxChangesPerMinute {
+			pe.heldListsLock.Unlock()
+			return false
+		}
+		held = newHeldList(now)
+		pe.heldLists[meta.RoomID] = held
+	}
+	var putPolicies []*database.HeldPolicy
+	var deletePolicies []id.EventID
+	if removed != nil {
+		if _, wasHeld := held.added[removed.ID]; wasHeld {
+			// The policy was never applied, so removing it doesn't change anything
+			delete(held.added, removed.ID)
+			deletePolicies = append(deletePolicies, removed.ID)
+		} else {
+			held.removed[removed.ID] = removed
+			putPolicies = append(putPolicies, pe.heldPolicyRow(removed, true))
+		}
+	}
+	if added != nil {
+		held.added[added.ID] = added
+		putPolicies = append(putPolicies, pe.heldPolicyRow(added, false))
+	}
+	pe.heldListsLock.Unlock()
+	if !isHeld {
+		zerolog.Ctx(ctx).Warn().
+			Stringer("policy_list", meta.RoomID).
+			Int("max_changes_per_minute", meta.MaxChangesPerMinute).
+			Msg("Policy list exceeded change rate limit, holding further changes")
+		pe.sendCategoryNotice(
+			ctx, config.NoticeCategoryAlerts, "⚠️ [%s](%s) sent more than %d policy changes within a minute. "+
+				"Further changes will be stored, but not applied until an admin runs `!lists release %s`.",
+			meta.Name, meta.RoomID.URI().MatrixToURL(), meta.MaxChangesPerMinute, meta.Shortcode,
+		)
+		err := pe.DB.HeldList.Put(ctx, &database.HeldList{
+			ManagementRoom: pe.ManagementRoom,
+			PolicyList:     meta.RoomID,
+			HeldSince:      held.since,
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Stringer("policy_list", meta.RoomID).Msg("Failed to save held list")
+		}
+	}
+	for _, hp := range putPolicies {
+		if err := pe.DB.HeldPolicy.Put(ctx, hp); err != nil {
+			zerolog.Ctx(ctx).Err(err).Stringer("event_id", hp.EventID).Msg("Failed to save held policy")
+		}
+	}
+	for _, eventID := range deletePolicies {
+		if err := pe.DB.HeldPolicy.Delete(ctx, pe.ManagementRoom, eventID); err != nil {
+			zerolog.Ctx(ctx).Err(err).Stringer("event_id", eventID).Msg("Failed to delete held policy")
+		}
+	}
+	return true
+}
+
+func (pe *PolicyEvaluator) heldPolicyRow(policy *policylist.Policy, removed bool) *database.HeldPolicy {
+	return &database.HeldPolicy{
+		ManagementRoom: pe.ManagementRoom,
+		PolicyList:     policy.RoomID,
+		EventID:        policy.ID,
+		Removed:        removed,
+		Policy:         policy.Event(),
+	}
+}
+
+// loadHeldLists restores the held state of lists from the database.
+func (pe *PolicyEvaluator) loadHeldLists(ctx context.Context) error {
+	lists, err := pe.DB.HeldList.GetAll(ctx, pe.ManagementRoom)
+	if err != nil {
+		return err
+	}
+	policies, err := pe.DB.HeldPolicy.GetAll(ctx, pe.ManagementRoom)
+	if err != nil {
+		return err
+	}
+	pe.heldListsLock.Lock()
+	defer pe.heldListsLock.Unlock()
+	for _, hl := range lists {
+		pe.heldLists[hl.PolicyList] = newHeldList(hl.HeldSince)
+	}
+	for _, hp := range policies {
+		held, ok := pe.heldLists[hp.PolicyList]
+		if !ok || hp.Policy == nil {
+			continue
+		}
+		policy := policylist.NewPolicy(hp.Policy)
+		if policy == nil {
+			zerolog.Ctx(ctx).Warn().Stringer("event_id", hp.EventID).Msg("Failed to parse held policy")
+		} else if hp.Removed {
+			held.removed[hp.EventID] = policy
+		} else {
+			held.added[hp.EventID] = policy
+		}
+	}
+	return nil
+}
+
+// IsListHeld returns the time when the given list was held, or nil if the list isn't held.
+func (pe *PolicyEvaluator) IsListHeld(roomID id.RoomID) *time.Time {
+	pe.heldListsLock.RLock()
+	defer pe.heldListsLock.RUnlock()
+	held, ok := pe.heldLists[roomID]
+	if !ok {
+		return nil
+	}
+	return &held.since
+}
+
+func (pe *PolicyEvaluator) isHeldPolicy(policy *policylist.Policy) bool {
+	held, ok := pe.heldLists[policy.RoomID]
+	if !ok {
+		return false
+	}
+	_, isHeld := held.added[policy.ID]
+	return isHeld
+}
+
+// filterHeldPolicies removes policies that were added to held lists from the given match.
+func (pe *PolicyEvaluator) filterHeldPolicies(match policylist.Match) policylist.Match {
+	pe.heldListsLock.RLock()
+	defer pe.heldListsLock.RUnlock()
+	if len(pe.heldLists) == 0 {
+		return match
+	}
+	return slices.DeleteFunc(slices.Clone(match), pe.isHeldPolicy)
+}
+
+// heldRemovals iterates over policies of the given entity type that were removed from the given lists
+// while the lists were held. The caller must hold heldListsLock.
+func (pe *PolicyEvaluator) heldRemovals(lists []id.RoomID, entityType policylist.EntityType) iter.Seq[*policylist.Policy] {
+	return func(yield func(*policylist.Policy) bool) {
+		for _, listID := range lists {
+			held, ok := pe.heldLists[listID]
+			if !ok {
+				continue
+			}
+			for _, policy := range held.removed {
+				if policy.EntityType == entityType && !policy.Ignored && !yield(policy) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// withHeldRemovals adds policies that were removed from held lists, but are still in effect, to the given match.
+// The match must be for the given lists, and the output is sorted in the same list order.
+func (pe *PolicyEvaluator) withHeldRemovals(lists []id.RoomID, match policylist.Match, entityType policylist.EntityType, entity string) policylist.Match {
+	pe.heldListsLock.RLock()
+	defer pe.heldListsLock.RUnlock()
+	if len(pe.heldLists) == 0 {
+		return match
+	}
+	var output policylist.Match
+	for policy := range pe.heldRemovals(lists, entityType) {
+		if policy.Pattern.Match(entity) {
+			if output == nil {
+				output = slices.Clone(match)
+			}
+			output = append(output, policy)
+		}
+	}
+	if output == nil {
+		return match
+	}
+	slices.SortStableFunc(output, func(a, b *policylist.Policy) int {
+		return cmp.Compare(slices.Index(lists, a.RoomID), slices.Index(lists, b.RoomID))
+	})
+	return output
+}
+
+// matchUser finds policies matching the given user in the given lists, taking held lists into account.
+func (pe *PolicyEvaluator) matchUser(lists []id.RoomID, userID id.UserID) policylist.Match {
+	return pe.withHeldRemovals(lists, pe.Store.MatchUser(lists, userID), policylist.EntityTypeUser, string(userID))
+}
+
+// matchRoom finds policies matching the given room in the given lists, taking held lists into account.
+func (pe *PolicyEvaluator) matchRoom(lists []id.RoomID, roomID id.RoomID) policylist.Match {
+	return pe.withHeldRemovals(lists, pe.Store.MatchRoom(lists, roomID), policylist.EntityTypeRoom, string(roomID))
+}
+
+// matchServer finds policies matching the given server in the given lists, taking held lists into account.
+func (pe *PolicyEvaluator) matchServer(lists []id.RoomID, serverName string) policylist.Match {
+	match := pe.Store.MatchServer(lists, serverName)
+	return pe.withHeldRemovals(lists, match, policylist.EntityTypeServer, policylist.CleanupServerNameForMatch(serverName))
+}
+
+// dropHeldLists forgets the held state of the given lists without applying the held changes.
+func (pe *PolicyEvaluator) dropHeldLists(ctx context.Context, roomIDs []id.RoomID) {
+	pe.heldListsLock.Lock()
+	var dropped []id.RoomID
+	for _, roomID := range roomIDs {
+		if _, ok := pe.heldLists[roomID]; ok {
+			dropped = append(dropped, roomID)
+		}
+		delete(pe.heldLists, roomID)
+		delete(pe.listChangeRates, roomID)
+	}
+	pe.heldListsLock.Unlock()
+	for _, roomID := range dropped {
+		if err := pe.DB.HeldList.Delete(ctx, pe.ManagementRoom, roomID); err != nil {
+			zerolog.Ctx(ctx).Err(err).Stringer("policy_list", roomID).Msg("Failed to delete held list")
+		}
+	}
+}
+
+// ReleaseList releases a held list and applies all changes that were held.
+// Returns false if the list wasn't held.
+func (pe *PolicyEvaluator) ReleaseList(ctx context.Context, roomID id.RoomID) bool {
+	pe.heldListsLock.Lock()
+	held, ok := pe.heldLists[roomID]
+	delete(pe.heldLists, roomID)
+	delete(pe.listChangeRates, roomID)
+	pe.heldListsLock.Unlock()
+	if !ok {
+		return false
+	}
+	if err := pe.DB.HeldList.Delete(ctx, pe.ManagementRoom, roomID); err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("policy_list", roomID).Msg("Failed to delete held list")
+	}
+	meta := pe.GetWatchedListMeta(roomID)
+	if meta == nil {
+		return true
+	}
+	pe.sendCategoryNotice(
+		ctx, config.NoticeCategoryPolicies, "Released [%s](%s), applying %d held changes (%d policies added, %d removed)",
+		meta.Name, roomID.URI().MatrixToURL(), len(held.added)+len(held.removed), len(held.added), len(held.removed),
+	)
+	if meta.DontApply {
+		return true
+	}
+	for _, policy := range held.removed {
+		pe.EvaluateRemovedRule(ctx, policy)
+	}
+	for _, policy := range held.added {
+		pe.EvaluateAddedRule(ctx, policy)
+	}
+	return true
+}
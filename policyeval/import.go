@@ -0,0 +1,298 @@
+package policyeval
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+
+	"go.mau.fi/meowlnir/bot"
+	"go.mau.fi/meowlnir/config"
+	"go.mau.fi/meowlnir/policylist"
+)
+
+type PolicyImportFormat string
+
+const (
+	PolicyImportFormatAuto  PolicyImportFormat = ""
+	PolicyImportFormatLines PolicyImportFormat = "lines"
+	PolicyImportFormatCSV   PolicyImportFormat = "csv"
+	PolicyImportFormatJSON  PolicyImportFormat = "json"
+)
+
+const (
+	policyImportInterval   = 100 * time.Millisecond
+	maxPolicyImportRetries = 5
+	maxPolicyImportErrors  = 20
+)
+
+// DetectPolicyImportFormat guesses the format of an import file based on the file name and content.
+func DetectPolicyImportFormat(fileName string, data []byte) PolicyImportFormat {
+	switch strings.ToLower(path.Ext(fileName)) {
+	case ".json":
+		return PolicyImportFormatJSON
+	case ".csv":
+		return PolicyImportFormatCSV
+	case ".txt":
+		return PolicyImportFormatLines
+	}
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		return PolicyImportFormatJSON
+	} else if bytes.ContainsRune(trimmed, ',') {
+		return PolicyImportFormatCSV
+	}
+	return PolicyImportFormatLines
+}
+
+func parseImportRecommendation(rec string) (event.PolicyRecommendation, bool) {
+	switch strings.ToLower(strings.TrimSpace(rec)) {
+	case "", "ban", string(event.PolicyRecommendationBan), string(event.PolicyRecommendationUnstableBan):
+		return event.PolicyRecommendationBan, true
+	case "takedown", string(event.PolicyRecommendationUnstableTakedown):
+		return event.PolicyRecommendationUnstableTakedown, true
+	case "unban", string(event.PolicyRecommendationUnban):
+		return event.PolicyRecommendationUnban, true
+	default:
+		return "", false
+	}
+}
+
+// ParsePolicyImport parses policies from an import file.
+//
+// The supported formats are one entity per line (optionally followed by a reason),
+// CSV with the columns entity, reason and recommendation (only entity is required),
+// and JSON containing policy state events (either a single event or a list, like the output of /state).
+// Entries without a reason will use the given default reason.
+func ParsePolicyImport(data []byte, importFormat PolicyImportFormat, defaultReason string) ([]*event.ModPolicyContent, error) {
+	var policies []*event.ModPolicyContent
+	switch importFormat {
+	case PolicyImportFormatLines:
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			policies = append(policies, &event.ModPolicyContent{
+				Entity:         fields[0],
+				Reason:         strings.Join(fields[1:], " "),
+				Recommendation: event.PolicyRecommendationBan,
+			})
+		}
+	case PolicyImportFormatCSV:
+		reader := csv.NewReader(bytes.NewReader(data))
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		reader.Comment = '#'
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CSV: %w", err)
+		}
+		for i, record := range records {
+			if len(record) == 0 || record[0] == "" || (i == 0 && strings.EqualFold(record[0], "entity")) {
+				continue
+			}
+			policy := &event.ModPolicyContent{
+				Entity:         strings.TrimSpace(record[0]),
+				Recommendation: event.PolicyRecommendationBan,
+			}
+			if len(record) > 1 {
+				policy.Reason = strings.TrimSpace(record[1])
+			}
+			if len(record) > 2 {
+				var ok bool
+				policy.Recommendation, ok = parseImportRecommendation(record[2])
+				if !ok {
+					return nil, fmt.Errorf("unknown recommendation %q on line %d", record[2], i+1)
+				}
+			}
+			policies = append(policies, policy)
+		}
+	case PolicyImportFormatJSON:
+		var events []*event.Event
+		trimmed := bytes.TrimSpace(data)
+		if len(trimmed) > 0 && trimmed[0] == '{' {
+			var evt event.Event
+			if err := json.Unmarshal(trimmed, &evt); err != nil {
+				return nil, fmt.Errorf("failed to parse JSON: %w", err)
+			}
+			events = []*event.Event{&evt}
+		} else if err := json.Unmarshal(trimmed, &events); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON: %w", err)
+		}
+		for _, evt := range events {
+			switch evt.Type.Type {
+			case event.StatePolicyUser.Type, event.StateLegacyPolicyUser.Type, event.StateUnstablePolicyUser.Type,
+				event.StatePolicyRoom.Type, event.StateLegacyPolicyRoom.Type, event.StateUnstablePolicyRoom.Type,
+				event.StatePolicyServer.Type, event.StateLegacyPolicyServer.Type, event.StateUnstablePolicyServer.Type:
+			default:
+				// State dumps contain other events too, just ignore them
+				continue
+			}
+			var content event.ModPolicyContent
+			if err := json.Unmarshal(evt.Content.VeryRaw, &content); err != nil {
+				return nil, fmt.Errorf("failed to parse content of %s/%s: %w", evt.Type.Type, evt.GetStateKey(), err)
+			} else if content.Entity == "" && content.UnstableHashes == nil {
+				// Removed policy
+				continue
+			}
+			rec, ok := parseImportRecommendation(string(content.Recommendation))
+			if !ok {
+				return nil, fmt.Errorf("unknown recommendation %q for %s", content.Recommendation, content.Entity)
+			}
+			policies = append(policies, &event.ModPolicyContent{
+				Entity:         content.Entity,
+				Reason:         content.Reason,
+				Recommendation: rec,
+				// Hashed policies can't be imported, but they're kept so that they can be reported as unsupported
+				UnstableHashes: content.UnstableHashes,
+			})
+		}
+	default:
+		return nil, fmt.Errorf("unknown import format %q", importFormat)
+	}
+	for _, policy := range policies {
+		if policy.Reason == "" {
+			policy.Reason = defaultReason
+		}
+	}
+	return policies, nil
+}
+
+type PolicyImportResult struct {
+	Total       int      `json:"total"`
+	Sent        int      `json:"sent"`
+	Duplicate   int      `json:"duplicate"`
+	Invalid     int      `json:"invalid"`
+	Unsupported int      `json:"unsupported"`
+	Failed      int      `json:"failed"`
+	Errors      []string `json:"errors,omitempty"`
+}
+
+func (res *PolicyImportResult) addError(msg string, args ...any) {
+	if len(res.Errors) < maxPolicyImportErrors {
+		res.Errors = append(res.Errors, fmt.Sprintf(msg, args...))
+	}
+}
+
+// Summary returns a one-line summary of the import result without individual errors.
+func (res *PolicyImportResult) Summary() string {
+	return fmt.Sprintf(
+		"imported %d/%d policies (%d duplicates or conflicts skipped, %d invalid, %d unsupported, %d failed)",
+		res.Sent, res.Total, res.Duplicate, res.Invalid, res.Unsupported, res.Failed,
+	)
+}
+
+func (res *PolicyImportResult) String() string {
+	var buf strings.Builder
+	summary := res.Summary()
+	buf.WriteString(strings.ToUpper(summary[:1]) + summary[1:])
+	if len(res.Errors) > 0 {
+		buf.WriteString("\n\n")
+		for _, msg := range res.Errors {
+			_, _ = fmt.Fprintf(&buf, "* %s\n", msg)
+		}
+		if hidden := res.Duplicate + res.Invalid + res.Unsupported + res.Failed - len(res.Errors); hidden > 0 {
+			_, _ = fmt.Fprintf(&buf, "* ...and %d more\n", hidden)
+		}
+	}
+	return buf.String()
+}
+
+// ImportPolicies sends the given policies to the given list, skipping ones that already exist
+// or conflict with existing policies. Policies are sent one by one with a delay in between
+// to avoid hitting rate limits.
+func (pe *PolicyEvaluator) ImportPolicies(ctx context.Context, list *config.WatchedPolicyList, policies []*event.ModPolicyContent, progress *bot.Progress) *PolicyImportResult {
+	log := zerolog.Ctx(ctx).With().
+		Stringer("policy_list", list.RoomID).
+		Int("policy_count", len(policies)).
+		Logger()
+	log.Info().Msg("Importing policies")
+	res := &PolicyImportResult{Total: len(policies)}
+	seen := make(map[string]struct{}, len(policies))
+	for _, policy := range policies {
+		if policy.Entity == "" && policy.UnstableHashes != nil {
+			res.Unsupported++
+			res.addError("Hashed policies can't be imported")
+			progress.Add(ctx, 0, 1)
+			continue
+		} else if _, ok := validateEntity(policy.Entity); !ok {
+			res.Invalid++
+			res.addError("Invalid entity %s", format.SafeMarkdownCode(policy.Entity))
+			progress.Add(ctx, 0, 1)
+			continue
+		}
+		// The store won't see sent policies until they come back through sync, so duplicates inside the file
+		// need to be tracked separately.
+		if _, alreadySeen := seen[policy.Entity]; alreadySeen {
+			res.Duplicate++
+			res.addError("%s is listed multiple times", format.SafeMarkdownCode(policy.Entity))
+			progress.Add(ctx, 1, 0)
+			continue
+		}
+		seen[policy.Entity] = struct{}{}
+		entityType, existingStateKey, problem := pe.checkPolicyDuplicate(list, policy)
+		if problem != "" {
+			res.Duplicate++
+			res.addError("%s", problem)
+			progress.Add(ctx, 1, 0)
+			continue
+		}
+		err := pe.sendImportedPolicy(ctx, list, entityType, existingStateKey, policy)
+		if err != nil {
+			log.Err(err).Str("entity", policy.Entity).Msg("Failed to send imported policy")
+			res.Failed++
+			res.addError("Failed to send policy for %s: %v", format.SafeMarkdownCode(policy.Entity), err)
+			progress.Add(ctx, 0, 1)
+		} else {
+			res.Sent++
+			progress.Add(ctx, 1, 0)
+		}
+		select {
+		case <-time.After(policyImportInterval):
+		case <-ctx.Done():
+			res.Failed += res.Total - res.Sent - res.Duplicate - res.Invalid - res.Unsupported - res.Failed
+			res.addError("Import was cancelled: %v", ctx.Err())
+			return res
+		}
+	}
+	log.Info().Any("result", res).Msg("Finished importing policies")
+	return res
+}
+
+func (pe *PolicyEvaluator) sendImportedPolicy(
+	ctx context.Context,
+	list *config.WatchedPolicyList,
+	entityType policylist.EntityType,
+	existingStateKey string,
+	policy *event.ModPolicyContent,
+) error {
+	for attempt := 1; ; attempt++ {
+		_, err := pe.SendPolicy(ctx, list.RoomID, entityType, existingStateKey, policy.Entity, policy)
+		if err == nil {
+			return nil
+		}
+		retryDelay, retryable := actionRetryDelay(err, attempt)
+		if !retryable || attempt >= maxPolicyImportRetries {
+			return err
+		}
+		zerolog.Ctx(ctx).Warn().Err(err).
+			Str("entity", policy.Entity).
+			Dur("retry_in", retryDelay).
+			Msg("Failed to send imported policy, retrying")
+		select {
+		case <-time.After(retryDelay):
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		}
+	}
+}
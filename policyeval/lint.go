@@ -0,0 +1,143 @@
+package policyeval
+
+import (
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/policylist"
+)
+
+const maxLintIssuesPerType = 10
+
+type LintedPolicy struct {
+	List           id.RoomID                  `json:"list"`
+	ListShortcode  string                     `json:"list_shortcode,omitempty"`
+	EventType      string                     `json:"event_type"`
+	StateKey       string                     `json:"state_key"`
+	Entity         string                     `json:"entity,omitempty"`
+	Hash           string                     `json:"hash,omitempty"`
+	Recommendation event.PolicyRecommendation `json:"recommendation"`
+	Reason         string                     `json:"reason"`
+}
+
+type LintedIssue struct {
+	Type     policylist.LintIssueType `json:"type"`
+	Policies []*LintedPolicy          `json:"policies"`
+	Winner   *LintedPolicy            `json:"winner,omitempty"`
+}
+
+func (pe *PolicyEvaluator) toLintedPolicy(policy *policylist.Policy) *LintedPolicy {
+	if policy == nil {
+		return nil
+	}
+	lp := &LintedPolicy{
+		List:           policy.RoomID,
+		EventType:      policy.Type.Type,
+		StateKey:       policy.StateKey,
+		Entity:         policy.Entity,
+		Hash:           encodePolicyHash(policy),
+		Recommendation: policy.Recommendation,
+		Reason:         policy.Reason,
+	}
+	if meta := pe.GetWatchedListMeta(policy.RoomID); meta != nil {
+		lp.ListShortcode = meta.Shortcode
+	}
+	return lp
+}
+
+// Lint checks all watched lists for conflicting, redundant and ignored policies.
+func (pe *PolicyEvaluator) Lint() []*LintedIssue {
+	issues := pe.Store.Lint(pe.GetWatchedLists(), pe.GetWatchedListModes(), pe.GetWatchedListTrust())
+	output := make([]*LintedIssue, len(issues))
+	for i, issue := range issues {
+		output[i] = &LintedIssue{
+			Type:     issue.Type,
+			Policies: make([]*LintedPolicy, len(issue.Policies)),
+			Winner:   pe.toLintedPolicy(issue.Winner),
+		}
+		for j, policy := range issue.Policies {
+			output[i].Policies[j] = pe.toLintedPolicy(policy)
+		}
+	}
+	return output
+}
+
+func (lp *LintedPolicy) String() string {
+	entity := lp.Entity
+	if entity == "" {
+		entity = "hash:" + lp.Hash
+	}
+	list := lp.ListShortcode
+	if list == "" {
+		list = lp.List.String()
+	}
+	return fmt.Sprintf("%s (%s in %s)", format.SafeMarkdownCode(entity), format.SafeMarkdownCode(lp.Recommendation), format.SafeMarkdownCode(list))
+}
+
+var lintIssueTitles = map[policylist.LintIssueType]string{
+	policylist.LintConflict:       "Conflicting ban and unban policies",
+	policylist.LintDuplicate:      "Duplicate policies",
+	policylist.LintShadowed:       "Globs covered by broader globs",
+	policylist.LintHashedAndPlain: "Entities with both hashed and plaintext policies",
+	policylist.LintFiltered:       "Policies ignored by the hacky rule filter",
+	policylist.LintUntrusted:      "Policies ignored because the sender isn't trusted",
+}
+
+var lintIssueOrder = []policylist.LintIssueType{
+	policylist.LintConflict,
+	policylist.LintDuplicate,
+	policylist.LintShadowed,
+	policylist.LintHashedAndPlain,
+	policylist.LintFiltered,
+	policylist.LintUntrusted,
+}
+
+func formatLintIssue(issue *LintedIssue) string {
+	switch issue.Type {
+	case policylist.LintConflict:
+		if issue.Winner != nil {
+			return fmt.Sprintf("%s conflicts with %s, %s wins", issue.Policies[0], issue.Policies[1], issue.Winner)
+		}
+		return fmt.Sprintf("%s conflicts with %s", issue.Policies[0], issue.Policies[1])
+	case policylist.LintShadowed:
+		return fmt.Sprintf("%s is covered by %s", issue.Policies[0], issue.Policies[1])
+	default:
+		parts := make([]string, len(issue.Policies))
+		for i, policy := range issue.Policies {
+			parts[i] = policy.String()
+		}
+		return strings.Join(parts, " and ")
+	}
+}
+
+// FormatLintReport formats lint issues as a human-readable markdown summary.
+func FormatLintReport(issues []*LintedIssue) string {
+	if len(issues) == 0 {
+		return "No issues found in watched lists"
+	}
+	byType := make(map[policylist.LintIssueType][]*LintedIssue)
+	for _, issue := range issues {
+		byType[issue.Type] = append(byType[issue.Type], issue)
+	}
+	var buf strings.Builder
+	_, _ = fmt.Fprintf(&buf, "Found %d issues in watched lists\n", len(issues))
+	for _, issueType := range lintIssueOrder {
+		typeIssues := byType[issueType]
+		if len(typeIssues) == 0 {
+			continue
+		}
+		_, _ = fmt.Fprintf(&buf, "\n**%s** (%d):\n\n", lintIssueTitles[issueType], len(typeIssues))
+		for i, issue := range typeIssues {
+			if i >= maxLintIssuesPerType {
+				_, _ = fmt.Fprintf(&buf, "* ...and %d more\n", len(typeIssues)-i)
+				break
+			}
+			_, _ = fmt.Fprintf(&buf, "* %s\n", formatLintIssue(issue))
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,139 @@
+package policyeval
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/config"
+)
+
+// policyListWriterLevel is the power level required to send policies in policy lists created by the bot.
+const policyListWriterLevel = 50
+
+var policyEventTypes = []event.Type{
+	event.StatePolicyUser, event.StatePolicyRoom, event.StatePolicyServer,
+	event.StateLegacyPolicyUser, event.StateLegacyPolicyRoom, event.StateLegacyPolicyServer,
+	event.StateUnstablePolicyUser, event.StateUnstablePolicyRoom, event.StateUnstablePolicyServer,
+}
+
+type CreatePolicyListParams struct {
+	Name      string
+	Shortcode string
+	// Alias is the localpart of the alias to create for the room, if any.
+	Alias string
+	// Public lists are published in the room directory and can be joined by anyone.
+	Public bool
+	// Creator is invited to the room and given the writer power level.
+	Creator id.UserID
+}
+
+// CreatePolicyList creates a new policy list room and adds it to the watched lists of this management room.
+func (pe *PolicyEvaluator) CreatePolicyList(ctx context.Context, params *CreatePolicyListParams) (id.RoomID, error) {
+	powerLevels := &event.PowerLevelsEventContent{
+		Users: map[id.UserID]int{
+			pe.Bot.UserID:  100,
+			params.Creator: policyListWriterLevel,
+		},
+		Events: make(map[string]int, len(policyEventTypes)),
+	}
+	for _, evtType := range policyEventTypes {
+		powerLevels.Events[evtType.Type] = policyListWriterLevel
+	}
+	req := &mautrix.ReqCreateRoom{
+		Visibility:    "private",
+		Preset:        "private_chat",
+		RoomAliasName: params.Alias,
+		Name:          params.Name,
+		Topic:         "Policy list managed by " + pe.Bot.UserID.String(),
+		Invite:        []id.UserID{params.Creator},
+		InitialState: []*event.Event{{
+			Type: event.StateHistoryVisibility,
+			Content: event.Content{Parsed: &event.HistoryVisibilityEventContent{
+				HistoryVisibility: event.HistoryVisibilityShared,
+			}},
+		}},
+		PowerLevelOverride: powerLevels,
+	}
+	if params.Public {
+		req.Visibility = "public"
+		req.Preset = "public_chat"
+	}
+	resp, err := pe.Bot.CreateRoom(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("failed to create room: %w", err)
+	}
+	content := pe.cloneWatchedListsEvent()
+	content.Lists = append(content.Lists, config.WatchedPolicyList{
+		RoomID:    resp.RoomID,
+		Name:      params.Name,
+		Shortcode: params.Shortcode,
+	})
+	_, err = pe.Bot.SendStateEvent(ctx, pe.ManagementRoom, config.StateWatchedLists, "", content)
+	if err != nil {
+		return resp.RoomID, fmt.Errorf("failed to add room to watched lists: %w", err)
+	}
+	return resp.RoomID, nil
+}
+
+// getPolicyWriterLevel returns the power level required to send all types of policy events in the given room.
+func getPolicyWriterLevel(pls *event.PowerLevelsEventContent) (level int) {
+	for _, evtType := range policyEventTypes {
+		level = max(level, pls.GetEventLevel(evtType))
+	}
+	return
+}
+
+// GetPolicyListWriters returns the users who can send policies to the given policy list.
+func (pe *PolicyEvaluator) GetPolicyListWriters(ctx context.Context, roomID id.RoomID) ([]id.UserID, error) {
+	var pls event.PowerLevelsEventContent
+	err := pe.Bot.StateEvent(ctx, roomID, event.StatePowerLevels, "", &pls)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get power levels: %w", err)
+	}
+	level := getPolicyWriterLevel(&pls)
+	var writers []id.UserID
+	for userID, userLevel := range pls.Users {
+		if userLevel >= level {
+			writers = append(writers, userID)
+		}
+	}
+	slices.Sort(writers)
+	return writers, nil
+}
+
+// SetPolicyListWriter gives or removes the power level required to send policies to the given policy list.
+func (pe *PolicyEvaluator) SetPolicyListWriter(ctx context.Context, roomID id.RoomID, userID id.UserID, canWrite bool) error {
+	var pls event.PowerLevelsEventContent
+	err := pe.Bot.StateEvent(ctx, roomID, event.StatePowerLevels, "", &pls)
+	if err != nil {
+		return fmt.Errorf("failed to get power levels: %w", err)
+	}
+	level := getPolicyWriterLevel(&pls)
+	ownLevel := pls.GetUserLevel(pe.Bot.UserID)
+	userLevel := pls.GetUserLevel(userID)
+	if canWrite {
+		if userLevel >= level {
+			return fmt.Errorf("%s can already write to the list", userID)
+		} else if ownLevel < level || ownLevel < pls.GetEventLevel(event.StatePowerLevels) {
+			return fmt.Errorf("bot doesn't have sufficient power level in the list")
+		}
+		pls.SetUserLevel(userID, level)
+	} else {
+		if userLevel < level {
+			return fmt.Errorf("%s can't write to the list", userID)
+		} else if ownLevel <= userLevel || ownLevel < pls.GetEventLevel(event.StatePowerLevels) {
+			return fmt.Errorf("bot doesn't have sufficient power level to demote %s", userID)
+		}
+		pls.SetUserLevel(userID, pls.UsersDefault)
+	}
+	_, err = pe.Bot.SendStateEvent(ctx, roomID, event.StatePowerLevels, "", &pls)
+	if err != nil {
+		return fmt.Errorf("failed to update power levels: %w", err)
+	}
+	return nil
+}
@@ -39,15 +39,42 @@ type PolicyEvaluator struct {
 	Admins         *exsync.Set[id.UserID]
 
 	commandProcessor *commands.Processor[*PolicyEvaluator]
+	allCommands      []*Command
 
 	watchedListsEvent   *config.WatchedListsEventContent
 	watchedListsMap     map[id.RoomID]*config.WatchedPolicyList
 	watchedListsList    []id.RoomID
 	watchedListsForACLs []id.RoomID
+	watchedListModes    policylist.ListModes
+	watchedListTrust    policylist.ListTrust
 	watchedListsLock    sync.RWMutex
 
-	configLock sync.Mutex
-	aclLock    sync.Mutex
+	pendingListUpgrades     map[id.RoomID]*pendingListUpgrade
+	pendingListUpgradesLock sync.Mutex
+
+	heldLists       map[id.RoomID]*heldList
+	listChangeRates map[id.RoomID]*listChangeRate
+	heldListsLock   sync.RWMutex
+
+	digests     map[id.RoomID]*policyDigest
+	digestsLock sync.Mutex
+
+	joinRates     map[id.RoomID]*joinRate
+	joinRatesLock sync.Mutex
+
+	watchedMessagesSeen map[watchedUserRoom]struct{}
+	watchedMessagesLock sync.Mutex
+
+	noticeRouting     *config.NoticeRoutingEventContent
+	noticeRoutingLock sync.RWMutex
+
+	roles     *config.RolesEventContent
+	rolesLock sync.RWMutex
+
+	// The power levels of the management room, protected by configLock
+	powerLevels *event.PowerLevelsEventContent
+	configLock  sync.Mutex
+	aclLock     sync.Mutex
 
 	aclDeferChan chan struct{}
 
@@ -59,14 +86,27 @@ type PolicyEvaluator struct {
 	protectedRoomMembers map[id.UserID][]id.RoomID
 	memberHashes         map[[32]byte]id.UserID
 	skipACLForRooms      []id.RoomID
+	protectedSpaces      map[id.RoomID]*resolvedSpace
 	protectedRoomsLock   sync.RWMutex
 
+	// changedSpaces are protected spaces whose children changed since the last refresh.
+	// It's nil when no refresh is scheduled.
+	changedSpaces     map[id.RoomID]struct{}
+	changedSpacesLock sync.Mutex
+
 	pendingInvites     map[pendingInvite]struct{}
 	pendingInvitesLock sync.Mutex
 	AutoRejectInvites  bool
 	FilterLocalInvites bool
 	createPuppetClient func(userID id.UserID) *mautrix.Client
 	autoRedactPatterns []glob.Glob
+
+	evaluationConcurrency int
+	roomActions           *roomActionPool
+	actionsInFlight       *exsync.Set[string]
+	// supersededActions contains newer versions of in-flight actions, which are queued when the current attempt finishes.
+	supersededActions     map[string]*database.QueuedAction
+	supersededActionsLock sync.Mutex
 }
 
 func NewPolicyEvaluator(
@@ -78,8 +118,15 @@ func NewPolicyEvaluator(
 	claimProtected func(roomID id.RoomID, eval *PolicyEvaluator, claim bool) *PolicyEvaluator,
 	createPuppetClient func(userID id.UserID) *mautrix.Client,
 	autoRejectInvites, filterLocalInvites, dryRun bool,
+	evaluationConcurrency, actionConcurrency int,
 	hackyAutoRedactPatterns []glob.Glob,
 ) *PolicyEvaluator {
+	if evaluationConcurrency <= 0 {
+		evaluationConcurrency = defaultEvaluationConcurrency
+	}
+	if actionConcurrency <= 0 {
+		actionConcurrency = defaultActionConcurrency
+	}
 	pe := &PolicyEvaluator{
 		Bot:                  bot,
 		DB:                   db,
@@ -91,9 +138,16 @@ func NewPolicyEvaluator(
 		protectedRoomMembers: make(map[id.UserID][]id.RoomID),
 		memberHashes:         make(map[[32]byte]id.UserID),
 		watchedListsMap:      make(map[id.RoomID]*config.WatchedPolicyList),
+		pendingListUpgrades:  make(map[id.RoomID]*pendingListUpgrade),
+		heldLists:            make(map[id.RoomID]*heldList),
+		listChangeRates:      make(map[id.RoomID]*listChangeRate),
+		digests:              make(map[id.RoomID]*policyDigest),
+		joinRates:            make(map[id.RoomID]*joinRate),
+		watchedMessagesSeen:  make(map[watchedUserRoom]struct{}),
 		protectedRooms:       make(map[id.RoomID]*protectedRoomMeta),
 		wantToProtect:        make(map[id.RoomID]struct{}),
 		isJoining:            make(map[id.RoomID]struct{}),
+		protectedSpaces:      make(map[id.RoomID]*resolvedSpace),
 		aclDeferChan:         make(chan struct{}, 1),
 		claimProtected:       claimProtected,
 		pendingInvites:       make(map[pendingInvite]struct{}),
@@ -102,6 +156,11 @@ func NewPolicyEvaluator(
 		FilterLocalInvites:   filterLocalInvites,
 		DryRun:               dryRun,
 		autoRedactPatterns:   hackyAutoRedactPatterns,
+
+		evaluationConcurrency: evaluationConcurrency,
+		roomActions:           newRoomActionPool(actionConcurrency),
+		actionsInFlight:       exsync.NewSet[string](),
+		supersededActions:     make(map[string]*database.QueuedAction),
 	}
 	pe.commandProcessor.LogArgs = true
 	pe.commandProcessor.Meta = pe
@@ -110,7 +169,7 @@ func NewPolicyEvaluator(
 		commands.ValidatePrefixCommand[*PolicyEvaluator]("!meowlnir"),
 		commands.ValidatePrefixSubstring[*PolicyEvaluator]("!"),
 	}
-	pe.commandProcessor.Register(
+	pe.allCommands = []*Command{
 		cmdJoin,
 		cmdKnock,
 		cmdLeave,
@@ -118,6 +177,14 @@ func NewPolicyEvaluator(
 		cmdRedact,
 		cmdRedactRecent,
 		cmdKick,
+		cmdRoomBan,
+		cmdMute,
+		cmdUnmute,
+		cmdAudit,
+		cmdNote,
+		cmdNotes,
+		cmdLockdown,
+		cmdUnlock,
 		cmdBan,
 		cmdRemovePolicy,
 		cmdAddUnban,
@@ -127,10 +194,22 @@ func NewPolicyEvaluator(
 		cmdSuspend,
 		cmdDeactivate,
 		cmdRooms,
-		cmdProtectRoom,
+		cmdImport,
+		cmdExport,
+		cmdLint,
+		cmdLists,
+		cmdQueue,
 		cmdHelp,
-	)
+	}
+	for _, cmd := range pe.allCommands {
+		pe.commandProcessor.Register(cmd.Handler())
+	}
+	// Protecting rooms is also available as a top-level command for backwards compatibility
+	pe.commandProcessor.Register(cmdProtectRoom.Handler())
 	go pe.aclDeferLoop()
+	go pe.actionQueueLoop()
+	go pe.confirmationExpiryLoop()
+	go pe.muteExpiryLoop()
 	return pe
 }
 
@@ -145,6 +224,7 @@ func (pe *PolicyEvaluator) Load(ctx context.Context) {
 		pe.sendNotice(ctx, "Failed to load initial state: %v", err)
 	} else {
 		zerolog.Ctx(ctx).Info().Msg("Loaded initial state")
+		pe.resumeListUpgrades(ctx)
 	}
 }
 
@@ -157,6 +237,10 @@ func (pe *PolicyEvaluator) tryLoad(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("failed to get management room state: %w", err)
 	}
+	err = pe.loadHeldLists(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load held lists: %w", err)
+	}
 	var errors []string
 	if evt, ok := state[event.StatePowerLevels][""]; !ok {
 		return fmt.Errorf("no power level event found in management room")
@@ -169,6 +253,14 @@ func (pe *PolicyEvaluator) tryLoad(ctx context.Context) error {
 		_, errorMsgs := pe.handleWatchedLists(ctx, evt, true)
 		errors = append(errors, errorMsgs...)
 	}
+	if evt, ok := state[config.StateRoles][""]; ok {
+		_, errorMsgs := pe.handleRoles(evt)
+		errors = append(errors, errorMsgs...)
+	}
+	if evt, ok := state[config.StateNoticeRouting][""]; ok {
+		_, errorMsgs := pe.handleNoticeRouting(ctx, evt)
+		errors = append(errors, errorMsgs...)
+	}
 	if evt, ok := state[config.StateProtectedRooms][""]; !ok {
 		zerolog.Ctx(ctx).Info().Msg("No protected rooms event found in management room")
 	} else {
@@ -215,5 +307,6 @@ func (pe *PolicyEvaluator) handlePowerLevels(evt *event.Event) string {
 		}
 	}
 	pe.Admins.ReplaceAll(admins)
+	pe.powerLevels = content
 	return ""
 }
@@ -8,6 +8,7 @@ import (
 	"maunium.net/go/mautrix/event"
 
 	"go.mau.fi/meowlnir/bot"
+	"go.mau.fi/meowlnir/config"
 )
 
 func (pe *PolicyEvaluator) isMention(content *event.MessageEventContent) bool {
@@ -23,9 +24,10 @@ func (pe *PolicyEvaluator) HandleMessage(ctx context.Context, evt *event.Event)
 	if !ok {
 		return
 	}
+	pe.checkWatchlistMessage(ctx, evt)
 	if pe.isMention(content) {
-		pe.Bot.SendNoticeOpts(
-			ctx, pe.ManagementRoom,
+		pe.sendCategoryNoticeOpts(
+			ctx, config.NoticeCategoryPings,
 			fmt.Sprintf(
 				`@room [%s](%s) [pinged](%s) the bot in [%s](%s)`,
 				evt.Sender, evt.Sender.URI().MatrixToURL(),
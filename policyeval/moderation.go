@@ -0,0 +1,198 @@
+package policyeval
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/config"
+	"go.mau.fi/meowlnir/database"
+)
+
+// scopeFlags are the flags of manual moderation commands that limit which protected rooms the command acts in.
+var scopeFlags = []FlagSpec{
+	{Name: "space", Description: "Only act in protected rooms in the given space and its subspaces", Value: "space"},
+	{Name: "rooms", Description: "Only act in the given protected rooms (comma-separated IDs or aliases)", Value: "rooms"},
+}
+
+const scopeHelp = "By default, the command acts in all protected rooms. " +
+	"The `--space` and `--rooms` flags can be used to limit it to a subset of protected rooms."
+
+// resolveCommandScope returns the protected rooms a manual moderation command should act in based on the scope flags,
+// as well as a human-readable description of the scope. If the scope is invalid, an error is sent and ok is false.
+func (pe *PolicyEvaluator) resolveCommandScope(ce *CommandEvent, args *CommandArgs) (rooms []id.RoomID, scope string, ok bool) {
+	switch {
+	case args.HasFlag("space") && args.HasFlag("rooms"):
+		args.ReplyUsage(ce, "Only one of `--space` and `--rooms` can be used at a time")
+		return nil, "", false
+	case args.HasFlag("space"):
+		spaceID := resolveRoom(ce, args.Flag("space"))
+		if spaceID == "" {
+			return nil, "", false
+		}
+		space, _, err := pe.walkSpace(ce.Ctx, config.ProtectedSpace{RoomID: spaceID})
+		if err != nil {
+			ce.Reply("Failed to get rooms in space [%s](%s): %v", spaceID, spaceID.URI().MatrixToURL(), err)
+			return nil, "", false
+		}
+		rooms = slices.DeleteFunc(space.Rooms, func(roomID id.RoomID) bool {
+			return !pe.IsProtectedRoom(roomID)
+		})
+		if len(rooms) == 0 {
+			ce.Reply("No protected rooms found in space [%s](%s)", spaceID, spaceID.URI().MatrixToURL())
+			return nil, "", false
+		}
+		scope = fmt.Sprintf("%s in space [%s](%s)", pluralize(len(rooms), "protected room"), spaceID, spaceID.URI().MatrixToURL())
+	case args.HasFlag("rooms"):
+		for _, rawRoom := range strings.Split(args.Flag("rooms"), ",") {
+			rawRoom = strings.TrimSpace(rawRoom)
+			if rawRoom == "" {
+				continue
+			}
+			roomID := resolveRoom(ce, rawRoom)
+			if roomID == "" {
+				return nil, "", false
+			} else if !pe.IsProtectedRoom(roomID) {
+				ce.Reply("[%s](%s) is not a protected room", roomID, roomID.URI().MatrixToURL())
+				return nil, "", false
+			} else if !slices.Contains(rooms, roomID) {
+				rooms = append(rooms, roomID)
+			}
+		}
+		if len(rooms) == 0 {
+			args.ReplyUsage(ce, "No rooms specified")
+			return nil, "", false
+		}
+		scope = pluralize(len(rooms), "room")
+	default:
+		rooms = pe.GetProtectedRooms()
+		if len(rooms) == 0 {
+			ce.Reply("There are no protected rooms")
+			return nil, "", false
+		}
+		scope = fmt.Sprintf("all %s", pluralize(len(rooms), "protected room"))
+	}
+	return rooms, scope, true
+}
+
+// runScopedAction runs the given function for each room in parallel, records successful actions in the moderation log
+// and returns the number of rooms where the action succeeded along with a list of errors.
+func (pe *PolicyEvaluator) runScopedAction(
+	ce *CommandEvent,
+	action database.ModerationAction,
+	userID id.UserID,
+	reason string,
+	rooms []id.RoomID,
+	fn func(roomID id.RoomID) error,
+) (successCount int, errs []string) {
+	return pe.runRoomAction(rooms, func(roomID id.RoomID) error {
+		err := fn(roomID)
+		if err != nil || pe.DryRun {
+			return err
+		}
+		entry := &database.ModerationLogEntry{
+			ManagementRoom: pe.ManagementRoom,
+			CommandEventID: ce.Event.ID,
+			Moderator:      ce.Event.Sender,
+			Action:         action,
+			TargetUser:     userID,
+			RoomID:         roomID,
+			Reason:         reason,
+			TakenAt:        time.Now(),
+		}
+		if dbErr := pe.DB.ModerationLog.Put(ce.Ctx, entry); dbErr != nil {
+			zerolog.Ctx(ce.Ctx).Err(dbErr).Any("moderation_log_entry", entry).Msg("Failed to save moderation log entry")
+		}
+		return nil
+	})
+}
+
+// runRoomAction runs the given function for each room in parallel
+// and returns the number of rooms where it succeeded along with a list of errors.
+func (pe *PolicyEvaluator) runRoomAction(rooms []id.RoomID, fn func(roomID id.RoomID) error) (successCount int, errs []string) {
+	var lock sync.Mutex
+	pe.roomActions.SubmitAndWait(rooms, func(roomID id.RoomID) {
+		err := fn(roomID)
+		lock.Lock()
+		defer lock.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("* [%s](%s): %v", roomID, roomID.URI().MatrixToURL(), err))
+		} else {
+			successCount++
+		}
+	})
+	slices.Sort(errs)
+	return
+}
+
+func replyRoomAction(ce *CommandEvent, action, scope string, successCount int, errs []string) {
+	message := fmt.Sprintf("%s %d/%d rooms (%s)", action, successCount, successCount+len(errs), scope)
+	if len(errs) > 0 {
+		message += "\n\nErrors:\n\n" + strings.Join(errs, "\n")
+	}
+	ce.Reply(message)
+	if successCount > 0 {
+		ce.React(SuccessReaction)
+	}
+}
+
+func describeModerationAction(action database.ModerationAction) string {
+	switch action {
+	case database.ModerationActionBan:
+		return "banned"
+	case database.ModerationActionUnban:
+		return "unbanned"
+	case database.ModerationActionMute:
+		return "muted"
+	case database.ModerationActionUnmute:
+		return "unmuted"
+	default:
+		return string(action)
+	}
+}
+
+// formatModerationLog formats moderation log entries for the `!audit` command.
+// Entries from the same command are merged into one line.
+func formatModerationLog(entries []*database.ModerationLogEntry) string {
+	type logKey struct {
+		eventID id.EventID
+		action  database.ModerationAction
+		target  id.UserID
+	}
+	var keys []logKey
+	grouped := make(map[logKey][]*database.ModerationLogEntry)
+	for _, entry := range entries {
+		key := logKey{entry.CommandEventID, entry.Action, entry.TargetUser}
+		if _, exists := grouped[key]; !exists {
+			keys = append(keys, key)
+		}
+		grouped[key] = append(grouped[key], entry)
+	}
+	var buf strings.Builder
+	for _, key := range keys {
+		group := grouped[key]
+		first := group[0]
+		var where string
+		if len(group) == 1 {
+			where = fmt.Sprintf("[%s](%s)", first.RoomID, first.RoomID.URI().MatrixToURL())
+		} else {
+			where = pluralize(len(group), "room")
+		}
+		_, _ = fmt.Fprintf(
+			&buf, "* %s: [%s](%s) %s [%s](%s) in %s",
+			first.TakenAt.UTC().Format(time.DateTime), first.Moderator, first.Moderator.URI().MatrixToURL(),
+			describeModerationAction(first.Action), first.TargetUser, first.TargetUser.URI().MatrixToURL(), where,
+		)
+		if first.Reason != "" {
+			_, _ = fmt.Fprintf(&buf, " for %s", format.EscapeMarkdown(first.Reason))
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
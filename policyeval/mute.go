@@ -0,0 +1,267 @@
+package policyeval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/config"
+	"go.mau.fi/meowlnir/database"
+	"go.mau.fi/meowlnir/policylist"
+)
+
+const muteExpiryPollInterval = 1 * time.Minute
+
+var (
+	errAlreadyMuted = errors.New("user is already muted")
+	errNotMuted     = errors.New("user was not muted by the bot")
+)
+
+// parseMuteDuration parses a duration like `30m`, `12h` or `7d`.
+func parseMuteDuration(input string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(input, "d"); ok {
+		count, err := strconv.Atoi(days)
+		if err != nil || count <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", input)
+		}
+		return time.Duration(count) * 24 * time.Hour, nil
+	}
+	duration, err := time.ParseDuration(input)
+	if err != nil {
+		return 0, err
+	} else if duration <= 0 {
+		return 0, fmt.Errorf("duration must be positive")
+	}
+	return duration, nil
+}
+
+// unlockedEventsDefault returns the level required to send messages in the given room outside lockdowns.
+// Mute lockdowns raise events_default above users_default, so mutes are based on the level from before the lockdown.
+func (pe *PolicyEvaluator) unlockedEventsDefault(ctx context.Context, roomID id.RoomID, pls *event.PowerLevelsEventContent) (int, error) {
+	rl, err := pe.DB.RoomLockdown.Get(ctx, roomID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get lockdown from database: %w", err)
+	} else if rl != nil && rl.PreviousEventsDefault != nil {
+		return *rl.PreviousEventsDefault, nil
+	}
+	return pls.EventsDefault, nil
+}
+
+// setMutedPowerLevel lowers the power level of the given user below the level required to send messages
+// in the given room and returns the level the user had before.
+func (pe *PolicyEvaluator) setMutedPowerLevel(ctx context.Context, roomID id.RoomID, userID id.UserID) (int, error) {
+	var pls event.PowerLevelsEventContent
+	err := pe.Bot.StateEvent(ctx, roomID, event.StatePowerLevels, "", &pls)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get power levels: %w", err)
+	}
+	eventsDefault, err := pe.unlockedEventsDefault(ctx, roomID, &pls)
+	if err != nil {
+		return 0, err
+	}
+	mutedLevel := eventsDefault - 1
+	userLevel := pls.GetUserLevel(userID)
+	ownLevel := pls.GetUserLevel(pe.Bot.UserID)
+	if userLevel <= mutedLevel {
+		return userLevel, errAlreadyMuted
+	} else if ownLevel <= userLevel || ownLevel < pls.GetEventLevel(event.StatePowerLevels) {
+		return userLevel, fmt.Errorf("bot doesn't have sufficient power level to mute %s", userID)
+	}
+	pls.SetUserLevel(userID, mutedLevel)
+	if pe.DryRun {
+		return userLevel, nil
+	}
+	_, err = pe.Bot.SendStateEvent(ctx, roomID, event.StatePowerLevels, "", &pls)
+	if err != nil {
+		return userLevel, fmt.Errorf("failed to update power levels: %w", err)
+	}
+	return userLevel, nil
+}
+
+// restorePowerLevel sets the power level of a muted user back to the given level.
+// If the user isn't muted anymore, e.g. because a moderator changed their level manually, nothing is changed.
+func (pe *PolicyEvaluator) restorePowerLevel(ctx context.Context, roomID id.RoomID, userID id.UserID, level int) error {
+	var pls event.PowerLevelsEventContent
+	err := pe.Bot.StateEvent(ctx, roomID, event.StatePowerLevels, "", &pls)
+	if err != nil {
+		return fmt.Errorf("failed to get power levels: %w", err)
+	}
+	eventsDefault, err := pe.unlockedEventsDefault(ctx, roomID, &pls)
+	if err != nil {
+		return err
+	} else if pls.GetUserLevel(userID) >= eventsDefault {
+		return nil
+	}
+	ownLevel := pls.GetUserLevel(pe.Bot.UserID)
+	if ownLevel <= level || ownLevel < pls.GetEventLevel(event.StatePowerLevels) {
+		return fmt.Errorf("bot doesn't have sufficient power level to unmute %s", userID)
+	}
+	pls.SetUserLevel(userID, level)
+	if pe.DryRun {
+		return nil
+	}
+	_, err = pe.Bot.SendStateEvent(ctx, roomID, event.StatePowerLevels, "", &pls)
+	if err != nil {
+		return fmt.Errorf("failed to update power levels: %w", err)
+	}
+	return nil
+}
+
+// ApplyMute mutes the target user of the given action in its room and saves the action in the database.
+//
+// If the user was already muted by the bot, the power level from the original mute is kept so that it can be
+// restored correctly, and only the source and expiry of the mute are updated.
+func (pe *PolicyEvaluator) ApplyMute(ctx context.Context, ta *database.TakenAction) error {
+	ta.ActionType = database.TakenActionTypeMute
+	ta.Action = policylist.PolicyRecommendationMute
+	ta.TakenAt = time.Now()
+	existing, err := pe.DB.TakenAction.Get(ctx, ta.TargetUser, ta.InRoomID, database.TakenActionTypeMute)
+	if err != nil {
+		return fmt.Errorf("failed to get existing mute: %w", err)
+	}
+	previousLevel, err := pe.setMutedPowerLevel(ctx, ta.InRoomID, ta.TargetUser)
+	if existing != nil && existing.PreviousPowerLevel != nil {
+		previousLevel = *existing.PreviousPowerLevel
+	}
+	if err != nil && (existing == nil || !errors.Is(err, errAlreadyMuted)) {
+		return err
+	}
+	if existing != nil && existing.PolicyList != "" && ta.PolicyList == "" {
+		// Manual mutes don't replace the policy that caused the existing mute,
+		// so the mute stays as long as the policy applies even if the manual duration expires.
+		ta.PolicyList = existing.PolicyList
+		ta.RuleEntity = existing.RuleEntity
+	}
+	ta.PreviousPowerLevel = &previousLevel
+	if pe.DryRun {
+		return nil
+	}
+	err = pe.DB.TakenAction.Put(ctx, ta)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Any("taken_action", ta).Msg("Failed to save taken action")
+		return fmt.Errorf("muted, but failed to save to database: %w", err)
+	}
+	zerolog.Ctx(ctx).Info().Any("taken_action", ta).Msg("Took action")
+	return nil
+}
+
+// UndoMute restores the power level of a user muted by the bot and deletes the taken action.
+func (pe *PolicyEvaluator) UndoMute(ctx context.Context, userID id.UserID, roomID id.RoomID) error {
+	ta, err := pe.DB.TakenAction.Get(ctx, userID, roomID, database.TakenActionTypeMute)
+	if err != nil {
+		return fmt.Errorf("failed to get mute from database: %w", err)
+	} else if ta == nil {
+		return errNotMuted
+	}
+	if ta.PreviousPowerLevel != nil {
+		err = pe.restorePowerLevel(ctx, roomID, userID, *ta.PreviousPowerLevel)
+		if err != nil {
+			return err
+		}
+	}
+	if pe.DryRun {
+		return nil
+	}
+	err = pe.DB.TakenAction.Delete(ctx, userID, roomID, database.TakenActionTypeMute)
+	if err != nil {
+		return fmt.Errorf("unmuted, but failed to delete mute from database: %w", err)
+	}
+	zerolog.Ctx(ctx).Debug().Stringer("user_id", userID).Stringer("room_id", roomID).Msg("Unmuted user")
+	return nil
+}
+
+func (pe *PolicyEvaluator) applyMutePolicy(ctx context.Context, userID id.UserID, roomID id.RoomID, policy *policylist.Policy) {
+	existing, err := pe.DB.TakenAction.Get(ctx, userID, roomID, database.TakenActionTypeMute)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("user_id", userID).Msg("Failed to get existing mute")
+		return
+	} else if existing != nil && existing.PolicyList == policy.RoomID && existing.RuleEntity == policy.EntityOrHash() {
+		return
+	}
+	err = pe.ApplyMute(ctx, &database.TakenAction{
+		TargetUser: userID,
+		InRoomID:   roomID,
+		PolicyList: policy.RoomID,
+		RuleEntity: policy.EntityOrHash(),
+	})
+	if errors.Is(err, errAlreadyMuted) {
+		zerolog.Ctx(ctx).Debug().Stringer("user_id", userID).Stringer("room_id", roomID).Msg("User is already muted, not applying mute policy")
+	} else if err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("user_id", userID).Stringer("room_id", roomID).Msg("Failed to apply mute policy")
+		pe.sendCategoryNotice(ctx, config.NoticeCategoryActions, "Failed to mute [%s](%s) in [%s](%s): %v", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL(), err)
+	} else {
+		pe.sendCategoryNotice(ctx, config.NoticeCategoryActions, "Muted [%s](%s) in [%s](%s) for %s", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL(), policy.Reason)
+	}
+}
+
+// ReevaluateMute unmutes the user if the policy that caused a mute was removed or the mute expired,
+// and no other mute policy matches.
+func (pe *PolicyEvaluator) ReevaluateMute(ctx context.Context, action *database.TakenAction) {
+	log := zerolog.Ctx(ctx).With().Any("action", action).Logger()
+	ctx = log.WithContext(ctx)
+	expired := !action.ExpiresAt.IsZero() && !action.ExpiresAt.After(time.Now())
+	match := pe.matchUser(pe.GetWatchedLists(), action.TargetUser)
+	if rec := pe.recommendations(match).Mute; rec != nil || (!action.ExpiresAt.IsZero() && !expired) {
+		if rec != nil {
+			action.PolicyList = rec.RoomID
+			action.RuleEntity = rec.EntityOrHash()
+		} else {
+			// The mute policy was removed, but the mute has a manual duration which hasn't expired yet
+			action.PolicyList = ""
+			action.RuleEntity = ""
+		}
+		if expired {
+			// The manual duration expired, but a policy still requires the mute
+			action.ExpiresAt = time.Time{}
+		}
+		err := pe.DB.TakenAction.Put(ctx, action)
+		if err != nil {
+			log.Err(err).Msg("Failed to update taken action source")
+		}
+		return
+	}
+	reason := "as the mute policy was removed"
+	if expired {
+		reason = "as the mute expired"
+	}
+	log.Debug().Msg("Unmuting user")
+	err := pe.UndoMute(ctx, action.TargetUser, action.InRoomID)
+	if err != nil {
+		log.Err(err).Msg("Failed to unmute user")
+		pe.sendCategoryNotice(ctx, config.NoticeCategoryActions, "Failed to unmute [%s](%s) in [%s](%s) %s: %v", action.TargetUser, action.TargetUser.URI().MatrixToURL(), action.InRoomID, action.InRoomID.URI().MatrixToURL(), reason, err)
+	} else {
+		pe.sendCategoryNotice(ctx, config.NoticeCategoryActions, "Unmuted [%s](%s) in [%s](%s) %s", action.TargetUser, action.TargetUser.URI().MatrixToURL(), action.InRoomID, action.InRoomID.URI().MatrixToURL(), reason)
+	}
+}
+
+func (pe *PolicyEvaluator) muteExpiryLoop() {
+	ctx := pe.Bot.Log.With().
+		Str("action", "mute expiry").
+		Stringer("management_room", pe.ManagementRoom).
+		Logger().
+		WithContext(context.Background())
+	ticker := time.NewTicker(muteExpiryPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		expired, err := pe.DB.TakenAction.GetExpired(ctx, database.TakenActionTypeMute, time.Now())
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Msg("Failed to get expired mutes")
+			continue
+		}
+		for _, ta := range expired {
+			// The taken action table is shared by all management rooms, so only handle rooms protected by this one
+			if !pe.IsProtectedRoom(ta.InRoomID) {
+				continue
+			}
+			// Go through the normal re-evaluation, so that mutes which are also required by a policy are kept
+			pe.ReevaluateMute(ctx, ta)
+		}
+	}
+}
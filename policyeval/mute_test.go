@@ -0,0 +1,38 @@
+package policyeval
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseMuteDuration(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		err      bool
+	}{
+		{input: "30m", expected: 30 * time.Minute},
+		{input: "1h30m", expected: 90 * time.Minute},
+		{input: "2d", expected: 48 * time.Hour},
+		{input: "0d", err: true},
+		{input: "-1d", err: true},
+		{input: "xd", err: true},
+		{input: "0s", err: true},
+		{input: "-5m", err: true},
+		{input: "forever", err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			duration, err := parseMuteDuration(test.input)
+			if test.err {
+				if err == nil {
+					t.Errorf("parseMuteDuration(%q) = %v; expected error", test.input, duration)
+				}
+			} else if err != nil {
+				t.Errorf("parseMuteDuration(%q) returned unexpected error: %v", test.input, err)
+			} else if duration != test.expected {
+				t.Errorf("parseMuteDuration(%q) = %v; expected %v", test.input, duration, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package policyeval
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/format"
+
+	"go.mau.fi/meowlnir/database"
+)
+
+func formatNotes(notes []*database.Note) string {
+	var buf strings.Builder
+	for _, note := range notes {
+		_, _ = fmt.Fprintf(
+			&buf, "* %s: [%s](%s): %s\n",
+			note.CreatedAt.UTC().Format(time.DateTime), note.Author, note.Author.URI().MatrixToURL(),
+			format.EscapeMarkdown(note.Text),
+		)
+	}
+	return buf.String()
+}
+
+// notesSection returns the notes about the given entity formatted for appending to a notice,
+// or an empty string if there are no notes.
+func (pe *PolicyEvaluator) notesSection(ctx context.Context, entity string) string {
+	notes, err := pe.DB.Note.GetByEntity(ctx, pe.ManagementRoom, entity)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Str("entity", entity).Msg("Failed to get notes")
+		return ""
+	} else if len(notes) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\n\nNotes about %s:\n\n%s", format.SafeMarkdownCode(entity), formatNotes(notes))
+}
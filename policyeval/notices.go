@@ -0,0 +1,84 @@
+package policyeval
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/bot"
+	"go.mau.fi/meowlnir/config"
+)
+
+func (pe *PolicyEvaluator) getNoticeRoute(category config.NoticeCategory) (roomID id.RoomID, ping bool) {
+	pe.noticeRoutingLock.RLock()
+	defer pe.noticeRoutingLock.RUnlock()
+	roomID = pe.ManagementRoom
+	if pe.noticeRouting == nil {
+		return
+	}
+	if target, ok := pe.noticeRouting.Rooms[category]; ok && target != "" {
+		roomID = target
+	}
+	ping = slices.Contains(pe.noticeRouting.Ping, category)
+	return
+}
+
+// sendCategoryNotice sends a notice to the room configured for the given category in the notice routing event.
+func (pe *PolicyEvaluator) sendCategoryNotice(ctx context.Context, category config.NoticeCategory, message string, args ...any) {
+	if len(args) > 0 {
+		message = fmt.Sprintf(message, args...)
+	}
+	pe.sendCategoryNoticeOpts(ctx, category, message, nil)
+}
+
+func (pe *PolicyEvaluator) sendCategoryNoticeOpts(ctx context.Context, category config.NoticeCategory, message string, opts *bot.SendNoticeOpts) id.EventID {
+	roomID, ping := pe.getNoticeRoute(category)
+	if ping {
+		var optsCopy bot.SendNoticeOpts
+		if opts != nil {
+			optsCopy = *opts
+		}
+		optsCopy.Mentions = &event.Mentions{Room: true}
+		opts = &optsCopy
+		if !strings.HasPrefix(message, "@room") {
+			message = "@room " + message
+		}
+	}
+	return pe.Bot.SendNoticeOpts(ctx, roomID, message, opts)
+}
+
+func (pe *PolicyEvaluator) handleNoticeRouting(ctx context.Context, evt *event.Event) (output, errors []string) {
+	content, ok := evt.Content.Parsed.(*config.NoticeRoutingEventContent)
+	if !ok {
+		return nil, []string{"* Failed to parse notice routing event"}
+	}
+	for category, roomID := range content.Rooms {
+		if !slices.Contains(config.NoticeCategories, category) {
+			errors = append(errors, fmt.Sprintf("* Unknown notice category `%s`", category))
+			continue
+		} else if roomID == "" || roomID == pe.ManagementRoom {
+			continue
+		}
+		_, err := pe.Bot.JoinRoomByID(ctx, roomID)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Stringer("room_id", roomID).Msg("Failed to join notice room")
+			errors = append(errors, fmt.Sprintf("* Failed to join room [%s](%s) for `%s` notices: %v", roomID, roomID.URI().MatrixToURL(), category, err))
+		} else {
+			output = append(output, fmt.Sprintf("* Sending `%s` notices to [%s](%s)", category, roomID, roomID.URI().MatrixToURL()))
+		}
+	}
+	for _, category := range content.Ping {
+		if !slices.Contains(config.NoticeCategories, category) {
+			errors = append(errors, fmt.Sprintf("* Unknown notice category `%s` in ping list", category))
+		}
+	}
+	pe.noticeRoutingLock.Lock()
+	pe.noticeRouting = content
+	pe.noticeRoutingLock.Unlock()
+	return
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/rs/zerolog"
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
 	"maunium.net/go/mautrix/id"
 
 	"go.mau.fi/meowlnir/config"
@@ -31,6 +32,17 @@ func (pe *PolicyEvaluator) IsProtectedRoom(roomID id.RoomID) bool {
 	return protected
 }
 
+// getRoomName returns the name of the given protected room, or the room ID if the room doesn't have a name.
+func (pe *PolicyEvaluator) getRoomName(roomID id.RoomID) string {
+	pe.protectedRoomsLock.RLock()
+	meta, ok := pe.protectedRooms[roomID]
+	pe.protectedRoomsLock.RUnlock()
+	if !ok || meta.Name == "" {
+		return roomID.String()
+	}
+	return format.EscapeMarkdown(meta.Name)
+}
+
 func (pe *PolicyEvaluator) HandleProtectedRoomMeta(ctx context.Context, evt *event.Event) {
 	switch evt.Type {
 	case event.StatePowerLevels:
@@ -72,7 +84,7 @@ func (pe *PolicyEvaluator) handleProtectedRoomPowerLevels(ctx context.Context, e
 		minLevel = max(minLevel, powerLevels.GetEventLevel(event.StateServerACL))
 	}
 	if isProtecting && ownLevel < minLevel {
-		pe.sendNotice(ctx, "⚠️ Bot no longer has sufficient power level in [%s](%s) (have %d, minimum %d)", evt.RoomID, evt.RoomID.URI().MatrixToURL(), ownLevel, minLevel)
+		pe.sendCategoryNotice(ctx, config.NoticeCategoryAlerts, "⚠️ Bot no longer has sufficient power level in [%s](%s) (have %d, minimum %d)", evt.RoomID, evt.RoomID.URI().MatrixToURL(), ownLevel, minLevel)
 	} else if wantToProtect && ownLevel >= minLevel {
 		_, errMsg := pe.tryProtectingRoom(ctx, nil, evt.RoomID, true)
 		if errMsg != "" {
@@ -177,11 +189,35 @@ func (pe *PolicyEvaluator) handleProtectedRooms(ctx context.Context, evt *event.
 	if !ok {
 		return nil, []string{"* Failed to parse protected rooms event"}
 	}
+	return pe.applyProtectedRooms(ctx, content, isInitial, nil)
+}
+
+// applyProtectedRooms updates the protected rooms based on the given event content.
+// If changedSpaces is non-nil, only protected spaces containing those spaces are walked again.
+func (pe *PolicyEvaluator) applyProtectedRooms(
+	ctx context.Context, content *config.ProtectedRoomsEventContent, isInitial bool, changedSpaces map[id.RoomID]struct{},
+) (output, errors []string) {
+	if rp := content.RaidProtection; rp != nil && rp.Lockdown != "" && !slices.Contains(config.LockdownModes, rp.Lockdown) {
+		errors = append(errors, fmt.Sprintf("* Unknown raid lockdown mode `%s`, raids will only be reported", rp.Lockdown))
+	}
+	rooms := slices.Clone(content.Rooms)
+	pe.protectedRoomsLock.RLock()
+	hadSpaces := len(pe.protectedSpaces) > 0
+	pe.protectedRoomsLock.RUnlock()
+	if len(content.Spaces) > 0 || hadSpaces {
+		spaceRooms, spaceErrors := pe.resolveProtectedSpaces(ctx, content.Spaces, changedSpaces)
+		errors = append(errors, spaceErrors...)
+		for _, roomID := range spaceRooms {
+			if !slices.Contains(rooms, roomID) {
+				rooms = append(rooms, roomID)
+			}
+		}
+	}
 	pe.protectedRoomsLock.Lock()
 	pe.protectedRoomsEvent = content
 	pe.skipACLForRooms = content.SkipACL
 	for roomID := range pe.protectedRooms {
-		if !slices.Contains(content.Rooms, roomID) {
+		if !slices.Contains(rooms, roomID) {
 			delete(pe.protectedRooms, roomID)
 			pe.claimProtected(roomID, pe, false)
 			output = append(output, fmt.Sprintf("* Stopped protecting room [%s](%s)", roomID, roomID.URI().MatrixToURL()))
@@ -190,12 +226,12 @@ func (pe *PolicyEvaluator) handleProtectedRooms(ctx context.Context, evt *event.
 	pe.protectedRoomsLock.Unlock()
 	joinedRooms, err := pe.Bot.JoinedRooms(ctx)
 	if err != nil {
-		return output, []string{"* Failed to get joined rooms: ", err.Error()}
+		return output, append(errors, "* Failed to get joined rooms: ", err.Error())
 	}
 	var outLock sync.Mutex
 	reevalMembers := make(map[id.UserID]struct{})
 	var wg sync.WaitGroup
-	for _, roomID := range content.Rooms {
+	for _, roomID := range rooms {
 		if pe.IsProtectedRoom(roomID) {
 			continue
 		}
@@ -0,0 +1,220 @@
+package policyeval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/config"
+	"go.mau.fi/meowlnir/database"
+)
+
+var (
+	errAlreadyLockedDown = errors.New("room is already locked down")
+	errNotLockedDown     = errors.New("room is not locked down")
+)
+
+// joinRate tracks recent joins to a protected room in a sliding window.
+type joinRate struct {
+	joins []time.Time
+	// raidUntil is extended by the window on every join during a raid, so that a raid is only reported once.
+	raidUntil time.Time
+}
+
+func (pe *PolicyEvaluator) getRaidProtection() *config.RaidProtection {
+	pe.protectedRoomsLock.RLock()
+	defer pe.protectedRoomsLock.RUnlock()
+	if pe.protectedRoomsEvent == nil {
+		return nil
+	}
+	return pe.protectedRoomsEvent.RaidProtection
+}
+
+// trackJoin records a join to the given protected room and starts raid handling if the join rate exceeds the threshold.
+func (pe *PolicyEvaluator) trackJoin(ctx context.Context, roomID id.RoomID) {
+	cfg := pe.getRaidProtection()
+	if cfg == nil || cfg.MaxJoins <= 0 {
+		return
+	}
+	window := cfg.Window()
+	now := time.Now()
+	pe.joinRatesLock.Lock()
+	rate, ok := pe.joinRates[roomID]
+	if !ok {
+		rate = &joinRate{}
+		pe.joinRates[roomID] = rate
+	}
+	cutoff := now.Add(-window)
+	firstInWindow, _ := slices.BinarySearchFunc(rate.joins, cutoff, time.Time.Compare)
+	rate.joins = append(rate.joins[firstInWindow:], now)
+	// Only MaxJoins+1 joins are needed to detect a raid, so don't keep more than that
+	if len(rate.joins) > cfg.MaxJoins+1 {
+		rate.joins = rate.joins[len(rate.joins)-cfg.MaxJoins-1:]
+	}
+	inRaid := now.Before(rate.raidUntil)
+	detected := !inRaid && len(rate.joins) > cfg.MaxJoins
+	if inRaid || detected {
+		rate.raidUntil = now.Add(window)
+	}
+	pe.joinRatesLock.Unlock()
+	if detected {
+		go pe.handleRaid(context.WithoutCancel(ctx), roomID, cfg)
+	}
+}
+
+func (pe *PolicyEvaluator) handleRaid(ctx context.Context, roomID id.RoomID, cfg *config.RaidProtection) {
+	log := zerolog.Ctx(ctx).With().Stringer("room_id", roomID).Logger()
+	ctx = log.WithContext(ctx)
+	log.Warn().
+		Int("max_joins", cfg.MaxJoins).
+		Stringer("window", cfg.Window()).
+		Msg("Join rate exceeded raid threshold")
+	message := fmt.Sprintf(
+		"⚠️ Possible raid in [%s](%s): more than %d joins within %s.",
+		roomID, roomID.URI().MatrixToURL(), cfg.MaxJoins, cfg.Window(),
+	)
+	if cfg.Lockdown != "" {
+		err := pe.LockdownRoom(ctx, roomID, cfg.Lockdown, pe.Bot.UserID, "automatic raid lockdown")
+		if errors.Is(err, errAlreadyLockedDown) {
+			message += " The room is already locked down."
+		} else if err != nil {
+			log.Err(err).Msg("Failed to lock down room")
+			message += fmt.Sprintf(" Failed to lock down room: %v", err)
+		} else {
+			message += fmt.Sprintf(
+				" The room has been locked down (%s), use %s to lift the lockdown.",
+				cfg.Lockdown, format.SafeMarkdownCode("!unlock --rooms="+roomID.String()),
+			)
+		}
+	}
+	pe.sendCategoryNotice(ctx, config.NoticeCategoryAlerts, message)
+}
+
+// LockdownRoom restricts the given room according to the lockdown mode and stores the previous state of the room,
+// so that it can be restored with [PolicyEvaluator.UnlockRoom].
+func (pe *PolicyEvaluator) LockdownRoom(ctx context.Context, roomID id.RoomID, mode config.LockdownMode, lockedBy id.UserID, reason string) error {
+	existing, err := pe.DB.RoomLockdown.Get(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("failed to get existing lockdown: %w", err)
+	} else if existing != nil {
+		return errAlreadyLockedDown
+	}
+	rl := &database.RoomLockdown{
+		RoomID:         roomID,
+		ManagementRoom: pe.ManagementRoom,
+		Mode:           string(mode),
+		LockedBy:       lockedBy,
+		Reason:         reason,
+		LockedAt:       time.Now(),
+	}
+	switch mode {
+	case config.LockdownModeInvite, config.LockdownModeKnock:
+		var joinRules event.JoinRulesEventContent
+		err = pe.Bot.StateEvent(ctx, roomID, event.StateJoinRules, "", &joinRules)
+		if err != nil {
+			return fmt.Errorf("failed to get join rules: %w", err)
+		}
+		newJoinRule := event.JoinRuleInvite
+		if mode == config.LockdownModeKnock {
+			newJoinRule = event.JoinRuleKnock
+		}
+		if joinRules.JoinRule == newJoinRule || joinRules.JoinRule == event.JoinRulePrivate {
+			return fmt.Errorf("room already has the %s join rule", joinRules.JoinRule)
+		}
+		rl.PreviousJoinRules = &joinRules
+		if !pe.DryRun {
+			_, err = pe.Bot.SendStateEvent(ctx, roomID, event.StateJoinRules, "", &event.JoinRulesEventContent{JoinRule: newJoinRule})
+			if err != nil {
+				return fmt.Errorf("failed to change join rules: %w", err)
+			}
+		}
+	case config.LockdownModeMute:
+		var pls event.PowerLevelsEventContent
+		err = pe.Bot.StateEvent(ctx, roomID, event.StatePowerLevels, "", &pls)
+		if err != nil {
+			return fmt.Errorf("failed to get power levels: %w", err)
+		}
+		if pls.EventsDefault > pls.UsersDefault {
+			return fmt.Errorf("default users already can't send messages")
+		} else if pls.GetUserLevel(pe.Bot.UserID) < pls.GetEventLevel(event.StatePowerLevels) {
+			return fmt.Errorf("bot doesn't have sufficient power level to change power levels")
+		}
+		previousEventsDefault := pls.EventsDefault
+		rl.PreviousEventsDefault = &previousEventsDefault
+		pls.EventsDefault = pls.UsersDefault + 1
+		if !pe.DryRun {
+			_, err = pe.Bot.SendStateEvent(ctx, roomID, event.StatePowerLevels, "", &pls)
+			if err != nil {
+				return fmt.Errorf("failed to update power levels: %w", err)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown lockdown mode %q", mode)
+	}
+	if pe.DryRun {
+		return nil
+	}
+	err = pe.DB.RoomLockdown.Put(ctx, rl)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Any("room_lockdown", rl).Msg("Failed to save room lockdown")
+		return fmt.Errorf("locked down, but failed to save to database: %w", err)
+	}
+	zerolog.Ctx(ctx).Info().Any("room_lockdown", rl).Msg("Locked down room")
+	return nil
+}
+
+// UnlockRoom restores the state of a room that was locked down by [PolicyEvaluator.LockdownRoom].
+// Settings that were changed manually during the lockdown are left alone.
+func (pe *PolicyEvaluator) UnlockRoom(ctx context.Context, roomID id.RoomID) error {
+	rl, err := pe.DB.RoomLockdown.Get(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("failed to get lockdown from database: %w", err)
+	} else if rl == nil {
+		return errNotLockedDown
+	}
+	if rl.PreviousJoinRules != nil {
+		var joinRules event.JoinRulesEventContent
+		err = pe.Bot.StateEvent(ctx, roomID, event.StateJoinRules, "", &joinRules)
+		if err != nil {
+			return fmt.Errorf("failed to get join rules: %w", err)
+		}
+		isLocked := (rl.Mode == string(config.LockdownModeInvite) && joinRules.JoinRule == event.JoinRuleInvite) ||
+			(rl.Mode == string(config.LockdownModeKnock) && joinRules.JoinRule == event.JoinRuleKnock)
+		if isLocked && !pe.DryRun {
+			_, err = pe.Bot.SendStateEvent(ctx, roomID, event.StateJoinRules, "", rl.PreviousJoinRules)
+			if err != nil {
+				return fmt.Errorf("failed to restore join rules: %w", err)
+			}
+		}
+	}
+	if rl.PreviousEventsDefault != nil {
+		var pls event.PowerLevelsEventContent
+		err = pe.Bot.StateEvent(ctx, roomID, event.StatePowerLevels, "", &pls)
+		if err != nil {
+			return fmt.Errorf("failed to get power levels: %w", err)
+		}
+		if pls.EventsDefault > *rl.PreviousEventsDefault && !pe.DryRun {
+			pls.EventsDefault = *rl.PreviousEventsDefault
+			_, err = pe.Bot.SendStateEvent(ctx, roomID, event.StatePowerLevels, "", &pls)
+			if err != nil {
+				return fmt.Errorf("failed to restore power levels: %w", err)
+			}
+		}
+	}
+	if pe.DryRun {
+		return nil
+	}
+	err = pe.DB.RoomLockdown.Delete(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("unlocked, but failed to delete lockdown from database: %w", err)
+	}
+	zerolog.Ctx(ctx).Info().Stringer("room_id", roomID).Msg("Lifted room lockdown")
+	return nil
+}
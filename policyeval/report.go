@@ -11,6 +11,7 @@ import (
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
+	"go.mau.fi/meowlnir/config"
 	"go.mau.fi/meowlnir/policylist"
 )
 
@@ -22,33 +23,37 @@ func (pe *PolicyEvaluator) HandleReport(ctx context.Context, senderClient *mautr
 		evt, err = senderClient.GetEvent(ctx, roomID, eventID)
 		if err != nil {
 			zerolog.Ctx(ctx).Err(err).Msg("Failed to get report target event with user's token")
-			pe.sendNotice(
-				ctx, `[%s](%s) reported [an event](%s) for %s, but the event could not be fetched: %v`,
+			pe.sendCategoryNotice(
+				ctx, config.NoticeCategoryReports, `[%s](%s) reported [an event](%s) for %s, but the event could not be fetched: %v`,
 				sender, sender.URI().MatrixToURL(), roomID.EventURI(eventID).MatrixToURL(), reason, err,
 			)
 			return fmt.Errorf("failed to fetch event: %w", err)
 		}
 		targetUserID = evt.Sender
 	}
-	if !pe.Admins.Has(sender) || !strings.HasPrefix(reason, "/") || targetUserID == "" {
+	var reportCommand string
+	if fields := strings.Fields(reason); len(fields) > 0 && strings.HasPrefix(fields[0], "/") {
+		reportCommand = strings.ToLower(strings.TrimPrefix(fields[0], "/"))
+	}
+	if reportCommand == "" || targetUserID == "" || !pe.CanUseCommand(sender, reportCommand) {
 		if eventID != "" {
-			pe.sendNotice(
-				ctx, `[%s](%s) reported [an event](%s) from [%s](%s) for %s`,
+			pe.sendCategoryNotice(
+				ctx, config.NoticeCategoryReports, `[%s](%s) reported [an event](%s) from [%s](%s) for %s%s`,
 				sender, sender.URI().MatrixToURL(), roomID.EventURI(eventID).MatrixToURL(),
 				evt.Sender, evt.Sender.URI().MatrixToURL(),
-				reason,
+				reason, pe.notesSection(ctx, evt.Sender.String()),
 			)
 		} else if roomID != "" {
-			pe.sendNotice(
-				ctx, `[%s](%s) reported [a room](%s) for %s`,
+			pe.sendCategoryNotice(
+				ctx, config.NoticeCategoryReports, `[%s](%s) reported [a room](%s) for %s%s`,
 				sender, sender.URI().MatrixToURL(), roomID.URI().MatrixToURL(),
-				reason,
+				reason, pe.notesSection(ctx, roomID.String()),
 			)
 		} else if targetUserID != "" {
-			pe.sendNotice(
-				ctx, `[%s](%s) reported [%s](%s) for %s`,
-				sender, sender.URI().MatrixToURL(), targetUserID.URI().MatrixToURL(),
-				reason,
+			pe.sendCategoryNotice(
+				ctx, config.NoticeCategoryReports, `[%s](%s) reported [%s](%s) for %s%s`,
+				sender, sender.URI().MatrixToURL(), targetUserID, targetUserID.URI().MatrixToURL(),
+				reason, pe.notesSection(ctx, targetUserID.String()),
 			)
 		}
 		return nil
@@ -63,7 +68,7 @@ func (pe *PolicyEvaluator) HandleReport(ctx context.Context, senderClient *mautr
 		}
 		list := pe.FindListByShortcode(args[0])
 		if list == nil {
-			pe.sendNotice(ctx, `Failed to handle [%s](%s)'s report of [%s](%s): list %q not found`,
+			pe.sendCategoryNotice(ctx, config.NoticeCategoryReports, `Failed to handle [%s](%s)'s report of [%s](%s): list %q not found`,
 				sender, sender.URI().MatrixToURL(), targetUserID, targetUserID.URI().MatrixToURL(), args[0])
 			return mautrix.MNotFound.WithMessage(fmt.Sprintf("List with shortcode %q not found", args[0]))
 		}
@@ -90,7 +95,7 @@ func (pe *PolicyEvaluator) HandleReport(ctx context.Context, senderClient *mautr
 		}
 		resp, err := pe.SendPolicy(ctx, list.RoomID, policylist.EntityTypeUser, "", string(targetUserID), policy)
 		if err != nil {
-			pe.sendNotice(ctx, `Failed to handle [%s](%s)'s report of [%s](%s) for %s ([%s](%s)): %v`,
+			pe.sendCategoryNotice(ctx, config.NoticeCategoryReports, `Failed to handle [%s](%s)'s report of [%s](%s) for %s ([%s](%s)): %v`,
 				sender, sender.URI().MatrixToURL(), targetUserID, targetUserID.URI().MatrixToURL(),
 				list.Name, list.RoomID, list.RoomID.URI().MatrixToURL(), err)
 			return fmt.Errorf("failed to send policy: %w", err)
@@ -100,9 +105,9 @@ func (pe *PolicyEvaluator) HandleReport(ctx context.Context, senderClient *mautr
 			Any("policy", policy).
 			Stringer("policy_event_id", resp.EventID).
 			Msg("Sent ban policy from report")
-		pe.sendNotice(ctx, `Processed [%s](%s)'s report of [%s](%s) and sent a ban policy to %s ([%s](%s)) for %s`,
+		pe.sendCategoryNotice(ctx, config.NoticeCategoryReports, `Processed [%s](%s)'s report of [%s](%s) and sent a ban policy to %s ([%s](%s)) for %s%s`,
 			sender, sender.URI().MatrixToURL(), targetUserID, targetUserID.URI().MatrixToURL(),
-			list.Name, list.RoomID, list.RoomID.URI().MatrixToURL(), policy.Reason)
+			list.Name, list.RoomID, list.RoomID.URI().MatrixToURL(), policy.Reason, pe.notesSection(ctx, targetUserID.String()))
 	}
 	return nil
 }
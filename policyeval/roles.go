@@ -0,0 +1,141 @@
+package policyeval
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"maunium.net/go/mautrix/commands"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/config"
+)
+
+// alwaysAllowedCommands can be used by anyone who is allowed to use any command.
+var alwaysAllowedCommands = []string{"help"}
+
+func (pe *PolicyEvaluator) handleRoles(evt *event.Event) (output, errors []string) {
+	content, ok := evt.Content.Parsed.(*config.RolesEventContent)
+	if !ok {
+		return nil, []string{"* Failed to parse roles event"}
+	}
+	// Roles can grant any command, so changing them requires the same power as changing the rest of the config,
+	// even if the roles event itself has a lower power level requirement in the room.
+	if pl := pe.powerLevels; pl == nil || pl.GetUserLevel(evt.Sender) < max(
+		pl.GetEventLevel(config.StateWatchedLists), pl.GetEventLevel(config.StateProtectedRooms),
+	) {
+		return nil, []string{fmt.Sprintf(
+			"* Ignoring roles set by [%s](%s): changing roles requires permission to change watched lists and protected rooms",
+			evt.Sender, evt.Sender.URI().MatrixToURL(),
+		)}
+	}
+	for userID, roles := range content.Users {
+		for _, role := range roles {
+			if _, exists := content.Roles[role]; !exists {
+				errors = append(errors, fmt.Sprintf("* Unknown role `%s` for [%s](%s)", role, userID, userID.URI().MatrixToURL()))
+			}
+		}
+	}
+	if _, exists := content.Roles[content.DefaultRole]; content.DefaultRole != "" && !exists {
+		errors = append(errors, fmt.Sprintf("* Unknown default role `%s`", content.DefaultRole))
+	}
+	if len(content.Roles) > 0 {
+		output = append(output, fmt.Sprintf("* Loaded %d roles for %d users", len(content.Roles), len(content.Users)))
+	} else {
+		output = append(output, "* No roles defined, all admins can use all commands")
+	}
+	pe.rolesLock.Lock()
+	pe.roles = content
+	pe.rolesLock.Unlock()
+	return
+}
+
+// getUserCommands returns the command specs the given user is allowed to use.
+// Only admins can use commands. If roles aren't configured, admins are allowed to use everything.
+func (pe *PolicyEvaluator) getUserCommands(userID id.UserID) []string {
+	if !pe.Admins.Has(userID) {
+		return nil
+	}
+	pe.rolesLock.RLock()
+	defer pe.rolesLock.RUnlock()
+	if pe.roles == nil || len(pe.roles.Roles) == 0 {
+		return []string{"*"}
+	}
+	roles, ok := pe.roles.Users[userID]
+	if !ok && pe.roles.DefaultRole != "" {
+		roles = []string{pe.roles.DefaultRole}
+	}
+	var allowed []string
+	for _, role := range roles {
+		allowed = append(allowed, pe.roles.Roles[role]...)
+	}
+	return allowed
+}
+
+// CanUseCommands returns true if the given user is allowed to use at least one command.
+func (pe *PolicyEvaluator) CanUseCommands(userID id.UserID) bool {
+	return len(pe.getUserCommands(userID)) > 0
+}
+
+// commandPath resolves the names of the handler and subcommand handler the given command would be executed by.
+// Both the name that was used and the primary name of each handler are returned.
+func (pe *PolicyEvaluator) commandPath(ce *CommandEvent) (used, primary []string) {
+	handler := pe.commandProcessor.GetHandler(ce.Command)
+	if handler == nil {
+		return []string{ce.Command}, []string{ce.Command}
+	}
+	used = []string{ce.Command}
+	primary = []string{handler.Name}
+	if len(ce.Args) > 0 {
+		subcommand := strings.ToLower(ce.Args[0])
+		for _, sub := range handler.Subcommands {
+			if sub.Name == subcommand || slices.Contains(sub.Aliases, subcommand) {
+				used = append(used, subcommand)
+				primary = append(primary, sub.Name)
+				break
+			}
+		}
+	}
+	return
+}
+
+// CanUseCommand checks whether the given user is allowed to use the command with the given name.
+func (pe *PolicyEvaluator) CanUseCommand(userID id.UserID, path ...string) bool {
+	allowed := pe.getUserCommands(userID)
+	return slices.Contains(allowed, "*") || commandAllowed(allowed, path)
+}
+
+func commandAllowed(allowed []string, path []string) bool {
+	for i := range path {
+		if slices.Contains(allowed, strings.Join(path[:i+1], " ")) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCommandPermission checks whether the sender of the given command event is allowed to use the command.
+// If the user isn't allowed to use any commands, the command is ignored silently.
+func (pe *PolicyEvaluator) checkCommandPermission(ctx context.Context, evt *event.Event) bool {
+	allowed := pe.getUserCommands(evt.Sender)
+	if len(allowed) == 0 {
+		return false
+	} else if slices.Contains(allowed, "*") {
+		return true
+	}
+	ce := commands.ParseEvent[*PolicyEvaluator](ctx, evt)
+	if ce == nil || !pe.commandProcessor.PreValidator.Validate(ce) {
+		return false
+	}
+	used, primary := pe.commandPath(ce)
+	if slices.Contains(alwaysAllowedCommands, primary[0]) || commandAllowed(allowed, used) || commandAllowed(allowed, primary) {
+		return true
+	}
+	ce.Ctx = ctx
+	ce.Proc = pe.commandProcessor
+	ce.Meta = pe
+	ce.Reply("You don't have permission to use `%s`", strings.Join(primary, " "))
+	return false
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"slices"
 	"strings"
-	"sync"
 	"sync/atomic"
 	"time"
 
@@ -12,17 +11,41 @@ import (
 	"go.mau.fi/util/exslices"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/bot"
+	"go.mau.fi/meowlnir/config"
+	"go.mau.fi/meowlnir/database"
+	"go.mau.fi/meowlnir/policylist"
 )
 
 func (pe *PolicyEvaluator) CompileACL() (*event.ServerACLEventContent, time.Duration) {
 	start := time.Now()
-	rules := pe.Store.ListServerRules(pe.GetWatchedListsForACLs())
+	lists := pe.GetWatchedListsForACLs()
+	modes := pe.GetWatchedListModes()
+	rules := pe.Store.ListServerRules(lists, modes, pe.GetWatchedListTrust())
 	acl := event.ServerACLEventContent{
 		Allow: []string{"*"},
 		Deny:  make([]string, 0, len(rules)),
 
 		AllowIPLiterals: false,
 	}
+	pe.heldListsLock.RLock()
+	for entity, policy := range rules {
+		if pe.isHeldPolicy(policy) {
+			delete(rules, entity)
+		}
+	}
+	// Policies removed from held lists stay in effect, unless a higher priority list has a policy for the same entity
+	for policy := range pe.heldRemovals(lists, policylist.EntityTypeServer) {
+		if policy.EntityHash != nil || !modes.Applies(policy) {
+			continue
+		}
+		existing, ok := rules[policy.Entity]
+		if !ok || slices.Index(lists, existing.RoomID) >= slices.Index(lists, policy.RoomID) {
+			rules[policy.Entity] = policy
+		}
+	}
+	pe.heldListsLock.RUnlock()
 	for entity, policy := range rules {
 		if policy.Pattern.Match(pe.Bot.ServerName) {
 			continue
@@ -44,6 +67,9 @@ func (pe *PolicyEvaluator) DeferredUpdateACL() {
 
 const aclDeferTime = 15 * time.Second
 
+// aclProgressThreshold is the number of rooms above which server ACL updates are reported with a progress message.
+const aclProgressThreshold = 25
+
 func (pe *PolicyEvaluator) aclDeferLoop() {
 	ctx := pe.Bot.Log.With().
 		Str("action", "deferred acl update").
@@ -89,42 +115,36 @@ func (pe *PolicyEvaluator) UpdateACL(ctx context.Context) {
 		Any("new_acl", newACL).
 		Dur("compile_duration", compileDur).
 		Msg("Sending updated server ACL event")
-	var wg sync.WaitGroup
-	wg.Add(len(changedRooms))
-	var successCount atomic.Int32
-	for roomID, oldACLDeny := range changedRooms {
-		go func(roomID id.RoomID, oldACLDeny []string) {
-			defer wg.Done()
-			removed, added := exslices.SortedDiff(oldACLDeny, newACL.Deny, strings.Compare)
-			if pe.DryRun {
-				log.Debug().
-					Stringer("room_id", roomID).
-					Strs("deny_added", added).
-					Strs("deny_removed", removed).
-					Msg("Dry run: would send server ACL to room")
-				successCount.Add(1)
-				return
-			}
-			resp, err := pe.Bot.SendStateEvent(ctx, roomID, event.StateServerACL, "", newACL)
-			if err != nil {
-				log.Err(err).
-					Strs("deny_added", added).
-					Strs("deny_removed", removed).
-					Stringer("room_id", roomID).
-					Msg("Failed to send server ACL to room")
-				pe.sendNotice(ctx, "Failed to send server ACL to room %s: %v", roomID, err)
-			} else {
-				log.Debug().
-					Stringer("room_id", roomID).
-					Stringer("event_id", resp.EventID).
-					Strs("deny_added", added).
-					Strs("deny_removed", removed).
-					Msg("Sent new server ACL to room")
-				successCount.Add(1)
-			}
-		}(roomID, oldACLDeny)
+	roomIDs := make([]id.RoomID, 0, len(changedRooms))
+	for roomID := range changedRooms {
+		roomIDs = append(roomIDs, roomID)
+	}
+	var progress *bot.Progress
+	if len(roomIDs) >= aclProgressThreshold {
+		noticeRoom, _ := pe.getNoticeRoute(config.NoticeCategoryActions)
+		progress = pe.Bot.StartProgress(ctx, noticeRoom, "Sending updated server ACL", len(roomIDs))
 	}
-	wg.Wait()
+	var successCount atomic.Int32
+	pe.roomActions.SubmitAndWait(roomIDs, func(roomID id.RoomID) {
+		removed, added := exslices.SortedDiff(changedRooms[roomID], newACL.Deny, strings.Compare)
+		log.Debug().
+			Stringer("room_id", roomID).
+			Strs("deny_added", added).
+			Strs("deny_removed", removed).
+			Msg("Sending server ACL to room")
+		err := pe.runAction(ctx, &database.QueuedAction{
+			ActionType: database.QueuedActionTypeServerACL,
+			RoomID:     roomID,
+			Payload:    database.QueuedActionPayload{ServerACL: newACL},
+		})
+		if err == nil {
+			successCount.Add(1)
+			progress.Add(ctx, 1, 0)
+		} else {
+			progress.Add(ctx, 0, 1)
+			progress.Detail(ctx, "Failed to send server ACL to [%s](%s): %v", roomID, roomID.URI().MatrixToURL(), err)
+		}
+	})
 	pe.protectedRoomsLock.Lock()
 	for roomID := range changedRooms {
 		pe.protectedRooms[roomID].ACL = newACL
@@ -134,5 +154,9 @@ func (pe *PolicyEvaluator) UpdateACL(ctx context.Context) {
 		Int("room_count", len(changedRooms)).
 		Int32("success_count", successCount.Load()).
 		Msg("Finished sending server ACL updates")
-	pe.sendNotice(ctx, "Successfully sent updated server ACL to %d/%d rooms", successCount.Load(), len(changedRooms))
+	if progress != nil {
+		progress.Finish(ctx, "sent to %d/%d rooms", successCount.Load(), len(changedRooms))
+	} else {
+		pe.sendCategoryNotice(ctx, config.NoticeCategoryActions, "Successfully sent updated server ACL to %d/%d rooms", successCount.Load(), len(changedRooms))
+	}
 }
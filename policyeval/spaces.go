@@ -0,0 +1,195 @@
+package policyeval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/config"
+)
+
+type resolvedSpace struct {
+	// Rooms are the non-space rooms found in the space hierarchy.
+	Rooms []id.RoomID
+	// Spaces are the space itself and all subspaces found in the hierarchy.
+	Spaces []id.RoomID
+}
+
+// IsProtectedSpace returns true if the given room is a protected space or a subspace of one.
+func (pe *PolicyEvaluator) IsProtectedSpace(roomID id.RoomID) bool {
+	pe.protectedRoomsLock.RLock()
+	defer pe.protectedRoomsLock.RUnlock()
+	for _, space := range pe.protectedSpaces {
+		if slices.Contains(space.Spaces, roomID) {
+			return true
+		}
+	}
+	return false
+}
+
+// spaceRefreshDelay is how long space child changes are collected before the affected spaces are walked again,
+// so that a burst of changes in a space tree only causes one walk.
+const spaceRefreshDelay = 10 * time.Second
+
+// HandleSpaceChild schedules re-resolving the protected rooms when a child is added to or removed from a protected space.
+func (pe *PolicyEvaluator) HandleSpaceChild(ctx context.Context, evt *event.Event) {
+	zerolog.Ctx(ctx).Debug().
+		Stringer("space_id", evt.RoomID).
+		Str("child_id", evt.GetStateKey()).
+		Msg("Space child changed, scheduling protected rooms update")
+	pe.changedSpacesLock.Lock()
+	defer pe.changedSpacesLock.Unlock()
+	if pe.changedSpaces == nil {
+		pe.changedSpaces = make(map[id.RoomID]struct{})
+		ctx = context.WithoutCancel(ctx)
+		time.AfterFunc(spaceRefreshDelay, func() {
+			pe.refreshChangedSpaces(ctx)
+		})
+	}
+	pe.changedSpaces[evt.RoomID] = struct{}{}
+}
+
+// refreshChangedSpaces walks the protected spaces whose hierarchy contains a space that changed
+// since the last refresh and updates the protected rooms accordingly.
+func (pe *PolicyEvaluator) refreshChangedSpaces(ctx context.Context) {
+	pe.changedSpacesLock.Lock()
+	changed := pe.changedSpaces
+	pe.changedSpaces = nil
+	pe.changedSpacesLock.Unlock()
+	pe.configLock.Lock()
+	defer pe.configLock.Unlock()
+	pe.protectedRoomsLock.RLock()
+	content := pe.protectedRoomsEvent
+	pe.protectedRoomsLock.RUnlock()
+	if content == nil || len(changed) == 0 {
+		return
+	}
+	output, errors := pe.applyProtectedRooms(ctx, content, false, changed)
+	if len(output) > 0 || len(errors) > 0 {
+		spaceLinks := make([]string, 0, len(changed))
+		for spaceID := range changed {
+			spaceLinks = append(spaceLinks, fmt.Sprintf("[%s](%s)", spaceID, spaceID.URI().MatrixToURL()))
+		}
+		slices.Sort(spaceLinks)
+		pe.sendCategoryNotice(
+			ctx, config.NoticeCategoryGeneral, "Protected space %s changed:\n\n%s",
+			strings.Join(spaceLinks, ", "), strings.Join(append(output, errors...), "\n"),
+		)
+	}
+}
+
+// resolveProtectedSpaces walks the hierarchies of protected spaces and joins the spaces and rooms in them.
+//
+// If changed is non-nil, only protected spaces whose hierarchy contains one of the changed spaces are walked,
+// and the previously resolved rooms are used for the rest.
+//
+// If walking a space fails, the previously resolved rooms of that space are used,
+// so that a temporary failure doesn't unprotect everything.
+func (pe *PolicyEvaluator) resolveProtectedSpaces(ctx context.Context, spaces []config.ProtectedSpace, changed map[id.RoomID]struct{}) (rooms []id.RoomID, errors []string) {
+	pe.protectedRoomsLock.RLock()
+	previous := pe.protectedSpaces
+	pe.protectedRoomsLock.RUnlock()
+	resolved := make(map[id.RoomID]*resolvedSpace, len(spaces))
+	joinedRooms, err := pe.Bot.JoinedRooms(ctx)
+	if err != nil {
+		return nil, []string{fmt.Sprintf("* Failed to get joined rooms: %v", err)}
+	}
+	for _, space := range spaces {
+		if prev, ok := previous[space.RoomID]; ok && changed != nil && !slices.ContainsFunc(prev.Spaces, func(spaceID id.RoomID) bool {
+			_, isChanged := changed[spaceID]
+			return isChanged
+		}) {
+			resolved[space.RoomID] = prev
+			rooms = append(rooms, prev.Rooms...)
+			continue
+		}
+		result, via, err := pe.walkSpace(ctx, space)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Stringer("space_id", space.RoomID).Msg("Failed to resolve protected space")
+			errors = append(errors, fmt.Sprintf("* Failed to get rooms in space [%s](%s): %v", space.RoomID, space.RoomID.URI().MatrixToURL(), err))
+			if prev, ok := previous[space.RoomID]; ok {
+				resolved[space.RoomID] = prev
+				rooms = append(rooms, prev.Rooms...)
+			}
+			continue
+		}
+		// Spaces are joined to receive m.space.child changes, rooms are joined so that they can be protected
+		for _, roomID := range slices.Concat(result.Spaces, result.Rooms) {
+			if roomID == pe.ManagementRoom || slices.Contains(joinedRooms.JoinedRooms, roomID) {
+				continue
+			}
+			_, err = pe.Bot.JoinRoom(ctx, roomID.String(), &mautrix.ReqJoinRoom{Via: via[roomID]})
+			if err != nil {
+				errors = append(errors, fmt.Sprintf("* Failed to join [%s](%s) in space [%s](%s): %v", roomID, roomID.URI().MatrixToURL(), space.RoomID, space.RoomID.URI().MatrixToURL(), err))
+			}
+		}
+		resolved[space.RoomID] = result
+		rooms = append(rooms, result.Rooms...)
+	}
+	pe.protectedRoomsLock.Lock()
+	pe.protectedSpaces = resolved
+	pe.protectedRoomsLock.Unlock()
+	return slices.DeleteFunc(rooms, func(roomID id.RoomID) bool {
+		return roomID == pe.ManagementRoom
+	}), errors
+}
+
+// walkSpace finds all rooms and subspaces in the given space, skipping excluded rooms and the contents of excluded subspaces.
+func (pe *PolicyEvaluator) walkSpace(ctx context.Context, space config.ProtectedSpace) (*resolvedSpace, map[id.RoomID][]string, error) {
+	chunks := make(map[id.RoomID]*mautrix.ChildRoomsChunk)
+	req := &mautrix.ReqHierarchy{}
+	for {
+		resp, err := pe.Bot.Hierarchy(ctx, space.RoomID, req)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get space hierarchy: %w", err)
+		}
+		for _, chunk := range resp.Rooms {
+			chunks[chunk.RoomID] = chunk
+		}
+		if resp.NextBatch == "" {
+			break
+		}
+		req.From = resp.NextBatch
+	}
+	result := &resolvedSpace{Spaces: []id.RoomID{space.RoomID}}
+	via := make(map[id.RoomID][]string)
+	visited := map[id.RoomID]struct{}{space.RoomID: {}}
+	queue := []id.RoomID{space.RoomID}
+	for len(queue) > 0 {
+		chunk, ok := chunks[queue[0]]
+		queue = queue[1:]
+		if !ok {
+			continue
+		}
+		for _, child := range chunk.ChildrenState {
+			childID := id.RoomID(child.StateKey)
+			if child.Type != event.StateSpaceChild || slices.Contains(space.Exclude, childID) {
+				continue
+			} else if _, alreadyVisited := visited[childID]; alreadyVisited {
+				continue
+			}
+			var content event.SpaceChildEventContent
+			// Children without a via list have been removed from the space
+			if err := json.Unmarshal(child.Content.VeryRaw, &content); err != nil || len(content.Via) == 0 {
+				continue
+			}
+			visited[childID] = struct{}{}
+			via[childID] = content.Via
+			if childChunk, ok := chunks[childID]; ok && childChunk.RoomType == event.RoomTypeSpace {
+				result.Spaces = append(result.Spaces, childID)
+				queue = append(queue, childID)
+			} else {
+				result.Rooms = append(result.Rooms, childID)
+			}
+		}
+	}
+	return result, via, nil
+}
@@ -0,0 +1,315 @@
+package policyeval
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/config"
+	"go.mau.fi/meowlnir/policylist"
+)
+
+// HandleProtectedRoomTombstone moves protection from an upgraded protected room to its replacement room.
+func (pe *PolicyEvaluator) HandleProtectedRoomTombstone(ctx context.Context, evt *event.Event, content *event.TombstoneEventContent) {
+	log := zerolog.Ctx(ctx).With().
+		Stringer("old_room_id", evt.RoomID).
+		Stringer("new_room_id", content.ReplacementRoom).
+		Logger()
+	_, err := pe.Bot.JoinRoom(ctx, content.ReplacementRoom.String(), &mautrix.ReqJoinRoom{Via: []string{evt.Sender.Homeserver()}})
+	if err != nil {
+		log.Err(err).Msg("Failed to join replacement of protected room")
+		pe.sendCategoryNotice(
+			ctx, config.NoticeCategoryAlerts,
+			"Protected room [%s](%s) was upgraded to [%s](%s), but joining the new room failed: %v",
+			evt.RoomID, evt.RoomID.URI().MatrixToURL(), content.ReplacementRoom, content.ReplacementRoom.URI().MatrixToURL(), err,
+		)
+		return
+	}
+	pe.protectedRoomsLock.RLock()
+	var contentCopy config.ProtectedRoomsEventContent
+	if pe.protectedRoomsEvent != nil {
+		contentCopy = *pe.protectedRoomsEvent
+	}
+	contentCopy.Rooms = slices.Clone(contentCopy.Rooms)
+	contentCopy.SkipACL = slices.Clone(contentCopy.SkipACL)
+	pe.protectedRoomsLock.RUnlock()
+	if !slices.Contains(contentCopy.Rooms, evt.RoomID) {
+		// The room is protected through a space, so the protected rooms event doesn't need to change.
+		// The new room will be protected once it's added to the space.
+		log.Info().Msg("Upgraded room is protected through a space, not updating protected rooms")
+		pe.sendCategoryNotice(
+			ctx, config.NoticeCategoryGeneral, "Protected room [%s](%s) was upgraded by %s to [%s](%s). "+
+				"The old room is protected through a space, so the new room will be protected once it's added to the space.",
+			evt.RoomID, evt.RoomID.URI().MatrixToURL(), format.SafeMarkdownCode(evt.Sender),
+			content.ReplacementRoom, content.ReplacementRoom.URI().MatrixToURL(),
+		)
+		return
+	}
+	contentCopy.Rooms = replaceRoomID(contentCopy.Rooms, evt.RoomID, content.ReplacementRoom)
+	contentCopy.SkipACL = replaceRoomID(contentCopy.SkipACL, evt.RoomID, content.ReplacementRoom)
+	_, err = pe.Bot.SendStateEvent(ctx, pe.ManagementRoom, config.StateProtectedRooms, "", &contentCopy)
+	if err != nil {
+		log.Err(err).Msg("Failed to update protected rooms after room upgrade")
+		pe.sendCategoryNotice(
+			ctx, config.NoticeCategoryAlerts,
+			"Protected room [%s](%s) was upgraded to [%s](%s), but updating the protected rooms list failed: %v",
+			evt.RoomID, evt.RoomID.URI().MatrixToURL(), content.ReplacementRoom, content.ReplacementRoom.URI().MatrixToURL(), err,
+		)
+		return
+	}
+	log.Info().Msg("Moved protection to upgraded room")
+	pe.sendCategoryNotice(
+		ctx, config.NoticeCategoryGeneral, "Protected room [%s](%s) was upgraded by %s, now protecting [%s](%s) instead",
+		evt.RoomID, evt.RoomID.URI().MatrixToURL(), format.SafeMarkdownCode(evt.Sender),
+		content.ReplacementRoom, content.ReplacementRoom.URI().MatrixToURL(),
+	)
+}
+
+// pendingListUpgrade is an upgraded policy list whose replacement room doesn't have all the policies of the old room yet.
+// The old room stays watched until the policies are copied, so that upgrading a list doesn't unban everyone.
+type pendingListUpgrade struct {
+	oldRoom id.RoomID
+	sender  id.UserID
+	missing map[policyKey]struct{}
+}
+
+type policyKey struct {
+	entityType     policylist.EntityType
+	entity         string
+	recommendation event.PolicyRecommendation
+}
+
+func getPolicyKey(policy *policylist.Policy) policyKey {
+	return policyKey{entityType: policy.EntityType, entity: policy.EntityOrHash(), recommendation: policy.Recommendation}
+}
+
+// missingPolicies finds the policies in the old room which don't have an equivalent policy in the new room.
+func missingPolicies(oldRoom, newRoom *policylist.Room) (missing []*policylist.Policy) {
+	existing := make(map[policyKey]struct{})
+	for _, policy := range newRoom.Policies() {
+		existing[getPolicyKey(policy)] = struct{}{}
+	}
+	for _, policy := range oldRoom.Policies() {
+		if _, ok := existing[getPolicyKey(policy)]; !ok {
+			missing = append(missing, policy)
+		}
+	}
+	return
+}
+
+// canSendPolicies checks whether the bot has the power level to send all the given policies in the given room.
+func (pe *PolicyEvaluator) canSendPolicies(room *policylist.Room, policies []*policylist.Policy) bool {
+	pl := room.PowerLevels()
+	if pl == nil {
+		return false
+	}
+	ownLevel := pl.GetUserLevel(pe.Bot.UserID)
+	for _, policy := range policies {
+		if ownLevel < pl.GetEventLevel(policy.Type) {
+			return false
+		}
+	}
+	return true
+}
+
+// HandlePolicyListTombstone moves the subscription of an upgraded policy list to its replacement room.
+// All settings of the watched list, like the shortcode and flags, are carried over to the new room.
+//
+// If the new room doesn't have all the policies of the old room, the bot copies them if it has permission to.
+// The subscription is only moved once all policies are in the new room.
+func (pe *PolicyEvaluator) HandlePolicyListTombstone(ctx context.Context, evt *event.Event, content *event.TombstoneEventContent) {
+	log := zerolog.Ctx(ctx).With().
+		Stringer("old_room_id", evt.RoomID).
+		Stringer("new_room_id", content.ReplacementRoom).
+		Logger()
+	err := pe.joinPolicyList(ctx, content.ReplacementRoom, content.ReplacementRoom.String(), evt.Sender.Homeserver())
+	if err != nil {
+		log.Err(err).Msg("Failed to join replacement of policy list")
+		pe.sendCategoryNotice(
+			ctx, config.NoticeCategoryAlerts,
+			"Policy list [%s](%s) was upgraded to [%s](%s), but joining the new room failed: %v",
+			evt.RoomID, evt.RoomID.URI().MatrixToURL(), content.ReplacementRoom, content.ReplacementRoom.URI().MatrixToURL(), err,
+		)
+		return
+	}
+	if !pe.Store.Contains(content.ReplacementRoom) {
+		state, err := pe.Bot.State(ctx, content.ReplacementRoom)
+		if err != nil {
+			log.Err(err).Msg("Failed to get state of replacement policy list")
+			pe.sendCategoryNotice(
+				ctx, config.NoticeCategoryAlerts,
+				"Policy list [%s](%s) was upgraded to [%s](%s), but getting the state of the new room failed: %v. "+
+					"Still watching the old room.",
+				evt.RoomID, evt.RoomID.URI().MatrixToURL(), content.ReplacementRoom, content.ReplacementRoom.URI().MatrixToURL(), err,
+			)
+			return
+		}
+		pe.Store.Add(content.ReplacementRoom, state)
+	}
+	oldRoom, newRoom := pe.Store.GetRoom(evt.RoomID), pe.Store.GetRoom(content.ReplacementRoom)
+	var missing []*policylist.Policy
+	if oldRoom != nil {
+		missing = missingPolicies(oldRoom, newRoom)
+	}
+	if len(missing) == 0 {
+		pe.moveWatchedList(ctx, evt.RoomID, content.ReplacementRoom, evt.Sender)
+		return
+	}
+	upgrade := &pendingListUpgrade{
+		oldRoom: evt.RoomID,
+		sender:  evt.Sender,
+		missing: make(map[policyKey]struct{}, len(missing)),
+	}
+	for _, policy := range missing {
+		upgrade.missing[getPolicyKey(policy)] = struct{}{}
+	}
+	// The subscription is moved when the missing policies show up in the new room, whether they're copied by us or someone else
+	pe.pendingListUpgradesLock.Lock()
+	pe.pendingListUpgrades[content.ReplacementRoom] = upgrade
+	pe.pendingListUpgradesLock.Unlock()
+	if !pe.canSendPolicies(newRoom, missing) {
+		log.Warn().Int("missing_policies", len(missing)).Msg("Replacement policy list is missing policies and bot can't copy them")
+		pe.sendCategoryNotice(
+			ctx, config.NoticeCategoryAlerts,
+			"Policy list [%s](%s) was upgraded to [%s](%s), but %d policies haven't been copied to the new room "+
+				"and the bot doesn't have permission to copy them. Still watching the old room until they're copied.",
+			evt.RoomID, evt.RoomID.URI().MatrixToURL(), content.ReplacementRoom, content.ReplacementRoom.URI().MatrixToURL(), len(missing),
+		)
+		return
+	}
+	log.Info().Int("missing_policies", len(missing)).Msg("Copying policies to replacement policy list")
+	for _, policy := range missing {
+		_, err = pe.Bot.SendStateEvent(ctx, content.ReplacementRoom, policy.Type, policy.StateKey, policy.ModPolicyContent)
+		if err != nil {
+			log.Err(err).Str("state_key", policy.StateKey).Msg("Failed to copy policy to replacement policy list")
+			pe.sendCategoryNotice(
+				ctx, config.NoticeCategoryAlerts,
+				"Policy list [%s](%s) was upgraded to [%s](%s), but copying policies to the new room failed: %v. "+
+					"Still watching the old room until all policies are copied.",
+				evt.RoomID, evt.RoomID.URI().MatrixToURL(), content.ReplacementRoom, content.ReplacementRoom.URI().MatrixToURL(), err,
+			)
+			return
+		}
+	}
+}
+
+// resumeListUpgrades finds watched lists which have been upgraded and handles their tombstones again.
+// Pending upgrades are only kept in memory, so this is called on startup to continue any upgrade
+// that was waiting for policies to be copied when the bot was stopped.
+func (pe *PolicyEvaluator) resumeListUpgrades(ctx context.Context) {
+	for _, roomID := range pe.GetWatchedLists() {
+		evt, err := pe.Bot.FullStateEvent(ctx, roomID, event.StateTombstone, "")
+		if errors.Is(err, mautrix.MNotFound) {
+			continue
+		} else if err != nil {
+			zerolog.Ctx(ctx).Err(err).Stringer("room_id", roomID).Msg("Failed to get tombstone of watched list")
+			continue
+		}
+		content, ok := evt.Content.Parsed.(*event.TombstoneEventContent)
+		if !ok || content.ReplacementRoom == "" || !pe.IsWatchingList(roomID) {
+			continue
+		}
+		zerolog.Ctx(ctx).Info().
+			Stringer("old_room_id", roomID).
+			Stringer("new_room_id", content.ReplacementRoom).
+			Msg("Resuming upgrade of watched list")
+		pe.HandlePolicyListTombstone(ctx, evt, content)
+	}
+}
+
+// checkPendingListUpgrade marks policies in the replacement room of an upgraded policy list as copied
+// and moves the subscription to the new room once there are no more missing policies.
+func (pe *PolicyEvaluator) checkPendingListUpgrade(ctx context.Context, policyRoom id.RoomID, added, removed *policylist.Policy) {
+	pe.pendingListUpgradesLock.Lock()
+	var newRoomID id.RoomID
+	var upgrade *pendingListUpgrade
+	if added != nil {
+		if upgrade = pe.pendingListUpgrades[policyRoom]; upgrade != nil {
+			newRoomID = policyRoom
+			delete(upgrade.missing, getPolicyKey(added))
+		}
+	}
+	if upgrade == nil && removed != nil {
+		// Policies that are removed from the old room don't need to be copied anymore
+		for roomID, pending := range pe.pendingListUpgrades {
+			if pending.oldRoom == policyRoom {
+				newRoomID, upgrade = roomID, pending
+				delete(upgrade.missing, getPolicyKey(removed))
+				break
+			}
+		}
+	}
+	if upgrade == nil || len(upgrade.missing) > 0 {
+		pe.pendingListUpgradesLock.Unlock()
+		return
+	}
+	delete(pe.pendingListUpgrades, newRoomID)
+	pe.pendingListUpgradesLock.Unlock()
+	if pe.IsWatchingList(upgrade.oldRoom) {
+		pe.moveWatchedList(ctx, upgrade.oldRoom, newRoomID, upgrade.sender)
+	}
+}
+
+// moveWatchedList replaces the old room with the new room in the watched lists event.
+func (pe *PolicyEvaluator) moveWatchedList(ctx context.Context, oldRoomID, newRoomID id.RoomID, sender id.UserID) {
+	log := zerolog.Ctx(ctx).With().
+		Stringer("old_room_id", oldRoomID).
+		Stringer("new_room_id", newRoomID).
+		Logger()
+	watchedLists := pe.cloneWatchedListsEvent()
+	alreadyWatched := slices.ContainsFunc(watchedLists.Lists, func(item config.WatchedPolicyList) bool {
+		return item.RoomID == newRoomID
+	})
+	var name string
+	for i, list := range watchedLists.Lists {
+		if list.RoomID == oldRoomID {
+			name = list.Name
+			watchedLists.Lists[i].RoomID = newRoomID
+		}
+	}
+	if alreadyWatched {
+		// The new room is already watched separately, so just drop the old room instead of creating a duplicate
+		watchedLists = pe.cloneWatchedListsEvent()
+		watchedLists.Lists = slices.DeleteFunc(watchedLists.Lists, func(item config.WatchedPolicyList) bool {
+			return item.RoomID == oldRoomID
+		})
+	}
+	_, err := pe.Bot.SendStateEvent(ctx, pe.ManagementRoom, config.StateWatchedLists, "", watchedLists)
+	if err != nil {
+		log.Err(err).Msg("Failed to update watched lists after room upgrade")
+		pe.sendCategoryNotice(
+			ctx, config.NoticeCategoryAlerts,
+			"Policy list [%s](%s) was upgraded to [%s](%s), but updating the watched lists failed: %v",
+			oldRoomID, oldRoomID.URI().MatrixToURL(), newRoomID, newRoomID.URI().MatrixToURL(), err,
+		)
+		return
+	}
+	log.Info().Msg("Moved policy list subscription to upgraded room")
+	pe.sendCategoryNotice(
+		ctx, config.NoticeCategoryPolicies, "Policy list [%s](%s) was upgraded by %s, now watching [%s](%s) instead",
+		format.EscapeMarkdown(name), oldRoomID.URI().MatrixToURL(), format.SafeMarkdownCode(sender),
+		newRoomID, newRoomID.URI().MatrixToURL(),
+	)
+}
+
+// replaceRoomID replaces the old room ID with the new one in the given list, unless the new one is already there,
+// in which case the old one is just removed.
+func replaceRoomID[T ~string](list []T, oldID, newID T) []T {
+	if slices.Contains(list, newID) {
+		return slices.DeleteFunc(list, func(item T) bool {
+			return item == oldID
+		})
+	}
+	for i, item := range list {
+		if item == oldID {
+			list[i] = newID
+		}
+	}
+	return list
+}
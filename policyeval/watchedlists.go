@@ -1,6 +1,7 @@
 package policyeval
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"maps"
@@ -10,10 +11,12 @@ import (
 
 	"github.com/rs/zerolog"
 	"go.mau.fi/util/exslices"
+	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
 	"go.mau.fi/meowlnir/config"
+	"go.mau.fi/meowlnir/policylist"
 )
 
 func (pe *PolicyEvaluator) IsWatchingList(roomID id.RoomID) bool {
@@ -42,6 +45,55 @@ func (pe *PolicyEvaluator) FindListByShortcode(shortcode string) *config.Watched
 	return nil
 }
 
+// cloneWatchedListsEvent returns a copy of the current watched lists event which can be modified and sent back
+// to the management room.
+func (pe *PolicyEvaluator) cloneWatchedListsEvent() *config.WatchedListsEventContent {
+	pe.watchedListsLock.RLock()
+	defer pe.watchedListsLock.RUnlock()
+	if pe.watchedListsEvent == nil {
+		return &config.WatchedListsEventContent{}
+	}
+	contentCopy := *pe.watchedListsEvent
+	contentCopy.Lists = slices.Clone(contentCopy.Lists)
+	return &contentCopy
+}
+
+// WatchedListFlags are the boolean options of watched lists which can be toggled with `!lists set`.
+var WatchedListFlags = []string{"dont_apply", "dont_apply_acl", "auto_unban", "auto_suspend", "dont_notify_on_change"}
+
+func getWatchedListFlag(list *config.WatchedPolicyList, flag string) *bool {
+	switch flag {
+	case "dont_apply":
+		return &list.DontApply
+	case "dont_apply_acl":
+		return &list.DontApplyACL
+	case "auto_unban":
+		return &list.AutoUnban
+	case "auto_suspend":
+		return &list.AutoSuspend
+	case "dont_notify_on_change":
+		return &list.DontNotifyOnChange
+	default:
+		return nil
+	}
+}
+
+// joinPolicyList joins the given policy list room if the bot isn't already in it.
+// The join target can be an alias, which allows joining rooms over federation without knowing a server name.
+func (pe *PolicyEvaluator) joinPolicyList(ctx context.Context, roomID id.RoomID, joinTarget string, via ...string) error {
+	joinedRooms, err := pe.Bot.JoinedRooms(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get joined rooms: %w", err)
+	} else if slices.Contains(joinedRooms.JoinedRooms, roomID) {
+		return nil
+	}
+	_, err = pe.Bot.JoinRoom(ctx, joinTarget, &mautrix.ReqJoinRoom{Via: via})
+	if err != nil {
+		return fmt.Errorf("failed to join room: %w", err)
+	}
+	return nil
+}
+
 func (pe *PolicyEvaluator) GetWatchedLists() []id.RoomID {
 	pe.watchedListsLock.RLock()
 	defer pe.watchedListsLock.RUnlock()
@@ -54,6 +106,31 @@ func (pe *PolicyEvaluator) GetWatchedListsForACLs() []id.RoomID {
 	return pe.watchedListsForACLs
 }
 
+func (pe *PolicyEvaluator) GetWatchedListModes() policylist.ListModes {
+	pe.watchedListsLock.RLock()
+	defer pe.watchedListsLock.RUnlock()
+	return pe.watchedListModes
+}
+
+func (pe *PolicyEvaluator) GetWatchedListTrust() policylist.ListTrust {
+	pe.watchedListsLock.RLock()
+	defer pe.watchedListsLock.RUnlock()
+	return pe.watchedListTrust
+}
+
+// isTrusted checks whether the sender of the given policy is trusted by the settings of the watched list it's in.
+func (pe *PolicyEvaluator) isTrusted(policy *policylist.Policy) bool {
+	return pe.Store.Trusts(pe.GetWatchedListTrust(), policy)
+}
+
+// recommendations returns the effective recommendation in the given match
+// using the merge modes of the watched lists. Policies held by the change rate limit
+// and policies from senders that aren't trusted by the watched list settings are ignored.
+func (pe *PolicyEvaluator) recommendations(match policylist.Match) policylist.Recommendations {
+	trusted := pe.Store.FilterTrusted(pe.GetWatchedListTrust(), match)
+	return pe.filterHeldPolicies(trusted).RecommendationsWithModes(pe.GetWatchedListModes())
+}
+
 func (pe *PolicyEvaluator) handleWatchedLists(ctx context.Context, evt *event.Event, isInitial bool) (output, errors []string) {
 	content, ok := evt.Content.Parsed.(*config.WatchedListsEventContent)
 	if !ok {
@@ -82,10 +159,26 @@ func (pe *PolicyEvaluator) handleWatchedLists(ctx context.Context, evt *event.Ev
 	watchedList := make([]id.RoomID, 0, len(content.Lists))
 	aclWatchedList := make([]id.RoomID, 0, len(content.Lists))
 	watchedMap := make(map[id.RoomID]*config.WatchedPolicyList, len(content.Lists))
-	for _, listInfo := range content.Lists {
+	listModes := make(policylist.ListModes)
+	listTrust := make(policylist.ListTrust)
+	sortedLists := slices.Clone(content.Lists)
+	slices.SortStableFunc(sortedLists, func(a, b config.WatchedPolicyList) int {
+		return cmp.Compare(b.Priority, a.Priority)
+	})
+	for _, listInfo := range sortedLists {
 		if _, alreadyWatched := watchedMap[listInfo.RoomID]; alreadyWatched {
 			errors = append(errors, fmt.Sprintf("* Duplicate watched list [%s](%s)", listInfo.Name, listInfo.RoomID.URI().MatrixToURL()))
 		} else {
+			if !listInfo.MergeMode.IsValid() {
+				errors = append(errors, fmt.Sprintf("* Unknown merge mode `%s` for [%s](%s), treating it as authoritative", listInfo.MergeMode, listInfo.Name, listInfo.RoomID.URI().MatrixToURL()))
+				listInfo.MergeMode = policylist.MergeModeAuthoritative
+			}
+			if listInfo.MergeMode != "" && listInfo.MergeMode != policylist.MergeModeAuthoritative {
+				listModes[listInfo.RoomID] = listInfo.MergeMode
+			}
+			if trust := listInfo.SenderTrust(); trust != nil {
+				listTrust[listInfo.RoomID] = trust
+			}
 			watchedMap[listInfo.RoomID] = &listInfo
 			if !listInfo.DontApply {
 				watchedList = append(watchedList, listInfo.RoomID)
@@ -99,11 +192,27 @@ func (pe *PolicyEvaluator) handleWatchedLists(ctx context.Context, evt *event.Ev
 	oldWatchedList := pe.watchedListsList
 	oldACLWatchedList := pe.watchedListsForACLs
 	oldFullWatchedList := slices.Collect(maps.Keys(pe.watchedListsMap))
+	oldListModes := pe.watchedListModes
+	oldListTrust := pe.watchedListTrust
 	pe.watchedListsMap = watchedMap
 	pe.watchedListsList = watchedList
 	pe.watchedListsForACLs = aclWatchedList
+	pe.watchedListModes = listModes
+	pe.watchedListTrust = listTrust
 	pe.watchedListsEvent = content
 	pe.watchedListsLock.Unlock()
+	var trustChanges []policylist.TrustChange
+	for roomID := range watchedMap {
+		oldTrust, newTrust := oldListTrust[roomID], listTrust[roomID]
+		// Newly subscribed lists are evaluated fully anyway, so only lists that were already watched matter here
+		if !slices.Contains(oldFullWatchedList, roomID) || oldTrust.Equal(newTrust) {
+			continue
+		}
+		if room := pe.Store.GetRoom(roomID); room != nil {
+			pl := room.PowerLevels()
+			trustChanges = append(trustChanges, room.TrustChanges(oldTrust, newTrust, pl, pl)...)
+		}
+	}
 	if !isInitial {
 		unsubscribed, subscribed := exslices.Diff(oldWatchedList, watchedList)
 		noApplyUnsubscribed, noApplySubscribed := exslices.Diff(oldFullWatchedList, slices.Collect(maps.Keys(pe.watchedListsMap)))
@@ -115,6 +224,7 @@ func (pe *PolicyEvaluator) handleWatchedLists(ctx context.Context, evt *event.Ev
 				output = append(output, fmt.Sprintf("* Subscribed to %s [%s](%s) without applying policies", pe.GetWatchedListMeta(roomID).Name, roomID, roomID.URI().MatrixToURL()))
 			}
 		}
+		pe.dropHeldLists(ctx, noApplyUnsubscribed)
 		for _, roomID := range unsubscribed {
 			output = append(output, fmt.Sprintf("* Unsubscribed from [%s](%s)", roomID, roomID.URI().MatrixToURL()))
 		}
@@ -134,11 +244,25 @@ func (pe *PolicyEvaluator) handleWatchedLists(ctx context.Context, evt *event.Ev
 				output = append(output, fmt.Sprintf("* Unsubscribed from server ACLs in %s [%s](%s)", pe.GetWatchedListMeta(roomID).Name, roomID, roomID.URI().MatrixToURL()))
 			}
 		}
+		// Changing priorities or merge modes doesn't subscribe or unsubscribe anything,
+		// but it can still change which policies win, so everything needs to be re-evaluated.
+		precedenceChanged := !maps.Equal(oldListModes, listModes) ||
+			(len(subscribed) == 0 && len(unsubscribed) == 0 && !slices.Equal(oldWatchedList, watchedList))
+		if precedenceChanged {
+			output = append(output, "* List priorities or merge modes changed, re-evaluating all policies")
+		}
+		if len(trustChanges) > 0 {
+			output = append(output, fmt.Sprintf("* Sender trust settings changed: %s", summarizeTrustChanges(trustChanges)))
+		}
 		go func(ctx context.Context) {
+			pe.applyTrustChanges(ctx, trustChanges)
 			if len(unsubscribed) > 0 {
 				pe.ReevaluateAffectedByLists(ctx, unsubscribed)
 			}
-			if len(subscribed) > 0 || len(unsubscribed) > 0 {
+			if precedenceChanged {
+				pe.ReevaluateAffectedByLists(ctx, watchedList)
+			}
+			if len(subscribed) > 0 || len(unsubscribed) > 0 || precedenceChanged {
 				pe.EvaluateAll(ctx)
 			}
 			if len(aclSubscribed) > 0 || len(aclUnsubscribed) > 0 {
@@ -148,3 +272,57 @@ func (pe *PolicyEvaluator) handleWatchedLists(ctx context.Context, evt *event.Ev
 	}
 	return
 }
+
+// explainMatch sorts the given match into list precedence order and finds the policy that takes effect.
+// The returned reasons explain why each policy did or didn't win.
+func (pe *PolicyEvaluator) explainMatch(match policylist.Match) (sorted policylist.Match, winner *policylist.Policy, reasons map[*policylist.Policy]string) {
+	pe.watchedListsLock.RLock()
+	watchedLists := pe.watchedListsList
+	modes := pe.watchedListModes
+	trust := pe.watchedListTrust
+	pe.watchedListsLock.RUnlock()
+	listIndex := func(policy *policylist.Policy) int {
+		idx := slices.Index(watchedLists, policy.RoomID)
+		if idx == -1 {
+			return len(watchedLists)
+		}
+		return idx
+	}
+	sorted = slices.Clone(match)
+	slices.SortStableFunc(sorted, func(a, b *policylist.Policy) int {
+		return cmp.Compare(listIndex(a), listIndex(b))
+	})
+	trusted := pe.Store.FilterTrusted(trust, sorted)
+	applied := slices.DeleteFunc(pe.filterHeldPolicies(trusted), func(policy *policylist.Policy) bool {
+		return listIndex(policy) == len(watchedLists)
+	})
+	winner = applied.RecommendationsWithModes(modes).BanOrUnban
+	reasons = make(map[*policylist.Policy]string, len(sorted))
+	for _, policy := range sorted {
+		switch {
+		case policy == winner:
+			if modes.IsAdvisory(policy) {
+				reasons[policy] = "wins: advisory list, but no other list has an applicable policy"
+			} else {
+				reasons[policy] = "wins: first applicable policy in list priority order"
+			}
+		case listIndex(policy) == len(watchedLists):
+			reasons[policy] = "ignored: list is not applied"
+		case !slices.Contains(trusted, policy):
+			reasons[policy] = "ignored: sender isn't trusted"
+		case !slices.Contains(applied, policy):
+			reasons[policy] = "ignored: list is held"
+		case !modes.Applies(policy):
+			reasons[policy] = "ignored: list is unban-only"
+		case policy.Recommendation != event.PolicyRecommendationBan &&
+			policy.Recommendation != event.PolicyRecommendationUnban &&
+			policy.Recommendation != event.PolicyRecommendationUnstableTakedown:
+			reasons[policy] = "ignored: recommendation is not a ban or unban"
+		case modes.IsAdvisory(policy) && winner != nil && !modes.IsAdvisory(winner):
+			reasons[policy] = "lost: advisory list overridden by a non-advisory list"
+		default:
+			reasons[policy] = "lost: lower list priority"
+		}
+	}
+	return
+}
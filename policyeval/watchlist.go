@@ -0,0 +1,106 @@
+package policyeval
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/config"
+	"go.mau.fi/meowlnir/policylist"
+)
+
+type watchedUserRoom struct {
+	userID id.UserID
+	roomID id.RoomID
+}
+
+// getWatchPolicy returns the watch policy matching the given user, or nil if the user isn't being watched.
+func (pe *PolicyEvaluator) getWatchPolicy(userID id.UserID) *policylist.Policy {
+	match := pe.matchUser(pe.GetWatchedLists(), userID)
+	if match == nil {
+		return nil
+	}
+	return pe.recommendations(match).Watch
+}
+
+func (pe *PolicyEvaluator) sendWatchlistAlert(ctx context.Context, policy *policylist.Policy, userID id.UserID, message string, args ...any) {
+	message = fmt.Sprintf(message, args...)
+	zerolog.Ctx(ctx).Debug().
+		Stringer("user_id", userID).
+		Stringer("policy_list", policy.RoomID).
+		Str("policy_entity", policy.EntityOrHash()).
+		Str("activity", message).
+		Msg("Watched user activity")
+	listName := policy.RoomID.String()
+	if meta := pe.GetWatchedListMeta(policy.RoomID); meta != nil {
+		listName = meta.Name
+	}
+	reason := ""
+	if policy.Reason != "" {
+		reason = ": " + format.EscapeMarkdown(policy.Reason)
+	}
+	pe.sendCategoryNotice(
+		ctx, config.NoticeCategoryWatchlist, "👀 Watched user [%s](%s) %s (watched by [%s](%s)%s)",
+		userID, userID.URI().MatrixToURL(), message, format.EscapeMarkdown(listName), policy.RoomID.URI().MatrixToURL(), reason,
+	)
+}
+
+// checkWatchlistMembership alerts the management room when a watched user joins or is invited to a protected room.
+func (pe *PolicyEvaluator) checkWatchlistMembership(ctx context.Context, evt *event.Event, userID id.UserID, membership event.Membership) {
+	if membership != event.MembershipJoin && membership != event.MembershipInvite {
+		return
+	}
+	prevMembership := event.MembershipLeave
+	if evt.Unsigned.PrevContent != nil {
+		_ = evt.Unsigned.PrevContent.ParseRaw(evt.Type)
+		prevMembership = evt.Unsigned.PrevContent.AsMember().Membership
+	}
+	if prevMembership == membership {
+		// Profile changes
+		return
+	}
+	policy := pe.getWatchPolicy(userID)
+	if policy == nil {
+		return
+	}
+	roomLink := fmt.Sprintf("[%s](%s)", pe.getRoomName(evt.RoomID), evt.RoomID.URI().MatrixToURL())
+	if membership == event.MembershipJoin {
+		// Forget previous messages so that the first message after rejoining is reported again
+		pe.watchedMessagesLock.Lock()
+		delete(pe.watchedMessagesSeen, watchedUserRoom{userID, evt.RoomID})
+		pe.watchedMessagesLock.Unlock()
+		pe.sendWatchlistAlert(ctx, policy, userID, "joined %s", roomLink)
+	} else {
+		pe.sendWatchlistAlert(ctx, policy, userID, "was invited to %s by [%s](%s)", roomLink, evt.Sender, evt.Sender.URI().MatrixToURL())
+	}
+}
+
+// checkWatchlistMessage alerts the management room when a watched user sends their first message in a protected room.
+func (pe *PolicyEvaluator) checkWatchlistMessage(ctx context.Context, evt *event.Event) {
+	key := watchedUserRoom{evt.Sender, evt.RoomID}
+	pe.watchedMessagesLock.Lock()
+	_, seen := pe.watchedMessagesSeen[key]
+	pe.watchedMessagesLock.Unlock()
+	if seen {
+		return
+	}
+	policy := pe.getWatchPolicy(evt.Sender)
+	if policy == nil {
+		return
+	}
+	pe.watchedMessagesLock.Lock()
+	_, seen = pe.watchedMessagesSeen[key]
+	pe.watchedMessagesSeen[key] = struct{}{}
+	pe.watchedMessagesLock.Unlock()
+	if seen {
+		return
+	}
+	pe.sendWatchlistAlert(
+		ctx, policy, evt.Sender, "sent their [first message](%s) in [%s](%s)",
+		evt.RoomID.EventURI(evt.ID).MatrixToURL(), pe.getRoomName(evt.RoomID), evt.RoomID.URI().MatrixToURL(),
+	)
+}
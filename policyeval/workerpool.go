@@ -0,0 +1,60 @@
+package policyeval
+
+import (
+	"hash/maphash"
+	"sync"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	defaultEvaluationConcurrency = 8
+	defaultActionConcurrency     = 4
+)
+
+// roomActionPool executes actions with bounded concurrency.
+//
+// Each room is assigned to a single lane based on the hash of the room ID, and each lane executes its actions
+// one by one, which guarantees that actions in the same room are executed in the order they were submitted.
+type roomActionPool struct {
+	seed  maphash.Seed
+	lanes []chan func()
+}
+
+func newRoomActionPool(concurrency int) *roomActionPool {
+	pool := &roomActionPool{
+		seed:  maphash.MakeSeed(),
+		lanes: make([]chan func(), concurrency),
+	}
+	for i := range pool.lanes {
+		pool.lanes[i] = make(chan func(), 128)
+		go pool.runLane(pool.lanes[i])
+	}
+	return pool
+}
+
+func (pool *roomActionPool) runLane(lane <-chan func()) {
+	for fn := range lane {
+		fn()
+	}
+}
+
+// Submit queues the given function to be executed in the lane of the given room.
+// It only blocks if the lane's queue is full.
+func (pool *roomActionPool) Submit(roomID id.RoomID, fn func()) {
+	lane := maphash.String(pool.seed, string(roomID)) % uint64(len(pool.lanes))
+	pool.lanes[lane] <- fn
+}
+
+// SubmitAndWait queues the given function for each room and waits for all of them to finish.
+func (pool *roomActionPool) SubmitAndWait(rooms []id.RoomID, fn func(roomID id.RoomID)) {
+	var wg sync.WaitGroup
+	wg.Add(len(rooms))
+	for _, roomID := range rooms {
+		pool.Submit(roomID, func() {
+			defer wg.Done()
+			fn(roomID)
+		})
+	}
+	wg.Wait()
+}
@@ -0,0 +1,128 @@
+package policylist
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"iter"
+	"maps"
+
+	"go.mau.fi/meowlnir/util"
+)
+
+const cowMapShards = 256
+
+var cowMapSeed = maphash.MakeSeed()
+
+func hashString(key string) uint64 {
+	return maphash.String(cowMapSeed, key)
+}
+
+func hashEntityHash(key [util.HashSize]byte) uint64 {
+	// The keys are already SHA-256 hashes, so any part of them is evenly distributed
+	return binary.LittleEndian.Uint64(key[:8])
+}
+
+// cowMap is a copy-on-write map split into shards.
+//
+// Copying a cowMap only copies the shard array, and the first write to a shard after copying clones that shard,
+// so publishing a new snapshot after a single change doesn't need to copy the whole map.
+// Shards that were already cloned since the last copy are modified in place,
+// which makes batches of changes inside a single snapshot update cheap too.
+type cowMap[K comparable, V any] struct {
+	shards [cowMapShards]map[K]V
+	owned  [cowMapShards]bool
+	hash   func(K) uint64
+	size   int
+}
+
+func newCOWMap[K comparable, V any](hash func(K) uint64) cowMap[K, V] {
+	return cowMap[K, V]{hash: hash}
+}
+
+// clone returns a copy of the map that shares all shards with the original.
+// The original must not be modified afterwards.
+func (cm *cowMap[K, V]) clone() cowMap[K, V] {
+	return cowMap[K, V]{
+		shards: cm.shards,
+		hash:   cm.hash,
+		size:   cm.size,
+	}
+}
+
+func (cm *cowMap[K, V]) shard(key K) int {
+	return int(cm.hash(key) % cowMapShards)
+}
+
+// writableShard returns the shard for the given key, cloning it first if it's still shared with another copy.
+func (cm *cowMap[K, V]) writableShard(key K) map[K]V {
+	idx := cm.shard(key)
+	if !cm.owned[idx] {
+		if cm.shards[idx] == nil {
+			cm.shards[idx] = make(map[K]V)
+		} else {
+			cm.shards[idx] = maps.Clone(cm.shards[idx])
+		}
+		cm.owned[idx] = true
+	}
+	return cm.shards[idx]
+}
+
+func (cm *cowMap[K, V]) Get(key K) (value V, ok bool) {
+	value, ok = cm.shards[cm.shard(key)][key]
+	return
+}
+
+func (cm *cowMap[K, V]) Set(key K, value V) {
+	shard := cm.writableShard(key)
+	if _, exists := shard[key]; !exists {
+		cm.size++
+	}
+	shard[key] = value
+}
+
+func (cm *cowMap[K, V]) Delete(key K) {
+	if _, exists := cm.shards[cm.shard(key)][key]; !exists {
+		return
+	}
+	delete(cm.writableShard(key), key)
+	cm.size--
+}
+
+func (cm *cowMap[K, V]) Len() int {
+	return cm.size
+}
+
+// All iterates over all entries in the map in an unspecified order.
+func (cm *cowMap[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for _, shard := range cm.shards {
+			for key, value := range shard {
+				if !yield(key, value) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Values iterates over all values in the map in an unspecified order.
+func (cm *cowMap[K, V]) Values() iter.Seq[V] {
+	return func(yield func(V) bool) {
+		for _, value := range cm.All() {
+			if !yield(value) {
+				return
+			}
+		}
+	}
+}
+
+// Keys iterates over all keys in the map in an unspecified order.
+func (cm *cowMap[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for key := range cm.All() {
+			if !yield(key) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package policylist
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+type indexedRule struct {
+	*Policy
+	seq uint64
+}
+
+type globBucketKind int
+
+const (
+	globBucketOther globBucketKind = iota
+	globBucketPrefix
+	globBucketSuffix
+)
+
+// globBucket finds the best literal part of a glob pattern to index it by.
+//
+// Any string matching the pattern must start with the literal text before the first wildcard
+// and end with the literal text after the last wildcard, so the longer of those is used as the key.
+// Patterns that start and end with a wildcard can't be indexed and are evaluated for every entity.
+func globBucket(pattern string) (globBucketKind, string) {
+	first := strings.IndexAny(pattern, "*?")
+	if first < 0 {
+		return globBucketOther, ""
+	}
+	last := strings.LastIndexAny(pattern, "*?")
+	prefix, suffix := pattern[:first], pattern[last+1:]
+	if suffix != "" && len(suffix) >= len(prefix) {
+		return globBucketSuffix, suffix
+	} else if prefix != "" {
+		return globBucketPrefix, prefix
+	}
+	return globBucketOther, ""
+}
+
+// globIndex partitions dynamic rules by their literal prefix or suffix,
+// so that matching an entity only evaluates the patterns that could possibly match it.
+//
+// Like the rest of listSnapshot, a globIndex must not be modified after it's published.
+// Buckets are shared between clones, so the first change to a bucket after cloning copies it.
+// Buckets that were already copied are modified in place, so batches of changes only copy each bucket once.
+type globIndex struct {
+	bySuffix cowMap[string, []indexedRule]
+	byPrefix cowMap[string, []indexedRule]
+	other    []indexedRule
+
+	ownedBuckets map[globBucketKey]struct{}
+	ownsOther    bool
+}
+
+type globBucketKey struct {
+	kind globBucketKind
+	key  string
+}
+
+func newGlobIndex() *globIndex {
+	return &globIndex{
+		bySuffix:  newCOWMap[string, []indexedRule](hashString),
+		byPrefix:  newCOWMap[string, []indexedRule](hashString),
+		ownsOther: true,
+	}
+}
+
+func (gi *globIndex) clone() *globIndex {
+	return &globIndex{
+		bySuffix: gi.bySuffix.clone(),
+		byPrefix: gi.byPrefix.clone(),
+		other:    gi.other,
+	}
+}
+
+func (gi *globIndex) bucketMap(kind globBucketKind) *cowMap[string, []indexedRule] {
+	if kind == globBucketSuffix {
+		return &gi.bySuffix
+	}
+	return &gi.byPrefix
+}
+
+func (gi *globIndex) getRules(kind globBucketKind, key string) []indexedRule {
+	if kind == globBucketOther {
+		return gi.other
+	}
+	rules, _ := gi.bucketMap(kind).Get(key)
+	return rules
+}
+
+// writableRules returns the rules in the given bucket, copying them first if they may be shared with another clone.
+func (gi *globIndex) writableRules(kind globBucketKind, key string) []indexedRule {
+	if kind == globBucketOther {
+		if !gi.ownsOther {
+			gi.other = slices.Clone(gi.other)
+			gi.ownsOther = true
+		}
+		return gi.other
+	}
+	rules := gi.getRules(kind, key)
+	bucketKey := globBucketKey{kind: kind, key: key}
+	if _, owned := gi.ownedBuckets[bucketKey]; !owned {
+		rules = slices.Clone(rules)
+		if gi.ownedBuckets == nil {
+			gi.ownedBuckets = make(map[globBucketKey]struct{})
+		}
+		gi.ownedBuckets[bucketKey] = struct{}{}
+	}
+	return rules
+}
+
+func (gi *globIndex) setRules(kind globBucketKind, key string, rules []indexedRule) {
+	if kind == globBucketOther {
+		gi.other = rules
+	} else if len(rules) == 0 {
+		gi.bucketMap(kind).Delete(key)
+	} else {
+		gi.bucketMap(kind).Set(key, rules)
+	}
+}
+
+func (gi *globIndex) add(policy *Policy, seq uint64) {
+	kind, key := globBucket(policy.Entity)
+	gi.setRules(kind, key, append(gi.writableRules(kind, key), indexedRule{Policy: policy, seq: seq}))
+}
+
+// remove removes the given policy from the index and returns the sequence number it was added with.
+func (gi *globIndex) remove(policy *Policy) (uint64, bool) {
+	kind, key := globBucket(policy.Entity)
+	idx := slices.IndexFunc(gi.getRules(kind, key), func(rule indexedRule) bool {
+		return rule.Policy == policy
+	})
+	if idx < 0 {
+		return 0, false
+	}
+	rules := gi.writableRules(kind, key)
+	seq := rules[idx].seq
+	gi.setRules(kind, key, slices.Delete(rules, idx, idx+1))
+	return seq, true
+}
+
+func appendMatching(output []indexedRule, rules []indexedRule, entity string, exclude *Policy) []indexedRule {
+	for _, rule := range rules {
+		if rule.Policy != exclude && rule.Pattern.Match(entity) {
+			output = append(output, rule)
+		}
+	}
+	return output
+}
+
+// match finds all indexed rules matching the given entity, newest first.
+func (gi *globIndex) match(entity string, exclude *Policy) Match {
+	var matches []indexedRule
+	for i := 0; i < len(entity); i++ {
+		if rules, ok := gi.bySuffix.Get(entity[i:]); ok {
+			matches = appendMatching(matches, rules, entity, exclude)
+		}
+		if rules, ok := gi.byPrefix.Get(entity[:i+1]); ok {
+			matches = appendMatching(matches, rules, entity, exclude)
+		}
+	}
+	matches = appendMatching(matches, gi.other, entity, exclude)
+	if len(matches) == 0 {
+		return nil
+	}
+	// Newer rules come first, same as when all dynamic rules were evaluated linearly
+	slices.SortFunc(matches, func(a, b indexedRule) int {
+		return cmp.Compare(b.seq, a.seq)
+	})
+	output := make(Match, len(matches))
+	for i, rule := range matches {
+		output[i] = rule.Policy
+	}
+	return output
+}
@@ -0,0 +1,118 @@
+package policylist
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+)
+
+func TestGlobBucket(t *testing.T) {
+	tests := []struct {
+		pattern string
+		kind    globBucketKind
+		key     string
+	}{
+		{"@spam*:example.com", globBucketSuffix, ":example.com"},
+		{"@spammer-*", globBucketPrefix, "@spammer-"},
+		{"@longprefix*:x", globBucketPrefix, "@longprefix"},
+		{"@a?c:example.com", globBucketSuffix, "c:example.com"},
+		{"*.example.com", globBucketSuffix, ".example.com"},
+		{"*spam*", globBucketOther, ""},
+		{"?", globBucketOther, ""},
+		{"@exact:example.com", globBucketOther, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			kind, key := globBucket(test.pattern)
+			if kind != test.kind || key != test.key {
+				t.Errorf("globBucket(%q) = %v, %q; expected %v, %q", test.pattern, kind, key, test.kind, test.key)
+			}
+		})
+	}
+}
+
+// linearMatch is the reference implementation that the index must agree with:
+// every pattern is evaluated and newer rules come first.
+func linearMatch(policies []*Policy, entity string) Match {
+	var output Match
+	for _, policy := range slices.Backward(policies) {
+		if policy.Pattern.Match(entity) {
+			output = append(output, policy)
+		}
+	}
+	return output
+}
+
+func TestGlobIndex_MatchesLinear(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		entities []string
+	}{{
+		name:     "question mark",
+		patterns: []string{"@a?c:example.com", "@???:example.com", "?bc", "@abc:example.co?"},
+		entities: []string{"@abc:example.com", "@ac:example.com", "@abbc:example.com", "abc", "@abc:example.con", "@xyz:example.com"},
+	}, {
+		name:     "star in the middle",
+		patterns: []string{"@spam*:example.com", "@*bot:example.com", "@a*b*c:example.com", "@bad*"},
+		entities: []string{"@spam:example.com", "@spammer:example.com", "@mybot:example.com", "@abc:example.com", "@a-b-c:example.com", "@abd:example.com", "@bad:evil.com", "@spam:example.org"},
+	}, {
+		name: "overlapping prefix and suffix",
+		patterns: []string{
+			"@spam*", "@spam*:example.com", "@sp*:example.com", "*:example.com", "*.example.com",
+			"@spam*:example.com", "@spam*m", "*am*", "*",
+		},
+		entities: []string{"@spam:example.com", "@spammer:sub.example.com", "@sp:example.com", "@x:example.com", "@spam", "spam", "", "@other:matrix.org"},
+	}, {
+		name:     "server globs",
+		patterns: []string{"*.evil.com", "evil.*", "ev?l.com", "*evil*", "e*l.com"},
+		entities: []string{"evil.com", "sub.evil.com", "evil.org", "evxl.com", "notevil.net", "el.com", "example.com"},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gi := newGlobIndex()
+			policies := make([]*Policy, len(test.patterns))
+			for i, pattern := range test.patterns {
+				policies[i] = makePolicy(pattern, fmt.Sprintf("rule%d", i), event.PolicyRecommendationBan)
+				gi.add(policies[i], uint64(i))
+			}
+			for _, entity := range test.entities {
+				expected := linearMatch(policies, entity)
+				if actual := gi.match(entity, nil); !slices.Equal(actual, expected) {
+					t.Errorf("match(%q) = %v; expected %v", entity, actual, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestGlobIndex_CloneIsolation(t *testing.T) {
+	gi := newGlobIndex()
+	first := makePolicy("@spam*:example.com", "first", event.PolicyRecommendationBan)
+	other := makePolicy("*spam*", "other", event.PolicyRecommendationBan)
+	gi.add(first, 0)
+	gi.add(other, 1)
+
+	next := gi.clone()
+	second := makePolicy("@spammer*:example.com", "second", event.PolicyRecommendationBan)
+	next.add(second, 2)
+	next.add(makePolicy("@sp*:example.com", "third", event.PolicyRecommendationBan), 3)
+	if _, ok := next.remove(first); !ok {
+		t.Fatal("failed to remove first policy from clone")
+	}
+	if _, ok := next.remove(other); !ok {
+		t.Fatal("failed to remove other policy from clone")
+	}
+
+	if match := gi.match("@spammer:example.com", nil); !slices.Equal(match, Match{other, first}) {
+		t.Errorf("original index match = %v; expected it to be unaffected by the clone", match)
+	}
+	if match := next.match("@spammer:example.com", nil); len(match) != 2 || match[1] != second {
+		t.Errorf("cloned index match = %v; expected the new policies only", match)
+	}
+	if _, ok := next.remove(first); ok {
+		t.Error("removing an already removed policy succeeded")
+	}
+}
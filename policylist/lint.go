@@ -0,0 +1,215 @@
+package policylist
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/util"
+)
+
+type LintIssueType string
+
+const (
+	// LintConflict means that a ban and an unban policy apply to the same entity.
+	LintConflict LintIssueType = "conflict"
+	// LintDuplicate means that there are multiple policies for the exact same entity with the same effect.
+	LintDuplicate LintIssueType = "duplicate"
+	// LintShadowed means that a glob is fully covered by another glob with the same effect.
+	LintShadowed LintIssueType = "shadowed"
+	// LintHashedAndPlain means that the same entity has both a hashed and a plaintext policy.
+	LintHashedAndPlain LintIssueType = "hashed_and_plain"
+	// LintFiltered means that a policy is ignored because it matches the hacky rule filter.
+	LintFiltered LintIssueType = "filtered"
+	// LintUntrusted means that a policy is ignored because its sender isn't trusted by the list's trust settings.
+	LintUntrusted LintIssueType = "untrusted"
+)
+
+// LintIssue is a single problem found by Store.Lint.
+type LintIssue struct {
+	Type LintIssueType
+	// The policies involved in the issue, in list priority order.
+	// For shadowed globs, the first policy is the shadowed one and the second is the broader glob.
+	Policies []*Policy
+	// For conflicts between a specific entity and other policies,
+	// Winner is the policy that actually takes effect for that entity.
+	Winner *Policy
+}
+
+func isUnban(policy *Policy) bool {
+	return policy.Recommendation == event.PolicyRecommendationUnban
+}
+
+func isBanOrUnban(policy *Policy) bool {
+	switch policy.Recommendation {
+	case event.PolicyRecommendationBan, event.PolicyRecommendationUnban, event.PolicyRecommendationUnstableTakedown:
+		return true
+	default:
+		return false
+	}
+}
+
+type linter struct {
+	lists  []*listSnapshot
+	modes  ListModes
+	trust  func(*Policy) bool
+	issues []*LintIssue
+	order  map[*Policy]int
+	pairs  map[lintPairKey]struct{}
+}
+
+type lintPairKey struct {
+	issueType LintIssueType
+	a, b      *Policy
+}
+
+// addPair adds an issue involving two policies, unless the same pair has already been reported.
+func (l *linter) addPair(issueType LintIssueType, a, b, winner *Policy) {
+	if l.order[b] < l.order[a] && issueType != LintShadowed {
+		a, b = b, a
+	}
+	key := lintPairKey{issueType: issueType, a: a, b: b}
+	if _, alreadyReported := l.pairs[key]; alreadyReported {
+		return
+	}
+	l.pairs[key] = struct{}{}
+	l.issues = append(l.issues, &LintIssue{Type: issueType, Policies: []*Policy{a, b}, Winner: winner})
+}
+
+func (l *linter) matchAll(entity string) (output Match) {
+	for _, list := range l.lists {
+		for _, policy := range list.match(entity) {
+			if l.trust(policy) {
+				output = append(output, policy)
+			}
+		}
+	}
+	return
+}
+
+// ignored returns true if the given policy doesn't affect anything and shouldn't be linted against other policies.
+func (l *linter) ignored(policy *Policy) bool {
+	return policy.Ignored || !l.trust(policy)
+}
+
+func (l *linter) lintGroup(group []*Policy) {
+	for i, a := range group {
+		for _, b := range group[i+1:] {
+			if isUnban(a) != isUnban(b) {
+				l.addPair(LintConflict, a, b, nil)
+			} else {
+				l.addPair(LintDuplicate, a, b, nil)
+			}
+		}
+	}
+}
+
+func (l *linter) run() []*LintIssue {
+	var all []*Policy
+	for _, list := range l.lists {
+		policies := slices.Collect(list.byStateKey.Values())
+		slices.SortFunc(policies, func(a, b *Policy) int {
+			return cmp.Or(cmp.Compare(a.EntityOrHash(), b.EntityOrHash()), cmp.Compare(a.StateKey, b.StateKey))
+		})
+		for _, policy := range policies {
+			if isBanOrUnban(policy) {
+				l.order[policy] = len(all)
+				all = append(all, policy)
+			}
+		}
+	}
+	var entityOrder []string
+	var hashOrder [][util.HashSize]byte
+	byEntity := make(map[string][]*Policy)
+	byHash := make(map[[util.HashSize]byte][]*Policy)
+	plainByHash := make(map[[util.HashSize]byte][]*Policy)
+	for _, policy := range all {
+		if isHackyFiltered(policy) {
+			l.issues = append(l.issues, &LintIssue{Type: LintFiltered, Policies: []*Policy{policy}})
+		} else if !policy.Ignored && !l.trust(policy) {
+			l.issues = append(l.issues, &LintIssue{Type: LintUntrusted, Policies: []*Policy{policy}})
+		}
+		if l.ignored(policy) {
+			continue
+		}
+		if policy.EntityHash != nil {
+			if _, ok := byHash[*policy.EntityHash]; !ok {
+				hashOrder = append(hashOrder, *policy.EntityHash)
+			}
+			byHash[*policy.EntityHash] = append(byHash[*policy.EntityHash], policy)
+		} else {
+			if _, ok := byEntity[policy.Entity]; !ok {
+				entityOrder = append(entityOrder, policy.Entity)
+			}
+			byEntity[policy.Entity] = append(byEntity[policy.Entity], policy)
+			if !isDynamic(policy) {
+				hash := util.SHA256String(policy.Entity)
+				plainByHash[hash] = append(plainByHash[hash], policy)
+			}
+		}
+	}
+	// Match-based checks go first, so that conflicts with a specific entity are reported with the winning policy
+	// rather than as a plain pair from the grouping below.
+	for _, policy := range all {
+		if l.ignored(policy) || policy.EntityHash != nil {
+			continue
+		}
+		// The entity of a glob policy is matched as a literal string here, so any broader glob will match it
+		// as long as it doesn't use ? (which can't match the arbitrary-length text of a *).
+		isGlob := isDynamic(policy)
+		matches := l.matchAll(policy.Entity)
+		for _, other := range matches {
+			if other == policy || other.Ignored || !isBanOrUnban(other) {
+				continue
+			}
+			if isUnban(other) != isUnban(policy) {
+				var winner *Policy
+				if !isGlob {
+					winner = matches.RecommendationsWithModes(l.modes).BanOrUnban
+				}
+				l.addPair(LintConflict, other, policy, winner)
+			} else if isGlob && isDynamic(other) && other.Entity != policy.Entity && !strings.ContainsRune(other.Entity, '?') {
+				l.addPair(LintShadowed, policy, other, nil)
+			}
+		}
+	}
+	for _, entity := range entityOrder {
+		l.lintGroup(byEntity[entity])
+	}
+	for _, hash := range hashOrder {
+		l.lintGroup(byHash[hash])
+		for _, hashed := range byHash[hash] {
+			for _, plain := range plainByHash[hash] {
+				l.addPair(LintHashedAndPlain, hashed, plain, nil)
+			}
+		}
+	}
+	return l.issues
+}
+
+// Lint finds conflicting, redundant and ignored policies in the given lists.
+// The list IDs must be in priority order, and the merge modes are used to determine the winners of conflicts.
+// Policies from senders that aren't trusted by the trust settings are reported, but not linted against other policies.
+func (s *Store) Lint(listIDs []id.RoomID, modes ListModes, trust ListTrust) (issues []*LintIssue) {
+	if len(listIDs) == 0 {
+		return nil
+	}
+	for _, getter := range []func(*Room) *List{(*Room).GetUserRules, (*Room).GetRoomRules, (*Room).GetServerRules} {
+		l := &linter{
+			modes: modes,
+			trust: func(policy *Policy) bool {
+				return s.Trusts(trust, policy)
+			},
+			order: make(map[*Policy]int),
+			pairs: make(map[lintPairKey]struct{}),
+		}
+		for room := range s.iterRooms(listIDs) {
+			l.lists = append(l.lists, getter(room).snapshot.Load())
+		}
+		issues = append(issues, l.run()...)
+	}
+	return issues
+}
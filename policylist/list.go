@@ -1,7 +1,9 @@
 package policylist
 
 import (
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -13,32 +15,57 @@ import (
 	"go.mau.fi/meowlnir/util"
 )
 
-type dplNode struct {
-	*Policy
-	prev *dplNode
-	next *dplNode
+// listSnapshot is an immutable view of the rules in a List.
+//
+// Snapshots are never modified after they're published, so readers can use them without any locks.
+// Writers clone the current snapshot, apply their changes to the clone and then atomically swap it in.
+// The maps are copy-on-write, so cloning only copies the parts of the maps that are actually changed.
+type listSnapshot struct {
+	byStateKey   cowMap[string, *Policy]
+	byEntity     cowMap[string, *Policy]
+	byEntityHash cowMap[[util.HashSize]byte, *Policy]
+	// dynamic contains non-exact glob rules indexed by their literal prefix or suffix.
+	dynamic *globIndex
+	nextSeq uint64
+}
+
+func newListSnapshot() *listSnapshot {
+	return &listSnapshot{
+		byStateKey:   newCOWMap[string, *Policy](hashString),
+		byEntity:     newCOWMap[string, *Policy](hashString),
+		byEntityHash: newCOWMap[[util.HashSize]byte, *Policy](hashEntityHash),
+		dynamic:      newGlobIndex(),
+	}
+}
+
+func (s *listSnapshot) clone() *listSnapshot {
+	return &listSnapshot{
+		byStateKey:   s.byStateKey.clone(),
+		byEntity:     s.byEntity.clone(),
+		byEntityHash: s.byEntityHash.clone(),
+		dynamic:      s.dynamic.clone(),
+		nextSeq:      s.nextSeq,
+	}
 }
 
 // List represents the list of rules for a single entity type.
 //
 // Policies are split into literal rules and dynamic rules. Literal rules are stored in a map for fast matching,
-// while dynamic rules are glob patterns and are evaluated one by one for each query.
+// while dynamic rules are glob patterns which are indexed by their literal prefix or suffix (see globIndex).
+//
+// Reads never take locks: they operate on an immutable snapshot which is replaced atomically on every update.
 type List struct {
 	matchDuration prometheus.Observer
-	byStateKey    map[string]*dplNode
-	byEntity      map[string]*dplNode
-	byEntityHash  map[[util.HashSize]byte]*dplNode
-	dynamicHead   *dplNode
-	lock          sync.RWMutex
+	snapshot      atomic.Pointer[listSnapshot]
+	writeLock     sync.Mutex
 }
 
 func NewList(roomID id.RoomID, entityType string) *List {
-	return &List{
+	l := &List{
 		matchDuration: matchDuration.WithLabelValues(roomID.String(), entityType),
-		byStateKey:    make(map[string]*dplNode),
-		byEntity:      make(map[string]*dplNode),
-		byEntityHash:  make(map[[util.HashSize]byte]*dplNode),
 	}
+	l.snapshot.Store(newListSnapshot())
+	return l
 }
 
 func typeQuality(evtType event.Type) int {
@@ -54,87 +81,105 @@ func typeQuality(evtType event.Type) int {
 	}
 }
 
-func (l *List) removeFromLinkedList(node *dplNode) {
-	if l.dynamicHead == node {
-		l.dynamicHead = node.next
+func isDynamic(value *Policy) bool {
+	_, isStatic := value.Pattern.(glob.ExactGlob)
+	return value.Entity != "" && !isStatic && !value.Ignored
+}
+
+func (s *listSnapshot) unindex(value *Policy) {
+	if value.Entity != "" {
+		if existing, _ := s.byEntity.Get(value.Entity); existing == value {
+			s.byEntity.Delete(value.Entity)
+		}
 	}
-	if node.prev != nil {
-		node.prev.next = node.next
+	if value.EntityHash != nil {
+		if existing, _ := s.byEntityHash.Get(*value.EntityHash); existing == value {
+			s.byEntityHash.Delete(*value.EntityHash)
+		}
 	}
-	if node.next != nil {
-		node.next.prev = node.prev
+}
+
+func (s *listSnapshot) index(value *Policy) {
+	if value.Ignored {
+		return
+	}
+	if value.Entity != "" {
+		s.byEntity.Set(value.Entity, value)
+	}
+	if value.EntityHash != nil {
+		s.byEntityHash.Set(*value.EntityHash, value)
 	}
 }
 
-func (l *List) Add(value *Policy) (*Policy, bool) {
-	l.lock.Lock()
-	defer l.lock.Unlock()
-	existing, ok := l.byStateKey[value.StateKey]
+func (s *listSnapshot) add(value *Policy) (*Policy, bool) {
+	existing, ok := s.byStateKey.Get(value.StateKey)
+	var seq uint64
+	var keepSeq bool
 	if ok {
 		if typeQuality(existing.Type) > typeQuality(value.Type) {
 			// There's an existing policy with the same state key, but a newer event type, ignore this one.
 			return nil, false
-		} else if existing.EntityOrHash() == value.EntityOrHash() {
-			oldPolicy := existing.Policy
-			// The entity in the policy didn't change, just update the policy.
-			existing.Policy = value
-			return oldPolicy, true
-		}
-		// There's an existing event with the same state key, but the entity changed, remove the old node.
-		l.removeFromLinkedList(existing)
-		if existing.Entity != "" {
-			delete(l.byEntity, existing.Entity)
 		}
-		if existing.EntityHash != nil {
-			delete(l.byEntityHash, *existing.EntityHash)
-		}
-	}
-	node := &dplNode{Policy: value}
-	l.byStateKey[value.StateKey] = node
-	if !value.Ignored {
-		if value.Entity != "" {
-			l.byEntity[value.Entity] = node
-		}
-		if value.EntityHash != nil {
-			l.byEntityHash[*value.EntityHash] = node
-		}
-	}
-	if _, isStatic := value.Pattern.(glob.ExactGlob); value.Entity != "" && !isStatic && !value.Ignored {
-		if l.dynamicHead != nil {
-			node.next = l.dynamicHead
-			l.dynamicHead.prev = node
+		s.unindex(existing)
+		seq, keepSeq = s.dynamic.remove(existing)
+		// If the entity changed, the rule counts as new rather than keeping its old position.
+		keepSeq = keepSeq && existing.EntityOrHash() == value.EntityOrHash()
+	}
+	s.byStateKey.Set(value.StateKey, value)
+	s.index(value)
+	if isDynamic(value) {
+		if !keepSeq {
+			seq = s.nextSeq
+			s.nextSeq++
 		}
-		l.dynamicHead = node
+		s.dynamic.add(value, seq)
 	}
-	if existing != nil {
-		return existing.Policy, true
-	}
-	return nil, true
+	return existing, true
 }
 
-func (l *List) Remove(eventType event.Type, stateKey string) *Policy {
-	l.lock.Lock()
-	defer l.lock.Unlock()
-	if value, ok := l.byStateKey[stateKey]; ok && eventType == value.Type {
-		l.removeFromLinkedList(value)
-		if entValue, ok := l.byEntity[value.Entity]; ok && entValue == value && value.Entity != "" {
-			delete(l.byEntity, value.Entity)
-		}
-		if value.EntityHash != nil {
-			if entHashValue, ok := l.byEntityHash[*value.EntityHash]; ok && entHashValue == value {
-				delete(l.byEntityHash, *value.EntityHash)
-			}
-		}
-		delete(l.byStateKey, stateKey)
-		return value.Policy
-	}
-	return nil
+func (s *listSnapshot) remove(eventType event.Type, stateKey string) *Policy {
+	value, ok := s.byStateKey.Get(stateKey)
+	if !ok || eventType != value.Type {
+		return nil
+	}
+	s.unindex(value)
+	s.dynamic.remove(value)
+	s.byStateKey.Delete(stateKey)
+	return value
+}
+
+// update applies the given function to a copy of the current snapshot and then publishes the copy.
+//
+// Multiple changes can be made inside a single call, which avoids publishing intermediate snapshots
+// and lets the changes modify already copied parts of the snapshot in place.
+func (l *List) update(fn func(s *listSnapshot)) {
+	l.writeLock.Lock()
+	defer l.writeLock.Unlock()
+	next := l.snapshot.Load().clone()
+	fn(next)
+	l.snapshot.Store(next)
+}
+
+func (l *List) Add(value *Policy) (existing *Policy, added bool) {
+	l.update(func(s *listSnapshot) {
+		existing, added = s.add(value)
+	})
+	return
+}
+
+func (l *List) Remove(eventType event.Type, stateKey string) (removed *Policy) {
+	l.update(func(s *listSnapshot) {
+		removed = s.remove(eventType, stateKey)
+	})
+	return
 }
 
 var matchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
 	Name: "meowlnir_policylist_match_duration_nanoseconds",
 	Help: "Time taken to evaluate an entity against all policies",
 	Buckets: []float64{
+		// 100ns - 500ns
+		100, 250, 500,
 		// 1µs - 100µs
 		1_000, 5_000, 10_000, 25_000, 50_000, 75_000, 100_000,
 		// 250µs - 10ms
@@ -146,22 +191,21 @@ func (l *List) Match(entity string) (output Match) {
 	if entity == "" {
 		return
 	}
-	l.lock.RLock()
-	defer l.lock.RUnlock()
 	start := time.Now()
-	exactMatch, ok := l.byEntity[entity]
+	output = l.snapshot.Load().match(entity)
+	l.matchDuration.Observe(float64(time.Since(start)))
+	return
+}
+
+func (s *listSnapshot) match(entity string) (output Match) {
+	exactMatch, ok := s.byEntity.Get(entity)
 	if ok {
-		output = Match{exactMatch.Policy}
+		output = Match{exactMatch}
 	}
-	if value, ok := l.byEntityHash[util.SHA256String(entity)]; ok {
-		output = append(output, value.Policy)
+	if value, ok := s.byEntityHash.Get(util.SHA256String(entity)); ok {
+		output = append(output, value)
 	}
-	for item := l.dynamicHead; item != nil; item = item.next {
-		if !item.Ignored && item.Pattern.Match(entity) && item != exactMatch {
-			output = append(output, item.Policy)
-		}
-	}
-	l.matchDuration.Observe(float64(time.Since(start)))
+	output = append(output, s.dynamic.match(entity, exactMatch)...)
 	return
 }
 
@@ -169,33 +213,33 @@ func (l *List) MatchExact(entity string) (output Match) {
 	if entity == "" {
 		return
 	}
-	l.lock.RLock()
-	defer l.lock.RUnlock()
-	if value, ok := l.byEntity[entity]; ok {
-		output = Match{value.Policy}
+	s := l.snapshot.Load()
+	if value, ok := s.byEntity.Get(entity); ok {
+		output = Match{value}
 	}
-	if value, ok := l.byEntityHash[util.SHA256String(entity)]; ok {
-		output = append(output, value.Policy)
+	if value, ok := s.byEntityHash.Get(util.SHA256String(entity)); ok {
+		output = append(output, value)
 	}
 	return
 }
 
 func (l *List) MatchHash(hash [util.HashSize]byte) (output Match) {
-	l.lock.RLock()
-	defer l.lock.RUnlock()
-	if value, ok := l.byEntityHash[hash]; ok {
-		output = Match{value.Policy}
+	if value, ok := l.snapshot.Load().byEntityHash.Get(hash); ok {
+		output = Match{value}
 	}
 	return
 }
 
 func (l *List) Search(patternString string, pattern glob.Glob) (output Match) {
-	l.lock.RLock()
-	defer l.lock.RUnlock()
-	for _, item := range l.byStateKey {
+	for item := range l.snapshot.Load().byStateKey.Values() {
 		if !item.Ignored && (pattern.Match(item.EntityOrHash()) || item.Pattern.Match(patternString)) {
-			output = append(output, item.Policy)
+			output = append(output, item)
 		}
 	}
 	return
 }
+
+// All returns all policies in the list, including ignored ones.
+func (l *List) All() []*Policy {
+	return slices.Collect(l.snapshot.Load().byStateKey.Values())
+}
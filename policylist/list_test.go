@@ -0,0 +1,135 @@
+package policylist
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"go.mau.fi/util/glob"
+	"maunium.net/go/mautrix/event"
+)
+
+func makePolicy(entity, stateKey string, recommendation event.PolicyRecommendation) *Policy {
+	return &Policy{
+		ModPolicyContent: &event.ModPolicyContent{
+			Entity:         entity,
+			Recommendation: recommendation,
+		},
+		Pattern:    glob.Compile(entity),
+		EntityType: EntityTypeUser,
+		RoomID:     "!list:example.com",
+		StateKey:   stateKey,
+		Type:       event.StatePolicyUser,
+	}
+}
+
+func makeBenchmarkList(size int) *List {
+	list := NewList("!list:example.com", "user")
+	list.update(func(s *listSnapshot) {
+		for i := 0; i < size; i++ {
+			entity := fmt.Sprintf("@user%d:server%d.example.com", i, i%100)
+			if i%10 == 0 {
+				entity = fmt.Sprintf("@spam%d*:server%d.example.com", i, i%100)
+			}
+			s.add(makePolicy(entity, fmt.Sprintf("state%d", i), event.PolicyRecommendationBan))
+		}
+	})
+	return list
+}
+
+func benchmarkMatch(b *testing.B, size int, concurrentUpdates bool) {
+	list := makeBenchmarkList(size)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	if concurrentUpdates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				stateKey := fmt.Sprintf("churn%d", i%1000)
+				if i%2 == 0 {
+					list.Add(makePolicy(fmt.Sprintf("@churn%d:example.com", i), stateKey, event.PolicyRecommendationBan))
+				} else {
+					list.Remove(event.StatePolicyUser, stateKey)
+				}
+			}
+		}()
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			list.Match(fmt.Sprintf("@user%d:server%d.example.com", i%size, i%100))
+			i++
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	wg.Wait()
+}
+
+func BenchmarkListMatch(b *testing.B) {
+	for _, size := range []int{1_000, 100_000} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			benchmarkMatch(b, size, false)
+		})
+		b.Run(fmt.Sprintf("size=%d/concurrent-updates", size), func(b *testing.B) {
+			benchmarkMatch(b, size, true)
+		})
+	}
+}
+
+func BenchmarkListAdd(b *testing.B) {
+	for _, size := range []int{1_000, 100_000} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			list := makeBenchmarkList(size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				list.Add(makePolicy(fmt.Sprintf("@new%d:example.com", i), fmt.Sprintf("new%d", i%1000), event.PolicyRecommendationBan))
+			}
+		})
+	}
+}
+
+func TestList_AddRemove(t *testing.T) {
+	list := NewList("!list:example.com", "user")
+	exact := makePolicy("@alice:example.com", "alice", event.PolicyRecommendationBan)
+	dynamic := makePolicy("@spam*:example.com", "spam", event.PolicyRecommendationBan)
+	list.Add(exact)
+	list.Add(dynamic)
+	before := list.snapshot.Load()
+
+	replacement := makePolicy("@alice:example.com", "alice", event.PolicyRecommendationUnban)
+	existing, added := list.Add(replacement)
+	if !added || existing != exact {
+		t.Fatalf("Add returned %v, %v; expected the replaced policy", existing, added)
+	}
+	if match := list.Match("@alice:example.com"); len(match) != 1 || match[0] != replacement {
+		t.Errorf("Match after replace = %v, expected only the replacement", match)
+	}
+	// Old snapshots must not be affected by later updates
+	if match := before.match("@alice:example.com"); len(match) != 1 || match[0] != exact {
+		t.Errorf("old snapshot match = %v, expected the original policy", match)
+	}
+
+	if removed := list.Remove(event.StatePolicyUser, "spam"); removed != dynamic {
+		t.Errorf("Remove returned %v, expected the dynamic policy", removed)
+	}
+	if match := list.Match("@spammer:example.com"); len(match) != 0 {
+		t.Errorf("Match after remove = %v, expected no match", match)
+	}
+	if match := before.match("@spammer:example.com"); len(match) != 1 || match[0] != dynamic {
+		t.Errorf("old snapshot match = %v, expected the dynamic policy", match)
+	}
+	if removed := list.Remove(event.StatePolicyServer, "alice"); removed != nil {
+		t.Errorf("Remove with wrong event type removed %v", removed)
+	}
+	if count := len(list.All()); count != 1 {
+		t.Errorf("list has %d policies, expected 1", count)
+	}
+}
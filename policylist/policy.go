@@ -24,29 +24,156 @@ type Policy struct {
 	Ignored    bool
 }
 
+// NewPolicy parses a policy state event, such as one created with Policy.Event.
+// Returns nil if the event isn't a valid policy event or if it removes a policy instead of setting one.
+func NewPolicy(evt *event.Event) *Policy {
+	var entityType EntityType
+	switch evt.Type.Type {
+	case event.StatePolicyUser.Type, event.StateLegacyPolicyUser.Type, event.StateUnstablePolicyUser.Type:
+		entityType = EntityTypeUser
+	case event.StatePolicyRoom.Type, event.StateLegacyPolicyRoom.Type, event.StateUnstablePolicyRoom.Type:
+		entityType = EntityTypeRoom
+	case event.StatePolicyServer.Type, event.StateLegacyPolicyServer.Type, event.StateUnstablePolicyServer.Type:
+		entityType = EntityTypeServer
+	default:
+		return nil
+	}
+	if evt.StateKey == nil {
+		return nil
+	}
+	evt.Type.Class = event.StateEventType
+	if evt.Content.Parsed == nil {
+		if err := evt.Content.ParseRaw(evt.Type); err != nil {
+			return nil
+		}
+	}
+	content, ok := evt.Content.Parsed.(*event.ModPolicyContent)
+	if !ok {
+		return nil
+	}
+	return parsePolicy(evt, content, entityType)
+}
+
+// Event returns the policy as a state event, which can be used to store the policy outside the policy room.
+func (p *Policy) Event() *event.Event {
+	stateKey := p.StateKey
+	return &event.Event{
+		Type:      p.Type,
+		StateKey:  &stateKey,
+		RoomID:    p.RoomID,
+		Sender:    p.Sender,
+		Timestamp: p.Timestamp,
+		ID:        p.ID,
+		Content:   event.Content{Parsed: p.ModPolicyContent},
+	}
+}
+
+// PolicyRecommendationMute is a Meowlnir-specific recommendation which prevents the user from sending messages
+// in protected rooms by lowering their power level, without removing them from the room.
+const PolicyRecommendationMute event.PolicyRecommendation = "fi.mau.meowlnir.mute"
+
+// PolicyRecommendationWatch is a Meowlnir-specific recommendation which doesn't take any action,
+// but alerts the management room about the activity of matching users in protected rooms.
+const PolicyRecommendationWatch event.PolicyRecommendation = "fi.mau.meowlnir.watch"
+
 // Match represent a list of policies that matched a specific entity.
 type Match []*Policy
 
 type Recommendations struct {
 	BanOrUnban *Policy
+	Mute       *Policy
+	Watch      *Policy
 }
 
 func (r Recommendations) String() string {
 	if r.BanOrUnban != nil {
 		return string(r.BanOrUnban.Recommendation)
+	} else if r.Mute != nil {
+		return string(r.Mute.Recommendation)
+	} else if r.Watch != nil {
+		return string(r.Watch.Recommendation)
 	}
 	return ""
 }
 
-// Recommendations aggregates the recommendations in the match.
+// MergeMode defines how policies from a list are combined with policies from other lists.
+type MergeMode string
+
+const (
+	// MergeModeAuthoritative lists are applied normally: the highest priority matching policy wins.
+	MergeModeAuthoritative MergeMode = "authoritative"
+	// MergeModeAdvisory lists are only used if no authoritative list has a policy for the entity.
+	MergeModeAdvisory MergeMode = "advisory"
+	// MergeModeUnbanOnly lists only contribute unban policies, other policies in them are ignored.
+	MergeModeUnbanOnly MergeMode = "unban_only"
+)
+
+// IsValid checks whether the merge mode is known. The empty string is valid and means authoritative.
+func (mm MergeMode) IsValid() bool {
+	switch mm {
+	case "", MergeModeAuthoritative, MergeModeAdvisory, MergeModeUnbanOnly:
+		return true
+	default:
+		return false
+	}
+}
+
+// ListModes maps policy list room IDs to their merge modes. Lists that aren't in the map are authoritative.
+type ListModes map[id.RoomID]MergeMode
+
+// Applies returns false if the given policy is ignored entirely because of its list's merge mode.
+func (lm ListModes) Applies(policy *Policy) bool {
+	return lm[policy.RoomID] != MergeModeUnbanOnly || policy.Recommendation == event.PolicyRecommendationUnban
+}
+
+// IsAdvisory returns true if the given policy is from an advisory list.
+func (lm ListModes) IsAdvisory(policy *Policy) bool {
+	return lm[policy.RoomID] == MergeModeAdvisory
+}
+
+// Recommendations aggregates the recommendations in the match, treating all lists as authoritative.
+//
+// The match is expected to be in list priority order, so the first relevant policy wins.
 func (m Match) Recommendations() (output Recommendations) {
+	return m.RecommendationsWithModes(nil)
+}
+
+// RecommendationsWithModes aggregates the recommendations in the match, taking list merge modes into account.
+//
+// For each recommendation type, the first applicable policy from a non-advisory list wins.
+// If there are none, the first policy from an advisory list is used instead.
+func (m Match) RecommendationsWithModes(modes ListModes) (output Recommendations) {
+	var advisory Recommendations
 	for _, policy := range m {
+		var target, advisoryTarget **Policy
 		switch policy.Recommendation {
 		case event.PolicyRecommendationBan, event.PolicyRecommendationUnban, event.PolicyRecommendationUnstableTakedown:
-			if output.BanOrUnban == nil {
-				output.BanOrUnban = policy
+			target, advisoryTarget = &output.BanOrUnban, &advisory.BanOrUnban
+		case PolicyRecommendationMute:
+			target, advisoryTarget = &output.Mute, &advisory.Mute
+		case PolicyRecommendationWatch:
+			target, advisoryTarget = &output.Watch, &advisory.Watch
+		default:
+			continue
+		}
+		if !modes.Applies(policy) {
+			continue
+		} else if modes.IsAdvisory(policy) {
+			if *advisoryTarget == nil {
+				*advisoryTarget = policy
 			}
+		} else if *target == nil {
+			*target = policy
 		}
 	}
+	if output.BanOrUnban == nil {
+		output.BanOrUnban = advisory.BanOrUnban
+	}
+	if output.Mute == nil {
+		output.Mute = advisory.Mute
+	}
+	if output.Watch == nil {
+		output.Watch = advisory.Watch
+	}
 	return
 }
@@ -0,0 +1,119 @@
+package policylist
+
+import (
+	"encoding/json"
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestMatch_RecommendationsWithModes(t *testing.T) {
+	const (
+		listA id.RoomID = "!a:example.com"
+		listB id.RoomID = "!b:example.com"
+		listC id.RoomID = "!c:example.com"
+	)
+	policy := func(list id.RoomID, recommendation event.PolicyRecommendation) *Policy {
+		p := makePolicy("@user:example.com", string(list)+string(recommendation), recommendation)
+		p.RoomID = list
+		return p
+	}
+	banA := policy(listA, event.PolicyRecommendationBan)
+	unbanA := policy(listA, event.PolicyRecommendationUnban)
+	banB := policy(listB, event.PolicyRecommendationBan)
+	unbanB := policy(listB, event.PolicyRecommendationUnban)
+	takedownB := policy(listB, event.PolicyRecommendationUnstableTakedown)
+	banC := policy(listC, event.PolicyRecommendationBan)
+	muteA := policy(listA, PolicyRecommendationMute)
+	muteB := policy(listB, PolicyRecommendationMute)
+	watchC := policy(listC, PolicyRecommendationWatch)
+	unknownA := policy(listA, "com.example.unknown")
+
+	tests := []struct {
+		name     string
+		match    Match
+		modes    ListModes
+		expected Recommendations
+	}{
+		{"empty", nil, nil, Recommendations{}},
+		{"first policy wins", Match{banA, unbanB}, nil, Recommendations{BanOrUnban: banA}},
+		{"first unban wins", Match{unbanA, banB}, nil, Recommendations{BanOrUnban: unbanA}},
+		{"explicit authoritative", Match{unbanA, banB}, ListModes{listA: MergeModeAuthoritative}, Recommendations{BanOrUnban: unbanA}},
+		{"takedown counts as ban", Match{takedownB, banC}, nil, Recommendations{BanOrUnban: takedownB}},
+		{"unknown recommendations are skipped", Match{unknownA, banB}, nil, Recommendations{BanOrUnban: banB}},
+		{"advisory loses to lower priority list", Match{banA, unbanB}, ListModes{listA: MergeModeAdvisory}, Recommendations{BanOrUnban: unbanB}},
+		{"advisory used when alone", Match{banA}, ListModes{listA: MergeModeAdvisory}, Recommendations{BanOrUnban: banA}},
+		{"first advisory wins among advisories", Match{banA, unbanB}, ListModes{listA: MergeModeAdvisory, listB: MergeModeAdvisory}, Recommendations{BanOrUnban: banA}},
+		{"unban-only ignores bans", Match{banA, banB}, ListModes{listA: MergeModeUnbanOnly}, Recommendations{BanOrUnban: banB}},
+		{"unban-only keeps unbans", Match{unbanA, banB}, ListModes{listA: MergeModeUnbanOnly}, Recommendations{BanOrUnban: unbanA}},
+		{"unban-only without other lists", Match{banA}, ListModes{listA: MergeModeUnbanOnly}, Recommendations{}},
+		{"unban-only ignores mutes", Match{muteA, muteB}, ListModes{listA: MergeModeUnbanOnly}, Recommendations{Mute: muteB}},
+		{
+			"recommendation types are independent",
+			Match{muteA, banB, watchC},
+			ListModes{listB: MergeModeAdvisory},
+			Recommendations{BanOrUnban: banB, Mute: muteA, Watch: watchC},
+		},
+		{
+			"advisory mute loses to authoritative mute",
+			Match{muteA, muteB, banC},
+			ListModes{listA: MergeModeAdvisory},
+			Recommendations{BanOrUnban: banC, Mute: muteB},
+		},
+		{"unban-only and advisory", Match{banA, banB, unbanA}, ListModes{listA: MergeModeUnbanOnly, listB: MergeModeAdvisory}, Recommendations{BanOrUnban: unbanA}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.match.RecommendationsWithModes(test.modes); actual != test.expected {
+				t.Errorf("RecommendationsWithModes() = %+v; expected %+v", actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestMergeMode_IsValid(t *testing.T) {
+	for _, mode := range []MergeMode{"", MergeModeAuthoritative, MergeModeAdvisory, MergeModeUnbanOnly} {
+		if !mode.IsValid() {
+			t.Errorf("%q should be valid", mode)
+		}
+	}
+	if MergeMode("exclusive").IsValid() {
+		t.Error("unknown merge mode should be invalid")
+	}
+}
+
+func TestNewPolicy_EventRoundTrip(t *testing.T) {
+	hashed := makePolicy("", "hashed", event.PolicyRecommendationBan)
+	hashed.UnstableHashes = &event.PolicyHashes{SHA256: "LSWShZ3cg/u/CPz8UwiWVpFP3/KmiBUAL0dcJgEiRAU="}
+	for _, policy := range []*Policy{
+		makePolicy("@spam*:example.com", "glob", event.PolicyRecommendationBan),
+		makePolicy("@user:example.com", "unban", event.PolicyRecommendationUnban),
+		hashed,
+	} {
+		t.Run(policy.StateKey, func(t *testing.T) {
+			policy.Sender = "@mod:example.com"
+			policy.ID = id.EventID("$" + policy.StateKey)
+			data, err := json.Marshal(policy.Event())
+			if err != nil {
+				t.Fatalf("failed to marshal event: %v", err)
+			}
+			var evt *event.Event
+			if err = json.Unmarshal(data, &evt); err != nil {
+				t.Fatalf("failed to unmarshal event: %v", err)
+			}
+			parsed := NewPolicy(evt)
+			if parsed == nil {
+				t.Fatal("NewPolicy returned nil")
+			}
+			if parsed.EntityOrHash() != policy.EntityOrHash() || parsed.Recommendation != policy.Recommendation ||
+				parsed.StateKey != policy.StateKey || parsed.Sender != policy.Sender || parsed.ID != policy.ID ||
+				parsed.RoomID != policy.RoomID || parsed.EntityType != policy.EntityType {
+				t.Errorf("NewPolicy(policy.Event()) = %+v; expected %+v", parsed, policy)
+			}
+		})
+	}
+	if NewPolicy(&event.Event{Type: event.StateMember, StateKey: new(string)}) != nil {
+		t.Error("NewPolicy accepted a non-policy event")
+	}
+}
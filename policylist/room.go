@@ -1,8 +1,10 @@
 package policylist
 
 import (
+	"cmp"
 	"slices"
 	"sync"
+	"sync/atomic"
 
 	"go.mau.fi/util/glob"
 	"maunium.net/go/mautrix/event"
@@ -24,6 +26,7 @@ type Room struct {
 	ServerRules *List
 	mapLock     sync.RWMutex
 	byEventID   map[id.EventID]typeStateKeyTuple
+	powerLevels atomic.Pointer[event.PowerLevelsEventContent]
 }
 
 // NewRoom creates a new store for a single policy room.
@@ -49,6 +52,19 @@ func (r *Room) GetServerRules() *List {
 	return r.ServerRules
 }
 
+// Policies returns all policies in the room sorted by entity type and entity.
+func (r *Room) Policies() []*Policy {
+	policies := slices.Concat(r.UserRules.All(), r.RoomRules.All(), r.ServerRules.All())
+	slices.SortFunc(policies, func(a, b *Policy) int {
+		return cmp.Or(
+			cmp.Compare(a.EntityType, b.EntityType),
+			cmp.Compare(a.EntityOrHash(), b.EntityOrHash()),
+			cmp.Compare(a.StateKey, b.StateKey),
+		)
+	})
+	return policies
+}
+
 type EntityType string
 
 func (et EntityType) EventType() event.Type {
@@ -103,13 +119,20 @@ func (r *Room) Update(evt *event.Event) (added, removed *Policy) {
 		}
 	}
 	if added != removed && removed != nil {
-		removed.Sender = evt.Sender
+		// Policies may still be referenced by old list snapshots, so don't modify them in place.
+		removedCopy := *removed
+		removedCopy.Sender = evt.Sender
+		removed = &removedCopy
 	}
 	return
 }
 
 // ParseState updates the state of this object with the given state events.
 func (r *Room) ParseState(state map[event.Type]map[string]*event.Event) *Room {
+	if evt, ok := state[event.StatePowerLevels][""]; ok {
+		content, _ := evt.Content.Parsed.(*event.PowerLevelsEventContent)
+		r.powerLevels.Store(content)
+	}
 	userPolicies := mergeUnstableEvents(state[event.StatePolicyUser], state[event.StateLegacyPolicyUser], state[event.StateUnstablePolicyUser])
 	roomPolicies := mergeUnstableEvents(state[event.StatePolicyRoom], state[event.StateLegacyPolicyRoom], state[event.StateUnstablePolicyRoom])
 	serverPolicies := mergeUnstableEvents(state[event.StatePolicyServer], state[event.StateLegacyPolicyServer], state[event.StateUnstablePolicyServer])
@@ -135,14 +158,31 @@ func mergeUnstableEvents(into map[string]*event.Event, sources ...map[string]*ev
 }
 
 func (r *Room) massUpdatePolicyList(input map[string]*event.Event, entityType EntityType, rules *List) {
-	for _, evt := range input {
-		r.updatePolicyList(evt, entityType, rules)
-	}
+	rules.update(func(s *listSnapshot) {
+		for _, evt := range input {
+			r.applyPolicyEvent(evt, entityType, s)
+		}
+	})
 }
 
 var HackyRuleFilter []string
 var HackyRuleFilterHashes [][util.HashSize]byte
 
+// isHackyFiltered checks whether the given policy is a ban that matches an entry in HackyRuleFilter.
+func isHackyFiltered(policy *Policy) bool {
+	if policy.Recommendation != event.PolicyRecommendationBan {
+		return false
+	} else if policy.EntityHash != nil {
+		return slices.Contains(HackyRuleFilterHashes, *policy.EntityHash)
+	}
+	for _, entry := range HackyRuleFilter {
+		if policy.Pattern.Match(entry) {
+			return true
+		}
+	}
+	return false
+}
+
 type hashGlob [util.HashSize]byte
 
 func (hg *hashGlob) Match(entity string) bool {
@@ -150,6 +190,13 @@ func (hg *hashGlob) Match(entity string) bool {
 }
 
 func (r *Room) updatePolicyList(evt *event.Event, entityType EntityType, rules *List) (added, removed *Policy) {
+	rules.update(func(s *listSnapshot) {
+		added, removed = r.applyPolicyEvent(evt, entityType, s)
+	})
+	return
+}
+
+func (r *Room) applyPolicyEvent(evt *event.Event, entityType EntityType, rules *listSnapshot) (added, removed *Policy) {
 	content, ok := evt.Content.Parsed.(*event.ModPolicyContent)
 	if !ok || evt.StateKey == nil {
 		return
@@ -157,18 +204,33 @@ func (r *Room) updatePolicyList(evt *event.Event, entityType EntityType, rules *
 	r.mapLock.Lock()
 	r.byEventID[evt.ID] = typeStateKeyTuple{Type: evt.Type, StateKey: *evt.StateKey}
 	r.mapLock.Unlock()
+	added = parsePolicy(evt, content, entityType)
+	if added == nil {
+		removed = rules.remove(evt.Type, *evt.StateKey)
+		return
+	}
+	var wasAdded bool
+	removed, wasAdded = rules.add(added)
+	if !wasAdded {
+		added = nil
+	}
+	return
+}
+
+// parsePolicy converts a policy event into a Policy.
+// Returns nil if the event doesn't contain a policy, i.e. if it removes the policy with the same state key.
+func parsePolicy(evt *event.Event, content *event.ModPolicyContent, entityType EntityType) *Policy {
 	var entityHash *[util.HashSize]byte
 	if content.Entity == "" && content.UnstableHashes != nil && len(content.UnstableHashes.SHA256) == util.Base64SHA256Length {
 		entityHash, _ = util.DecodeBase64Hash(content.UnstableHashes.SHA256)
 	}
 	if (content.Entity == "" && entityHash == nil) || content.Recommendation == "" {
-		removed = rules.Remove(evt.Type, *evt.StateKey)
-		return
+		return nil
 	}
 	if content.Recommendation == event.PolicyRecommendationUnstableBan {
 		content.Recommendation = event.PolicyRecommendationBan
 	}
-	added = &Policy{
+	policy := &Policy{
 		ModPolicyContent: content,
 		Pattern:          glob.Compile(content.Entity),
 		EntityHash:       entityHash,
@@ -181,23 +243,8 @@ func (r *Room) updatePolicyList(evt *event.Event, entityType EntityType, rules *
 		ID:               evt.ID,
 	}
 	if entityHash != nil {
-		added.Pattern = (*hashGlob)(entityHash)
-	}
-	if added.Recommendation == event.PolicyRecommendationBan {
-		if added.EntityHash != nil {
-			added.Ignored = slices.Contains(HackyRuleFilterHashes, *added.EntityHash)
-		} else {
-			for _, entry := range HackyRuleFilter {
-				if added.Pattern.Match(entry) {
-					added.Ignored = true
-				}
-			}
-		}
+		policy.Pattern = (*hashGlob)(entityHash)
 	}
-	var wasAdded bool
-	removed, wasAdded = rules.Add(added)
-	if !wasAdded {
-		added = nil
-	}
-	return
+	policy.Ignored = isHackyFiltered(policy)
+	return policy
 }
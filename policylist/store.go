@@ -1,10 +1,12 @@
 package policylist
 
 import (
+	"iter"
 	"maps"
 	"regexp"
 	"slices"
 	"sync"
+	"sync/atomic"
 
 	"go.mau.fi/util/glob"
 	"maunium.net/go/mautrix/event"
@@ -15,15 +17,43 @@ import (
 
 // Store is a collection of policy rooms that allows matching users, rooms, and servers
 // against the policies of any subset of rooms in the store.
+//
+// The room map is copy-on-write: readers load the current map without locking,
+// while writers copy it under roomsLock and atomically swap in the new version.
 type Store struct {
-	rooms     map[id.RoomID]*Room
-	roomsLock sync.RWMutex
+	rooms     atomic.Pointer[map[id.RoomID]*Room]
+	roomsLock sync.Mutex
 }
 
 // NewStore creates a new policy list store.
 func NewStore() *Store {
-	return &Store{
-		rooms: make(map[id.RoomID]*Room),
+	s := &Store{}
+	s.rooms.Store(&map[id.RoomID]*Room{})
+	return s
+}
+
+func (s *Store) getRooms() map[id.RoomID]*Room {
+	return *s.rooms.Load()
+}
+
+// iterRooms returns the given rooms that exist in the store, or all rooms if listIDs is nil.
+func (s *Store) iterRooms(listIDs []id.RoomID) iter.Seq[*Room] {
+	rooms := s.getRooms()
+	return func(yield func(*Room) bool) {
+		if listIDs == nil {
+			for _, room := range rooms {
+				if !yield(room) {
+					return
+				}
+			}
+			return
+		}
+		for _, roomID := range listIDs {
+			room, ok := rooms[roomID]
+			if ok && !yield(room) {
+				return
+			}
+		}
 	}
 }
 
@@ -66,8 +96,8 @@ func (s *Store) MatchServer(listIDs []id.RoomID, serverName string) Match {
 	return s.match(listIDs, serverName, (*Room).GetServerRules)
 }
 
-func (s *Store) ListServerRules(listIDs []id.RoomID) map[string]*Policy {
-	return s.compileList(listIDs, (*Room).GetServerRules)
+func (s *Store) ListServerRules(listIDs []id.RoomID, modes ListModes, trust ListTrust) map[string]*Policy {
+	return s.compileList(listIDs, modes, trust, (*Room).GetServerRules)
 }
 
 // Update updates the store with the given policy event.
@@ -88,9 +118,7 @@ func (s *Store) Update(evt *event.Event) (added, removed *Policy) {
 	default:
 		return
 	}
-	s.roomsLock.RLock()
-	list, ok := s.rooms[evt.RoomID]
-	s.roomsLock.RUnlock()
+	list, ok := s.getRooms()[evt.RoomID]
 	if !ok {
 		return
 	}
@@ -101,60 +129,43 @@ func (s *Store) Update(evt *event.Event) (added, removed *Policy) {
 //
 // This will always replace the existing state for the given room, even if it already exists.
 //
-// To ensure the store doesn't contain partial state, the store is locked for the duration of the parsing.
+// The state is fully parsed before the room is published, so readers never see partial state.
 func (s *Store) Add(roomID id.RoomID, state map[event.Type]map[string]*event.Event) {
+	room := NewRoom(roomID).ParseState(state)
 	s.roomsLock.Lock()
-	s.rooms[roomID] = NewRoom(roomID).ParseState(state)
+	newRooms := maps.Clone(s.getRooms())
+	newRooms[roomID] = room
+	s.rooms.Store(&newRooms)
 	s.roomsLock.Unlock()
 }
 
+// GetRoom returns the policies of the given room, or nil if the room isn't in the store.
+func (s *Store) GetRoom(roomID id.RoomID) *Room {
+	return s.getRooms()[roomID]
+}
+
 func (s *Store) Contains(roomID id.RoomID) bool {
-	s.roomsLock.RLock()
-	_, ok := s.rooms[roomID]
-	s.roomsLock.RUnlock()
+	_, ok := s.getRooms()[roomID]
 	return ok
 }
 
 func (s *Store) match(listIDs []id.RoomID, entity string, listGetter func(*Room) *List) (output Match) {
-	if listIDs == nil {
-		s.roomsLock.Lock()
-		listIDs = slices.Collect(maps.Keys(s.rooms))
-		s.roomsLock.Unlock()
-	}
-	for _, roomID := range listIDs {
-		s.roomsLock.RLock()
-		list, ok := s.rooms[roomID]
-		s.roomsLock.RUnlock()
-		if !ok {
-			continue
-		}
-		rules := listGetter(list)
-		output = append(output, rules.Match(entity)...)
+	for room := range s.iterRooms(listIDs) {
+		output = append(output, listGetter(room).Match(entity)...)
 	}
 	return
 }
 
 func (s *Store) matchExactFunc(listIDs []id.RoomID, entityType EntityType, fn func(*List) Match) (output Match) {
-	if listIDs == nil {
-		s.roomsLock.Lock()
-		listIDs = slices.Collect(maps.Keys(s.rooms))
-		s.roomsLock.Unlock()
-	}
-	for _, roomID := range listIDs {
-		s.roomsLock.RLock()
-		list, ok := s.rooms[roomID]
-		s.roomsLock.RUnlock()
-		if !ok {
-			continue
-		}
+	for room := range s.iterRooms(listIDs) {
 		var rules *List
 		switch entityType {
 		case EntityTypeUser:
-			rules = list.GetUserRules()
+			rules = room.GetUserRules()
 		case EntityTypeRoom:
-			rules = list.GetRoomRules()
+			rules = room.GetRoomRules()
 		case EntityTypeServer:
-			rules = list.GetServerRules()
+			rules = room.GetServerRules()
 		}
 		output = append(output, fn(rules)...)
 	}
@@ -174,42 +185,32 @@ func (s *Store) MatchHash(listIDs []id.RoomID, entityType EntityType, entity [ut
 }
 
 func (s *Store) Search(listIDs []id.RoomID, entity string) (output Match) {
-	if listIDs == nil {
-		s.roomsLock.Lock()
-		listIDs = slices.Collect(maps.Keys(s.rooms))
-		s.roomsLock.Unlock()
-	}
 	entityGlob := glob.Compile(entity)
-	for _, roomID := range listIDs {
-		s.roomsLock.RLock()
-		list, ok := s.rooms[roomID]
-		s.roomsLock.RUnlock()
-		if !ok {
-			continue
-		}
-		output = append(output, list.GetUserRules().Search(entity, entityGlob)...)
-		output = append(output, list.GetRoomRules().Search(entity, entityGlob)...)
-		output = append(output, list.GetServerRules().Search(entity, entityGlob)...)
+	for room := range s.iterRooms(listIDs) {
+		output = append(output, room.GetUserRules().Search(entity, entityGlob)...)
+		output = append(output, room.GetRoomRules().Search(entity, entityGlob)...)
+		output = append(output, room.GetServerRules().Search(entity, entityGlob)...)
 	}
 	return
 }
 
-func (s *Store) compileList(listIDs []id.RoomID, listGetter func(*Room) *List) (output map[string]*Policy) {
+func (s *Store) compileList(listIDs []id.RoomID, modes ListModes, trust ListTrust, listGetter func(*Room) *List) (output map[string]*Policy) {
 	output = make(map[string]*Policy)
-	// Iterate the list backwards so that entries in higher priority lists overwrite lower priority ones
-	for _, roomID := range slices.Backward(listIDs) {
-		s.roomsLock.RLock()
-		list, ok := s.rooms[roomID]
-		s.roomsLock.RUnlock()
-		if !ok {
-			continue
-		}
-		rules := listGetter(list)
-		rules.lock.RLock()
-		for _, policy := range rules.byEntity {
-			output[policy.Entity] = policy.Policy
+	rooms := s.getRooms()
+	// Advisory lists are compiled first so that any other list overrides them regardless of priority
+	for _, advisoryPass := range []bool{true, false} {
+		// Iterate the list backwards so that entries in higher priority lists overwrite lower priority ones
+		for _, roomID := range slices.Backward(listIDs) {
+			room, ok := rooms[roomID]
+			if !ok || (modes[roomID] == MergeModeAdvisory) != advisoryPass {
+				continue
+			}
+			for policy := range listGetter(room).snapshot.Load().byEntity.Values() {
+				if modes.Applies(policy) && s.Trusts(trust, policy) {
+					output[policy.Entity] = policy
+				}
+			}
 		}
-		rules.lock.RUnlock()
 	}
 	return
 }
@@ -0,0 +1,120 @@
+package policylist
+
+import (
+	"slices"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// SenderTrust restricts which senders are allowed to set policies in a policy room.
+//
+// A sender is trusted if they're in AllowedSenders or their power level is at least MinPowerLevel.
+// If neither field is set, everyone who can send policy events is trusted.
+type SenderTrust struct {
+	AllowedSenders []id.UserID
+	MinPowerLevel  *int
+}
+
+// IsEmpty returns true if the trust settings don't restrict senders at all.
+func (st *SenderTrust) IsEmpty() bool {
+	return st == nil || (len(st.AllowedSenders) == 0 && st.MinPowerLevel == nil)
+}
+
+// Trusts checks whether the given sender is trusted based on these settings and the room's power levels.
+func (st *SenderTrust) Trusts(sender id.UserID, pl *event.PowerLevelsEventContent) bool {
+	if st.IsEmpty() || slices.Contains(st.AllowedSenders, sender) {
+		return true
+	}
+	return st.MinPowerLevel != nil && pl != nil && pl.GetUserLevel(sender) >= *st.MinPowerLevel
+}
+
+// Equal checks whether the two trust settings trust the same senders.
+func (st *SenderTrust) Equal(other *SenderTrust) bool {
+	if st.IsEmpty() || other.IsEmpty() {
+		return st.IsEmpty() == other.IsEmpty()
+	}
+	return slices.Equal(st.AllowedSenders, other.AllowedSenders) &&
+		(st.MinPowerLevel == nil) == (other.MinPowerLevel == nil) &&
+		(st.MinPowerLevel == nil || *st.MinPowerLevel == *other.MinPowerLevel)
+}
+
+// ListTrust maps policy list room IDs to their sender trust settings. Lists that aren't in the map trust everyone.
+//
+// Trust settings are owned by whoever evaluates the policies (i.e. a management room),
+// so they're passed in when evaluating rather than stored on the policies themselves.
+type ListTrust map[id.RoomID]*SenderTrust
+
+// TrustChange is a policy whose trust status changed because of new trust settings or power levels.
+type TrustChange struct {
+	Policy  *Policy
+	Trusted bool
+}
+
+// PowerLevels returns the current power levels of the policy room, or nil if they're not known.
+func (r *Room) PowerLevels() *event.PowerLevelsEventContent {
+	return r.powerLevels.Load()
+}
+
+// TrustChanges finds all policies in the room whose trust status is different
+// between the old and new trust settings and power levels.
+func (r *Room) TrustChanges(oldTrust, newTrust *SenderTrust, oldPL, newPL *event.PowerLevelsEventContent) (changes []TrustChange) {
+	if oldTrust.IsEmpty() && newTrust.IsEmpty() {
+		return nil
+	}
+	for _, policy := range r.Policies() {
+		if policy.Ignored {
+			continue
+		}
+		wasTrusted := oldTrust.Trusts(policy.Sender, oldPL)
+		isTrusted := newTrust.Trusts(policy.Sender, newPL)
+		if wasTrusted != isTrusted {
+			changes = append(changes, TrustChange{Policy: policy, Trusted: isTrusted})
+		}
+	}
+	return
+}
+
+// Trusts checks whether the sender of the given policy is trusted by the settings of the policy's list,
+// using the current power levels of the policy room.
+func (s *Store) Trusts(trust ListTrust, policy *Policy) bool {
+	st := trust[policy.RoomID]
+	if st.IsEmpty() {
+		return true
+	}
+	var pl *event.PowerLevelsEventContent
+	if room := s.GetRoom(policy.RoomID); room != nil {
+		pl = room.PowerLevels()
+	}
+	return st.Trusts(policy.Sender, pl)
+}
+
+// FilterTrusted returns the policies in the match that are from trusted senders.
+// The original match is not modified.
+func (s *Store) FilterTrusted(trust ListTrust, match Match) Match {
+	if len(trust) == 0 {
+		return match
+	}
+	return slices.DeleteFunc(slices.Clone(match), func(policy *Policy) bool {
+		return !s.Trusts(trust, policy)
+	})
+}
+
+// UpdatePowerLevels updates the power levels of a policy room in the store.
+//
+// The room and its previous power levels are returned, so callers can find policies whose trust status changed.
+// If the room isn't in the store, the returned room is nil.
+func (s *Store) UpdatePowerLevels(evt *event.Event) (room *Room, prevPL *event.PowerLevelsEventContent) {
+	if evt.Type != event.StatePowerLevels || evt.GetStateKey() != "" {
+		return nil, nil
+	}
+	room, ok := s.getRooms()[evt.RoomID]
+	if !ok {
+		return nil, nil
+	}
+	content, ok := evt.Content.Parsed.(*event.PowerLevelsEventContent)
+	if !ok {
+		return nil, nil
+	}
+	return room, room.powerLevels.Swap(content)
+}
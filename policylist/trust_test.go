@@ -0,0 +1,75 @@
+package policylist
+
+import (
+	"slices"
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestStore_FilterTrusted(t *testing.T) {
+	const list id.RoomID = "!list:example.com"
+	store := NewStore()
+	store.Add(list, nil)
+	store.GetRoom(list).powerLevels.Store(&event.PowerLevelsEventContent{
+		Users: map[id.UserID]int{"@mod:example.com": 50, "@admin:example.com": 100},
+	})
+	policy := func(sender id.UserID) *Policy {
+		p := makePolicy("@spam:example.com", sender.String(), event.PolicyRecommendationBan)
+		p.RoomID = list
+		p.Sender = sender
+		return p
+	}
+	admin, mod, user := policy("@admin:example.com"), policy("@mod:example.com"), policy("@user:example.com")
+	match := Match{admin, mod, user}
+	minPL := func(level int) *int { return &level }
+
+	tests := []struct {
+		name     string
+		trust    ListTrust
+		expected Match
+	}{
+		{"no settings", nil, match},
+		{"empty settings", ListTrust{list: {}}, match},
+		{"other list", ListTrust{"!other:example.com": {MinPowerLevel: minPL(100)}}, match},
+		{"power level", ListTrust{list: {MinPowerLevel: minPL(50)}}, Match{admin, mod}},
+		{"allowed senders", ListTrust{list: {AllowedSenders: []id.UserID{"@user:example.com"}}}, Match{user}},
+		{"either", ListTrust{list: {AllowedSenders: []id.UserID{"@user:example.com"}, MinPowerLevel: minPL(100)}}, Match{admin, user}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := store.FilterTrusted(test.trust, match); !slices.Equal(actual, test.expected) {
+				t.Errorf("FilterTrusted() = %v; expected %v", actual, test.expected)
+			}
+		})
+	}
+	if len(match) != 3 || match[2] != user {
+		t.Error("FilterTrusted modified the original match")
+	}
+}
+
+func TestStore_LintUntrusted(t *testing.T) {
+	const list id.RoomID = "!list:example.com"
+	store := NewStore()
+	store.Add(list, nil)
+	trusted := makePolicy("@spam:example.com", "trusted", event.PolicyRecommendationBan)
+	trusted.Sender = "@mod:example.com"
+	untrusted := makePolicy("@spam:example.com", "untrusted", event.PolicyRecommendationUnban)
+	untrusted.Sender = "@user:example.com"
+	store.GetRoom(list).GetUserRules().update(func(s *listSnapshot) {
+		s.add(trusted)
+		s.add(untrusted)
+	})
+	trust := ListTrust{list: {AllowedSenders: []id.UserID{"@mod:example.com"}}}
+
+	issues := store.Lint([]id.RoomID{list}, nil, trust)
+	if len(issues) != 1 || issues[0].Type != LintUntrusted || issues[0].Policies[0] != untrusted {
+		t.Fatalf("Lint() = %+v; expected only an untrusted issue for the unban", issues)
+	}
+	// Without trust settings, the same policies conflict
+	issues = store.Lint([]id.RoomID{list}, nil, nil)
+	if len(issues) != 1 || issues[0].Type != LintConflict {
+		t.Errorf("Lint() without trust = %+v; expected a conflict", issues)
+	}
+}